@@ -0,0 +1,28 @@
+package bot
+
+import (
+	"context"
+	"github.com/spf13/cast"
+	"time"
+	"todo-reminder/gocq"
+	"todo-reminder/model"
+	"todo-reminder/util"
+)
+
+func init() {
+	gocq.RegisterMessageHandler(handleReminderReply)
+	gocq.RegisterMessageHandler(createTodo)
+}
+
+// getUser 获取发送消息的用户，不是已同步的好友或者被禁用时返回 false
+func getUser(ctx context.Context, event *gocq.EventBody) (model.User, bool) {
+	user, err := model.CUser.GetByUserId(ctx, cast.ToString(event.UserId))
+	if err != nil || !user.IsEnabled {
+		return model.User{}, false
+	}
+	return user, true
+}
+
+func getUserNow(user model.User) time.Time {
+	return time.Now().In(util.LoadLocation(user.Timezone))
+}
@@ -0,0 +1,231 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"github.com/spf13/cast"
+	"strings"
+	"time"
+	"todo-reminder/gocq"
+	"todo-reminder/model"
+	"todo-reminder/repository/bsoncodec"
+)
+
+const (
+	listPerPage = 10
+)
+
+var (
+	durationReplacer = strings.NewReplacer("分钟", "m", "小时", "h", "天", "d")
+)
+
+func init() {
+	gocq.RegisterCommand("list", "/list [页码] 查看未完成的待办", listRecords)
+	gocq.RegisterCommand("today", "/today 查看今天的待办", listTodayRecords)
+	gocq.RegisterCommand("done", "/done <序号> 完成待办", doneRecord)
+	gocq.RegisterCommand("undo", "/undo <序号> 撤销完成", undoRecord)
+	gocq.RegisterCommand("delay", "/delay <序号> <时长> 推迟提醒，如 /delay 1 30m", delayRecord)
+	gocq.RegisterCommand("delete", "/delete <序号> 删除待办", deleteRecord)
+}
+
+func listRecords(ctx context.Context, event *gocq.EventBody, args []string) string {
+	user, ok := getUser(ctx, event)
+	if !ok {
+		return "请先添加机器人为好友"
+	}
+	page := int64(1)
+	if len(args) > 0 {
+		page = cast.ToInt64(args[0])
+		if page < 1 {
+			return "页码无效"
+		}
+	}
+	condition := model.GenUserRecordsCondition(user.UserId)
+	condition["isDeleted"] = false
+	condition["hasBeenDone"] = false
+	total, records, err := model.CTodoRecord.ListByPagination(ctx, condition, page, listPerPage, []string{"remindAt"})
+	if err != nil {
+		return fmt.Sprintf("查询失败：%s", err.Error())
+	}
+	if len(records) == 0 {
+		return "没有未完成的待办"
+	}
+	reply, err := formatRecordList(ctx, user, records)
+	if err != nil {
+		return fmt.Sprintf("查询失败：%s", err.Error())
+	}
+	totalPage := (total + listPerPage - 1) / listPerPage
+	return fmt.Sprintf("未完成的待办（第 %d/%d 页）：\n%s", page, totalPage, reply)
+}
+
+func listTodayRecords(ctx context.Context, event *gocq.EventBody, args []string) string {
+	user, ok := getUser(ctx, event)
+	if !ok {
+		return "请先添加机器人为好友"
+	}
+	now := getUserNow(user)
+	start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	condition := model.GenUserRecordsCondition(user.UserId)
+	condition["isDeleted"] = false
+	condition["needRemind"] = true
+	condition["remindAt"] = bsoncodec.M{
+		"$gte": start,
+		"$lt":  start.AddDate(0, 0, 1),
+	}
+	_, records, err := model.CTodoRecord.ListByPagination(ctx, condition, 1, 100, []string{"remindAt"})
+	if err != nil {
+		return fmt.Sprintf("查询失败：%s", err.Error())
+	}
+	if len(records) == 0 {
+		return "今天没有待办"
+	}
+	reply, err := formatRecordList(ctx, user, records)
+	if err != nil {
+		return fmt.Sprintf("查询失败：%s", err.Error())
+	}
+	return fmt.Sprintf("今天的待办：\n%s", reply)
+}
+
+// formatRecordList 格式化待办列表，同时保存序号以便后续命令使用
+func formatRecordList(ctx context.Context, user model.User, records []model.TodoRecord) (string, error) {
+	now := getUserNow(user)
+	recordIds := make([]bsoncodec.ObjectId, 0, len(records))
+	lines := make([]string, 0, len(records))
+	for i, record := range records {
+		recordIds = append(recordIds, record.Id)
+		line := fmt.Sprintf("%d. %s", i+1, record.Content)
+		if record.NeedRemind {
+			line = fmt.Sprintf("%s（%s）", line, formatRemindAt(record.RemindAt.In(now.Location()), now))
+		}
+		if record.HasBeenDone {
+			line = fmt.Sprintf("%s [已完成]", line)
+		}
+		lines = append(lines, line)
+	}
+	if err := model.CRecordAlias.Save(ctx, user.UserId, recordIds); err != nil {
+		return "", err
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// getRecordByAlias 根据最近一次列表中的序号获取待办记录，失败时返回需要回复的内容
+func getRecordByAlias(ctx context.Context, event *gocq.EventBody, args []string) (model.User, model.TodoRecord, string) {
+	user, ok := getUser(ctx, event)
+	if !ok {
+		return user, model.TodoRecord{}, "请先添加机器人为好友"
+	}
+	if len(args) == 0 {
+		return user, model.TodoRecord{}, "请指定序号，序号可以通过 /list 或 /today 查看"
+	}
+	recordId, err := model.CRecordAlias.GetRecordId(ctx, user.UserId, cast.ToInt(args[0]))
+	if err != nil {
+		return user, model.TodoRecord{}, fmt.Sprintf("序号 %s 不存在，请先通过 /list 或 /today 查看", args[0])
+	}
+	record, err := model.CTodoRecord.GetById(ctx, recordId)
+	if err != nil || record.IsDeleted || (!record.IsOwner(user.UserId) && !record.IsAssignee(user.UserId)) {
+		return user, model.TodoRecord{}, "待办不存在或已被删除"
+	}
+	return user, record, ""
+}
+
+// getOwnRecordByAlias 删除和推迟会影响所有成员，只有创建者可以操作，与网页端保持一致
+func getOwnRecordByAlias(ctx context.Context, event *gocq.EventBody, args []string) (model.User, model.TodoRecord, string) {
+	user, record, reply := getRecordByAlias(ctx, event, args)
+	if reply == "" && !record.IsOwner(user.UserId) {
+		return user, model.TodoRecord{}, "待办不存在或已被删除"
+	}
+	return user, record, reply
+}
+
+func doneRecord(ctx context.Context, event *gocq.EventBody, args []string) string {
+	user, record, reply := getRecordByAlias(ctx, event, args)
+	if reply != "" {
+		return reply
+	}
+	return done(ctx, record, user.UserId)
+}
+
+// done 创建者完成整条记录，被指派的成员只完成自己的部分
+func done(ctx context.Context, record model.TodoRecord, userId string) string {
+	if record.HasBeenDone {
+		return fmt.Sprintf("「%s」已经完成了", record.Content)
+	}
+	if err := model.CTodoRecord.DoneByUser(ctx, record, userId); err != nil {
+		return fmt.Sprintf("操作失败：%s", err.Error())
+	}
+	return fmt.Sprintf("已完成「%s」", record.Content)
+}
+
+func undoRecord(ctx context.Context, event *gocq.EventBody, args []string) string {
+	user, record, reply := getRecordByAlias(ctx, event, args)
+	if reply != "" {
+		return reply
+	}
+	var err error
+	if !record.IsOwner(user.UserId) && record.IsAssignee(user.UserId) {
+		err = model.CTodoRecord.UndoByAssignee(ctx, record.Id, user.UserId)
+	} else {
+		err = model.CTodoRecord.Undo(ctx, record.Id)
+	}
+	if err != nil {
+		return fmt.Sprintf("操作失败：%s", err.Error())
+	}
+	return fmt.Sprintf("已撤销完成「%s」", record.Content)
+}
+
+func deleteRecord(ctx context.Context, event *gocq.EventBody, args []string) string {
+	_, record, reply := getOwnRecordByAlias(ctx, event, args)
+	if reply != "" {
+		return reply
+	}
+	if err := model.CTodoRecord.Delete(ctx, record.Id); err != nil {
+		return fmt.Sprintf("操作失败：%s", err.Error())
+	}
+	return fmt.Sprintf("已删除「%s」", record.Content)
+}
+
+func delayRecord(ctx context.Context, event *gocq.EventBody, args []string) string {
+	user, record, reply := getOwnRecordByAlias(ctx, event, args)
+	if reply != "" {
+		return reply
+	}
+	if !record.NeedRemind || record.HasBeenDone {
+		return fmt.Sprintf("「%s」不需要提醒", record.Content)
+	}
+	if len(args) < 2 {
+		return "请指定推迟的时长，如 /delay 1 30m"
+	}
+	duration, err := parseDelayDuration(args[1])
+	if err != nil || duration <= 0 {
+		return fmt.Sprintf("时长 %s 无效，支持 30m、2h、1d 这样的格式", args[1])
+	}
+	remindAt, err := delay(ctx, record, duration)
+	if err != nil {
+		return fmt.Sprintf("操作失败：%s", err.Error())
+	}
+	now := getUserNow(user)
+	return fmt.Sprintf("已将「%s」推迟到 %s", record.Content, formatRemindAt(remindAt.In(now.Location()), now))
+}
+
+// delay 从现在和原提醒时间中较晚的一个开始推迟，返回新的提醒时间
+func delay(ctx context.Context, record model.TodoRecord, duration time.Duration) (time.Time, error) {
+	from := record.RemindAt
+	if now := time.Now(); now.After(from) {
+		from = now
+	}
+	remindAt := from.Add(duration).Truncate(time.Minute)
+	return remindAt, delayTo(ctx, record, remindAt)
+}
+
+func delayTo(ctx context.Context, record model.TodoRecord, remindAt time.Time) error {
+	return model.CTodoRecord.Delay(ctx, record.Id, remindAt.Sub(record.RemindAt))
+}
+
+// parseDelayDuration 解析 30m、1h30m、1d、30分钟 这样的时长
+func parseDelayDuration(str string) (time.Duration, error) {
+	str = durationReplacer.Replace(strings.ToLower(str))
+	if strings.HasSuffix(str, "d") {
+		return time.Duration(cast.ToInt64(strings.TrimSuffix(str, "d"))) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(str)
+}
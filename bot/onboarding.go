@@ -0,0 +1,42 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"github.com/spf13/cast"
+	"todo-reminder/gocq"
+	"todo-reminder/log"
+	"todo-reminder/model"
+)
+
+func init() {
+	gocq.RegisterFriendRequestHandler(approveFriendRequest)
+	gocq.RegisterNoticeHandler(welcomeNewFriend)
+}
+
+func approveFriendRequest(ctx context.Context, event *gocq.EventBody) bool {
+	approve := model.CanAutoApproveFriend(ctx, cast.ToString(event.UserId), event.Comment)
+	// 验证信息中可能包含邀请码，不记录日志
+	log.Info("Received friend request", map[string]interface{}{
+		"userId":  event.UserId,
+		"approve": approve,
+	})
+	return approve
+}
+
+// welcomeNewFriend 添加好友后立即创建用户并发送欢迎消息，不必等待定时同步好友列表
+func welcomeNewFriend(ctx context.Context, event *gocq.EventBody) error {
+	if event.NoticeType != gocq.NOTICE_TYPE_FRIEND_ADD {
+		return nil
+	}
+	userId := cast.ToString(event.UserId)
+	user, err := model.CUser.Onboard(ctx, userId)
+	if err != nil {
+		return err
+	}
+	// 被管理员禁用的用户重新添加好友时不发送欢迎消息
+	if !user.IsEnabled {
+		return nil
+	}
+	return model.CUser.SendWelcome(ctx, userId, fmt.Sprintf("welcome:%s:%d", userId, event.UnixTime))
+}
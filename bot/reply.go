@@ -0,0 +1,60 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"github.com/spf13/cast"
+	"strings"
+	"todo-reminder/gocq"
+	"todo-reminder/model"
+	"todo-reminder/util"
+)
+
+const (
+	replyUsageMessage = "回复“完成”标记为已完成，回复“10m”、“半小时后”、“明天”等推迟提醒"
+)
+
+var (
+	doneWords = []string{"done", "ok", "d", "完成", "已完成", "做完了", "好了", "搞定", "✓", "√"}
+)
+
+// handleReminderReply 处理对提醒消息的回复，回复“完成”时完成待办，回复时长或时间时推迟提醒
+// 被指派的成员只能完成自己的部分，不能推迟提醒
+func handleReminderReply(ctx context.Context, event *gocq.EventBody, content string) (string, bool) {
+	messageId, ok := gocq.GetReplyMessageId(event.RawMessage)
+	if !ok {
+		return "", false
+	}
+	// 群待办中被指派的成员不一定是机器人的好友，因此不要求是已同步的用户
+	userId := cast.ToString(event.UserId)
+	record, err := model.CTodoRecord.GetByMessageId(ctx, userId, messageId)
+	if err != nil {
+		return "", false
+	}
+	content = strings.TrimSpace(content)
+	if util.StrInArray(strings.ToLower(content), &doneWords) {
+		return done(ctx, record, userId), true
+	}
+	if !record.IsOwner(userId) {
+		return "待办不存在或已被删除", true
+	}
+	if record.HasBeenDone {
+		return fmt.Sprintf("「%s」已经完成了，无法推迟", record.Content), true
+	}
+	now := record.Now()
+	if duration, err := parseDelayDuration(content); err == nil && duration > 0 {
+		remindAt, err := delay(ctx, record, duration)
+		if err != nil {
+			return fmt.Sprintf("操作失败：%s", err.Error()), true
+		}
+		return fmt.Sprintf("已将「%s」推迟到 %s", record.Content, formatRemindAt(remindAt.In(now.Location()), now)), true
+	}
+	remindAt, err := ParseTime(content, now)
+	if err != nil || !remindAt.After(now) {
+		return replyUsageMessage, true
+	}
+	if err := delayTo(ctx, record, remindAt); err != nil {
+		return fmt.Sprintf("操作失败：%s", err.Error()), true
+	}
+	return fmt.Sprintf("已将「%s」推迟到 %s", record.Content, formatRemindAt(remindAt, now)), true
+}
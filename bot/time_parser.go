@@ -0,0 +1,599 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"todo-reminder/model"
+)
+
+const (
+	// 只有日期没有具体时间时默认的提醒时间
+	defaultRemindHour = 9
+
+	period_morning = "morning"
+	period_noon    = "noon"
+	period_evening = "evening"
+	period_dawn    = "dawn"
+)
+
+var (
+	ErrNoTimeFound    = errors.New("no time found")
+	ErrMissingContent = errors.New("missing content")
+
+	numPattern     = `(\d+|[零〇一二两三四五六七八九十]+)`
+	dayNamePattern = `(?:mon(?:day)?|tue(?:s|sday)?|wed(?:nesday)?|thu(?:rs|rsday)?|fri(?:day)?|sat(?:urday)?|sun(?:day)?)s?\b`
+
+	weekdayMap = map[string]time.Weekday{
+		"一": time.Monday, "二": time.Tuesday, "三": time.Wednesday, "四": time.Thursday,
+		"五": time.Friday, "六": time.Saturday, "日": time.Sunday, "天": time.Sunday,
+		"1": time.Monday, "2": time.Tuesday, "3": time.Wednesday, "4": time.Thursday,
+		"5": time.Friday, "6": time.Saturday, "7": time.Sunday,
+		"mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday, "thu": time.Thursday,
+		"fri": time.Friday, "sat": time.Saturday, "sun": time.Sunday,
+	}
+	rruleWeekdays = map[time.Weekday]string{
+		time.Monday: "MO", time.Tuesday: "TU", time.Wednesday: "WE", time.Thursday: "TH",
+		time.Friday: "FR", time.Saturday: "SA", time.Sunday: "SU",
+	}
+	periodMap = map[string]string{
+		"凌晨": period_dawn, "早上": period_morning, "早晨": period_morning, "上午": period_morning, "早": period_morning,
+		"中午": period_noon, "下午": period_evening, "傍晚": period_evening, "晚上": period_evening, "晚": period_evening,
+		"am": period_morning, "pm": period_evening,
+	}
+
+	// 去掉时间表达式后剩下的无意义的词
+	fillerPatterns = []*regexp.Regexp{
+		regexp.MustCompile(`^(?i)\s*(请|麻烦)?(提醒我|提醒|叫我|通知我|记得)\s*`),
+		regexp.MustCompile(`^(?i)\s*remind\s+me\s+(to\s+)?`),
+		regexp.MustCompile(`^(?i)\s*(to|at|on)\s+`),
+		regexp.MustCompile(`^[\s，,。.、:：的]+|[\s，,。.、:：的]+$`),
+	}
+)
+
+// ParsedTodo 从自然语言中解析出的待办
+type ParsedTodo struct {
+	Content      string
+	RemindAt     time.Time
+	IsRepeatable bool
+	// IsRepeatable 为 true 时有效
+	RepeatSetting model.RepeatSetting
+}
+
+type timeParser struct {
+	text string
+	now  time.Time
+
+	hasDate bool
+	date    time.Time
+	// 仅指定了星期几，如“周五”、“friday”
+	hasWeekday bool
+	weekday    time.Weekday
+
+	hasTime bool
+	hour    int
+	minute  int
+	period  string
+
+	hasRelative bool
+	relative    time.Duration
+
+	isRepeatable  bool
+	repeatSetting model.RepeatSetting
+}
+
+type matchRule struct {
+	pattern *regexp.Regexp
+	handle  func(p *timeParser, groups []string)
+}
+
+// ParseTodo 用规则从中文或英文的自然语言中解析出提醒时间和待办内容，now 决定了解析时使用的时区
+func ParseTodo(text string, now time.Time) (*ParsedTodo, error) {
+	p, err := parse(text, now)
+	if err != nil {
+		return nil, err
+	}
+	content := strings.Join(strings.Fields(p.text), " ")
+	for _, pattern := range fillerPatterns {
+		content = pattern.ReplaceAllString(content, "")
+	}
+	if content == "" {
+		return nil, ErrMissingContent
+	}
+	return &ParsedTodo{
+		Content:       content,
+		RemindAt:      p.getRemindAt(),
+		IsRepeatable:  p.isRepeatable,
+		RepeatSetting: p.repeatSetting,
+	}, nil
+}
+
+// ParseTime 只解析提醒时间，用于“明天”、“10分钟后”这样没有待办内容的消息
+func ParseTime(text string, now time.Time) (time.Time, error) {
+	p, err := parse(text, now)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return p.getRemindAt(), nil
+}
+
+func parse(text string, now time.Time) (*timeParser, error) {
+	p := &timeParser{
+		text: " " + strings.TrimSpace(text) + " ",
+		now:  now,
+	}
+	matched := p.match(repeatRules)
+	relative := p.match(relativeRules)
+	if !relative {
+		matched = p.match(dateRules) || matched
+		matched = p.match(timeRules) || matched
+	}
+	if !matched && !relative {
+		return nil, ErrNoTimeFound
+	}
+	return p, nil
+}
+
+// match 依次匹配规则，匹配到的部分会从文本中去掉，每条规则只匹配一次
+func (p *timeParser) match(rules []matchRule) bool {
+	matched := false
+	for _, rule := range rules {
+		loc := rule.pattern.FindStringSubmatchIndex(p.text)
+		if loc == nil {
+			continue
+		}
+		groups := make([]string, len(loc)/2)
+		for i := range groups {
+			if loc[2*i] >= 0 {
+				groups[i] = p.text[loc[2*i]:loc[2*i+1]]
+			}
+		}
+		p.text = p.text[:loc[0]] + " " + p.text[loc[1]:]
+		rule.handle(p, groups)
+		matched = true
+	}
+	return matched
+}
+
+func (p *timeParser) getRemindAt() time.Time {
+	if p.hasRelative {
+		return p.now.Add(p.relative).Truncate(time.Minute)
+	}
+	hour, minute := defaultRemindHour, 0
+	if p.hasTime {
+		hour, minute = adjustHour(p.hour, p.period), p.minute
+	} else {
+		switch p.period {
+		case period_dawn:
+			hour = 6
+		case period_morning:
+			hour = 8
+		case period_noon:
+			hour = 12
+		case period_evening:
+			hour = 20
+		}
+	}
+	date := p.now
+	if p.hasDate {
+		date = p.date
+	}
+	remindAt := time.Date(date.Year(), date.Month(), date.Day(), hour, minute, 0, 0, p.now.Location())
+	// 重复的待办由重复规则计算出第一次提醒的时间
+	if p.isRepeatable || p.hasDate {
+		return remindAt
+	}
+	if p.hasWeekday {
+		remindAt = remindAt.AddDate(0, 0, (int(p.weekday)-int(remindAt.Weekday())+7)%7)
+		if !remindAt.After(p.now) {
+			remindAt = remindAt.AddDate(0, 0, 7)
+		}
+		return remindAt
+	}
+	if !remindAt.After(p.now) {
+		remindAt = remindAt.AddDate(0, 0, 1)
+	}
+	return remindAt
+}
+
+func (p *timeParser) setDate(year int, month time.Month, day int) {
+	p.hasDate = true
+	p.date = time.Date(year, month, day, 0, 0, 0, 0, p.now.Location())
+}
+
+func (p *timeParser) setDateOffset(days int) {
+	p.hasDate = true
+	p.date = p.now.AddDate(0, 0, days)
+}
+
+func (p *timeParser) setRepeat(repeatType string, interval int) {
+	p.isRepeatable = true
+	p.repeatSetting = model.RepeatSetting{
+		Type:       repeatType,
+		DateOffset: interval,
+	}
+}
+
+func (p *timeParser) setRRule(format string, args ...interface{}) {
+	p.isRepeatable = true
+	p.repeatSetting = model.RepeatSetting{
+		Type:  model.REPEAT_TYPE_RRULE,
+		RRule: fmt.Sprintf(format, args...),
+	}
+}
+
+func (p *timeParser) setTime(hour, minute int, period string) {
+	p.hasTime = true
+	p.hour = hour
+	p.minute = minute
+	if period != "" {
+		p.period = periodMap[strings.ToLower(period)]
+	}
+}
+
+func adjustHour(hour int, period string) int {
+	switch period {
+	case period_evening:
+		if hour < 12 {
+			return hour + 12
+		}
+	case period_noon:
+		if hour < 6 {
+			return hour + 12
+		}
+	case period_morning, period_dawn:
+		if hour == 12 {
+			return 0
+		}
+	}
+	return hour % 24
+}
+
+// parseNumber 解析阿拉伯数字或一百以内的中文数字
+func parseNumber(str string) int {
+	if n, err := strconv.Atoi(str); err == nil {
+		return n
+	}
+	digits := map[rune]int{
+		'零': 0, '〇': 0, '一': 1, '二': 2, '两': 2, '三': 3, '四': 4,
+		'五': 5, '六': 6, '七': 7, '八': 8, '九': 9,
+	}
+	result, current := 0, 0
+	for _, r := range str {
+		if r == '十' {
+			if current == 0 {
+				current = 1
+			}
+			result += current * 10
+			current = 0
+			continue
+		}
+		current = current*10 + digits[r]
+	}
+	return result + current
+}
+
+func parseWeekdays(str string) []string {
+	var result []string
+	for _, item := range regexp.MustCompile(`(?i)[一二三四五六日天1-7]|mon|tue|wed|thu|fri|sat|sun`).FindAllString(str, -1) {
+		weekday := rruleWeekdays[weekdayMap[strings.ToLower(item)]]
+		if !strings.Contains(strings.Join(result, ","), weekday) {
+			result = append(result, weekday)
+		}
+	}
+	return result
+}
+
+func parseMinute(str string) int {
+	switch str {
+	case "":
+		return 0
+	case "半":
+		return 30
+	case "一刻":
+		return 15
+	case "三刻":
+		return 45
+	}
+	return parseNumber(strings.TrimSuffix(str, "分"))
+}
+
+func parseDuration(num, unit string) time.Duration {
+	n := 1
+	switch strings.ToLower(strings.TrimSpace(num)) {
+	case "半", "half an", "half a":
+		return parseDuration("1", unit) / 2
+	case "", "a", "an":
+	default:
+		n = parseNumber(num)
+	}
+	unit = strings.ToLower(unit)
+	switch {
+	case unit == "分钟" || strings.HasPrefix(unit, "m"):
+		return time.Duration(n) * time.Minute
+	case unit == "天" || strings.HasPrefix(unit, "d"):
+		return time.Duration(n) * 24 * time.Hour
+	}
+	return time.Duration(n) * time.Hour
+}
+
+var repeatRules = []matchRule{
+	{
+		pattern: regexp.MustCompile(`每个?工作日`),
+		handle: func(p *timeParser, groups []string) {
+			p.setRepeat(model.REPEAT_TYPE_WORKING_DAY, 0)
+		},
+	},
+	{
+		pattern: regexp.MustCompile(`每个?节假日`),
+		handle: func(p *timeParser, groups []string) {
+			p.setRepeat(model.REPEAT_TYPE_HOLIDAY, 0)
+		},
+	},
+	{
+		pattern: regexp.MustCompile(`(?i)every\s+weekday`),
+		handle: func(p *timeParser, groups []string) {
+			p.setRRule("FREQ=WEEKLY;BYDAY=MO,TU,WE,TH,FR")
+		},
+	},
+	{
+		pattern: regexp.MustCompile(`每个?(?:周|星期|礼拜)([一二三四五六日天1-7][一二三四五六日天1-7、，,和]*)`),
+		handle: func(p *timeParser, groups []string) {
+			p.setRRule("FREQ=WEEKLY;BYDAY=%s", strings.Join(parseWeekdays(groups[1]), ","))
+		},
+	},
+	{
+		pattern: regexp.MustCompile(`(?i)every\s+(` + dayNamePattern + `(?:\s*(?:,|and)\s*` + dayNamePattern + `)*)`),
+		handle: func(p *timeParser, groups []string) {
+			p.setRRule("FREQ=WEEKLY;BYDAY=%s", strings.Join(parseWeekdays(groups[1]), ","))
+		},
+	},
+	{
+		pattern: regexp.MustCompile(`每个?月(?:的)?(最后一天|` + numPattern + `[号日])`),
+		handle: func(p *timeParser, groups []string) {
+			if groups[1] == "最后一天" {
+				p.setRRule("FREQ=MONTHLY;BYMONTHDAY=-1")
+				return
+			}
+			p.setRRule("FREQ=MONTHLY;BYMONTHDAY=%d", parseNumber(groups[2]))
+		},
+	},
+	{
+		pattern: regexp.MustCompile(`(?i)every\s+month\s+on\s+the\s+(\d{1,2})(?:st|nd|rd|th)?`),
+		handle: func(p *timeParser, groups []string) {
+			p.setRRule("FREQ=MONTHLY;BYMONTHDAY=%s", groups[1])
+		},
+	},
+	{
+		pattern: regexp.MustCompile(`每年的?` + numPattern + `月` + numPattern + `[号日]`),
+		handle: func(p *timeParser, groups []string) {
+			p.setRRule("FREQ=YEARLY;BYMONTH=%d;BYMONTHDAY=%d", parseNumber(groups[1]), parseNumber(groups[2]))
+		},
+	},
+	{
+		pattern: regexp.MustCompile(`每隔?` + numPattern + `?个?(天|周|星期|月|年)|每(日)`),
+		handle: func(p *timeParser, groups []string) {
+			interval := 1
+			if groups[1] != "" {
+				interval = parseNumber(groups[1])
+			}
+			switch groups[2] {
+			case "周", "星期":
+				p.setRepeat(model.REPEAT_TYPE_WEEKLY, interval)
+			case "月":
+				p.setRepeat(model.REPEAT_TYPE_MONTHLY, interval)
+			case "年":
+				p.setRepeat(model.REPEAT_TYPE_YEARLY, interval)
+			default:
+				p.setRepeat(model.REPEAT_TYPE_DAILY, interval)
+			}
+		},
+	},
+	{
+		pattern: regexp.MustCompile(`(?i)every\s+(?:(other)\s+|(\d+)\s+)?(day|week|month|year)s?|\b(daily|weekly|monthly|yearly)\b`),
+		handle: func(p *timeParser, groups []string) {
+			interval := 1
+			if groups[1] != "" {
+				interval = 2
+			} else if groups[2] != "" {
+				interval = parseNumber(groups[2])
+			}
+			unit := strings.ToLower(groups[3])
+			if unit == "" {
+				unit = strings.TrimSuffix(strings.ToLower(groups[4]), "ly")
+			}
+			switch unit {
+			case "week":
+				p.setRepeat(model.REPEAT_TYPE_WEEKLY, interval)
+			case "month":
+				p.setRepeat(model.REPEAT_TYPE_MONTHLY, interval)
+			case "year":
+				p.setRepeat(model.REPEAT_TYPE_YEARLY, interval)
+			default:
+				p.setRepeat(model.REPEAT_TYPE_DAILY, interval)
+			}
+		},
+	},
+}
+
+var relativeRules = []matchRule{
+	{
+		pattern: regexp.MustCompile(numPattern + `?(半)?个?(半)?(小时|钟头|分钟)(?:后|以后|之后)`),
+		handle: func(p *timeParser, groups []string) {
+			p.hasRelative = true
+			switch {
+			case groups[1] == "" && groups[2] == "半":
+				p.relative = parseDuration("半", groups[4])
+			case groups[3] == "半" || groups[2] == "半":
+				p.relative = parseDuration(groups[1], groups[4]) + parseDuration("半", groups[4])
+			default:
+				p.relative = parseDuration(groups[1], groups[4])
+			}
+		},
+	},
+	{
+		pattern: regexp.MustCompile(`(?i)\bin\s+(\d+|an?|half\s+an?)\s+(minutes?|mins?|hours?|hrs?)\b`),
+		handle: func(p *timeParser, groups []string) {
+			p.hasRelative = true
+			p.relative = parseDuration(strings.Join(strings.Fields(groups[1]), " "), groups[2])
+		},
+	},
+}
+
+var dateRules = []matchRule{
+	{
+		pattern: regexp.MustCompile(`(\d{4})[年\-/](\d{1,2})[月\-/](\d{1,2})[日号]?`),
+		handle: func(p *timeParser, groups []string) {
+			p.setDate(parseNumber(groups[1]), time.Month(parseNumber(groups[2])), parseNumber(groups[3]))
+		},
+	},
+	{
+		pattern: regexp.MustCompile(numPattern + `月` + numPattern + `[日号]`),
+		handle: func(p *timeParser, groups []string) {
+			p.setDate(p.now.Year(), time.Month(parseNumber(groups[1])), parseNumber(groups[2]))
+			// 今年的日期已经过去时默认是明年
+			if p.date.Before(p.now.AddDate(0, 0, -1)) {
+				p.date = p.date.AddDate(1, 0, 0)
+			}
+		},
+	},
+	{
+		pattern: regexp.MustCompile(`(下下|下个?|这个?|本)?(?:周|星期|礼拜)([一二三四五六日天1-7])`),
+		handle: func(p *timeParser, groups []string) {
+			weekday := weekdayMap[groups[2]]
+			if groups[1] == "" {
+				p.hasWeekday = true
+				p.weekday = weekday
+				return
+			}
+			// 以周一作为一周的第一天
+			offset := (int(weekday)+6)%7 - (int(p.now.Weekday())+6)%7
+			switch groups[1] {
+			case "下", "下个":
+				offset += 7
+			case "下下":
+				offset += 14
+			}
+			p.setDateOffset(offset)
+		},
+	},
+	{
+		pattern: regexp.MustCompile(`(?i)\b(next|this|on)?\s*(monday|tuesday|wednesday|thursday|friday|saturday|sunday)\b`),
+		handle: func(p *timeParser, groups []string) {
+			weekday := weekdayMap[strings.ToLower(groups[2])[:3]]
+			if strings.ToLower(groups[1]) != "next" {
+				p.hasWeekday = true
+				p.weekday = weekday
+				return
+			}
+			offset := (int(weekday)+6)%7 - (int(p.now.Weekday())+6)%7 + 7
+			p.setDateOffset(offset)
+		},
+	},
+	{
+		pattern: regexp.MustCompile(`(大后天|后天|明天|明日|明早|明晚|今天|今日|今早|今晚)`),
+		handle: func(p *timeParser, groups []string) {
+			switch groups[1] {
+			case "大后天":
+				p.setDateOffset(3)
+			case "后天":
+				p.setDateOffset(2)
+			case "明天", "明日", "明早", "明晚":
+				p.setDateOffset(1)
+			default:
+				p.setDateOffset(0)
+			}
+			switch groups[1] {
+			case "明早", "今早":
+				p.period = period_morning
+			case "明晚", "今晚":
+				p.period = period_evening
+			}
+		},
+	},
+	{
+		pattern: regexp.MustCompile(`(?i)\b(today|tonight|tomorrow|tmr)\b`),
+		handle: func(p *timeParser, groups []string) {
+			switch strings.ToLower(groups[1]) {
+			case "tomorrow", "tmr":
+				p.setDateOffset(1)
+			case "tonight":
+				p.setDateOffset(0)
+				p.period = period_evening
+			default:
+				p.setDateOffset(0)
+			}
+		},
+	},
+	{
+		pattern: regexp.MustCompile(numPattern + `天(?:后|以后|之后)`),
+		handle: func(p *timeParser, groups []string) {
+			p.setDateOffset(parseNumber(groups[1]))
+		},
+	},
+	{
+		pattern: regexp.MustCompile(`(?i)\bin\s+(\d+|an?)\s+days?\b`),
+		handle: func(p *timeParser, groups []string) {
+			p.setDateOffset(int(parseDuration(groups[1], "day") / (24 * time.Hour)))
+		},
+	},
+	{
+		pattern: regexp.MustCompile(`(\d{1,2}|[一二三四五六七八九十]+)[号]`),
+		handle: func(p *timeParser, groups []string) {
+			p.setDate(p.now.Year(), p.now.Month(), parseNumber(groups[1]))
+			if p.date.Before(p.now.AddDate(0, 0, -1)) {
+				p.date = p.date.AddDate(0, 1, 0)
+			}
+		},
+	},
+}
+
+var timeRules = []matchRule{
+	{
+		pattern: regexp.MustCompile(`(凌晨|早上|早晨|上午|中午|下午|傍晚|晚上|早|晚)?\s*` + numPattern + `\s*(?:点钟?|时)\s*(半|一刻|三刻|` + numPattern + `分?)?`),
+		handle: func(p *timeParser, groups []string) {
+			p.setTime(parseNumber(groups[2]), parseMinute(groups[3]), groups[1])
+		},
+	},
+	{
+		pattern: regexp.MustCompile(`(?i)(凌晨|早上|早晨|上午|中午|下午|傍晚|晚上|早|晚)?\s*(?:\bat\s+)?\b(\d{1,2})(?:[:：](\d{2}))?\s*(am|pm)\b`),
+		handle: func(p *timeParser, groups []string) {
+			period := groups[4]
+			if groups[1] != "" {
+				period = groups[1]
+			}
+			p.setTime(parseNumber(groups[2]), parseNumber(groups[3]), period)
+		},
+	},
+	{
+		pattern: regexp.MustCompile(`(?i)(凌晨|早上|早晨|上午|中午|下午|傍晚|晚上|早|晚)?\s*(?:\bat\s+)?\b(\d{1,2})[:：](\d{2})\b`),
+		handle: func(p *timeParser, groups []string) {
+			p.setTime(parseNumber(groups[2]), parseNumber(groups[3]), groups[1])
+		},
+	},
+	{
+		pattern: regexp.MustCompile(`(?i)\bat\s+(\d{1,2})\b`),
+		handle: func(p *timeParser, groups []string) {
+			p.setTime(parseNumber(groups[1]), 0, "")
+		},
+	},
+	{
+		pattern: regexp.MustCompile(`(?i)\b(noon|midnight)\b`),
+		handle: func(p *timeParser, groups []string) {
+			if strings.ToLower(groups[1]) == "noon" {
+				p.setTime(12, 0, "")
+				return
+			}
+			p.setTime(0, 0, "")
+		},
+	},
+	{
+		pattern: regexp.MustCompile(`(凌晨|早上|早晨|上午|中午|下午|傍晚|晚上)`),
+		handle: func(p *timeParser, groups []string) {
+			p.period = periodMap[groups[1]]
+		},
+	},
+}
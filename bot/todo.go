@@ -0,0 +1,165 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"todo-reminder/gocq"
+	"todo-reminder/log"
+	"todo-reminder/model"
+	"todo-reminder/openai"
+	"todo-reminder/repository/bsoncodec"
+	"todo-reminder/util"
+)
+
+const (
+	openAITodoPrompt = `从下面的消息中提取待办事项和提醒时间，当前时间是 %s（%s）。
+只返回 JSON，不要返回其它内容，格式为 {"content": "待办内容", "remindAt": "2006-01-02 15:04", "rrule": "FREQ=WEEKLY;BYDAY=MO"}，
+不需要重复时 rrule 为空字符串，无法识别时返回 {}。
+消息：%s`
+
+	usageMessage = `没有识别出提醒时间，可以这样告诉我：
+明天下午3点提醒我交报告
+每个工作日早上9点打卡
+every weekday 9am stand-up`
+)
+
+type openAITodo struct {
+	Content  string `json:"content"`
+	RemindAt string `json:"remindAt"`
+	RRule    string `json:"rrule"`
+}
+
+// createTodo 从私聊消息中解析出待办并创建
+func createTodo(ctx context.Context, event *gocq.EventBody, content string) (string, bool) {
+	content = strings.TrimSpace(content)
+	if event.MessageType != gocq.MESSAGE_TYPE_PRIVATE || content == "" {
+		return "", false
+	}
+	user, ok := getUser(ctx, event)
+	if !ok {
+		return "", false
+	}
+	now := getUserNow(user)
+	parsed, err := ParseTodo(content, now)
+	if errors.Is(err, ErrNoTimeFound) {
+		parsed, err = parseTodoByOpenAI(ctx, content, now)
+	}
+	if err != nil {
+		return usageMessage, true
+	}
+	todo := model.Todo{
+		Id:         bsoncodec.NewObjectId(),
+		NeedRemind: true,
+		Content:    parsed.Content,
+		UserId:     user.UserId,
+		RemindSetting: model.RemindSetting{
+			RemindAt:      parsed.RemindAt,
+			IsRepeatable:  parsed.IsRepeatable,
+			RepeatSetting: parsed.RepeatSetting,
+		},
+	}
+	if err := todo.RemindSetting.Validate(); err != nil {
+		return fmt.Sprintf("创建失败：%s", err.Error()), true
+	}
+	// 复制一份提醒设置用于计算第一次提醒的时间，避免修改待办本身
+	setting := todo.RemindSetting
+	remindAt, err := setting.GetNextRemindAt(ctx, now.Location())
+	if err != nil {
+		return fmt.Sprintf("创建失败：%s", err.Error()), true
+	}
+	if !todo.RemindSetting.IsRepeatable && remindAt.Before(now) {
+		return "提醒时间已经过去了，换个时间吧", true
+	}
+	if err := todo.Upsert(ctx); err != nil {
+		log.Warn("Failed to create todo from message", map[string]interface{}{
+			"userId":  user.UserId,
+			"message": content,
+			"error":   err.Error(),
+		})
+		return "创建失败，请稍后再试", true
+	}
+	reply := fmt.Sprintf("已创建待办：%s\n提醒时间：%s", todo.Content, formatRemindAt(remindAt, now))
+	if todo.RemindSetting.IsRepeatable {
+		reply = fmt.Sprintf("%s\n重复：%s", reply, describeRepeat(todo.RemindSetting.RepeatSetting))
+	}
+	return reply, true
+}
+
+func parseTodoByOpenAI(ctx context.Context, content string, now time.Time) (*ParsedTodo, error) {
+	resp, err := openai.GetOpenAIClient().ChatCompletion(ctx, fmt.Sprintf(openAITodoPrompt, now.Format("2006-01-02 15:04 Mon"), now.Location().String(), content))
+	if err != nil {
+		return nil, err
+	}
+	// 去掉可能存在的 markdown 代码块
+	resp = strings.TrimSpace(resp)
+	resp = strings.TrimPrefix(strings.TrimPrefix(resp, "```json"), "```")
+	resp = strings.TrimSuffix(resp, "```")
+	result, err := util.UnmarshalFromJson[openAITodo](resp)
+	if err != nil {
+		return nil, err
+	}
+	if result.Content == "" || result.RemindAt == "" {
+		return nil, ErrNoTimeFound
+	}
+	remindAt, err := time.ParseInLocation("2006-01-02 15:04", result.RemindAt, now.Location())
+	if err != nil {
+		return nil, err
+	}
+	parsed := &ParsedTodo{
+		Content:  result.Content,
+		RemindAt: remindAt,
+	}
+	if result.RRule != "" {
+		parsed.IsRepeatable = true
+		parsed.RepeatSetting = model.RepeatSetting{
+			Type:  model.REPEAT_TYPE_RRULE,
+			RRule: strings.TrimPrefix(result.RRule, "RRULE:"),
+		}
+	}
+	return parsed, nil
+}
+
+func formatRemindAt(remindAt, now time.Time) string {
+	weekdays := []string{"日", "一", "二", "三", "四", "五", "六"}
+	prefix := ""
+	switch remindAt.YearDay() - now.YearDay() {
+	case 0:
+		prefix = "今天 "
+	case 1:
+		prefix = "明天 "
+	case 2:
+		prefix = "后天 "
+	}
+	if remindAt.Year() != now.Year() {
+		prefix = ""
+	}
+	return fmt.Sprintf("%s%s 周%s %s", prefix, remindAt.Format("2006-01-02"), weekdays[remindAt.Weekday()], remindAt.Format("15:04"))
+}
+
+func describeRepeat(setting model.RepeatSetting) string {
+	interval := setting.DateOffset
+	if interval < 1 {
+		interval = 1
+	}
+	units := map[string]string{
+		model.REPEAT_TYPE_DAILY:   "天",
+		model.REPEAT_TYPE_WEEKLY:  "周",
+		model.REPEAT_TYPE_MONTHLY: "个月",
+		model.REPEAT_TYPE_YEARLY:  "年",
+	}
+	switch setting.Type {
+	case model.REPEAT_TYPE_WORKING_DAY:
+		return "每个工作日"
+	case model.REPEAT_TYPE_HOLIDAY:
+		return "每个节假日"
+	case model.REPEAT_TYPE_RRULE:
+		return setting.RRule
+	}
+	if interval == 1 {
+		return fmt.Sprintf("每%s", strings.TrimPrefix(units[setting.Type], "个"))
+	}
+	return fmt.Sprintf("每%d%s", interval, units[setting.Type])
+}
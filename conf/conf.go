@@ -14,6 +14,7 @@ func init() {
 	viper.Set("minio.sk", os.Getenv("MINIO_SK"))
 	viper.Set("openai.sk", os.Getenv("OPENAI_SK"))
 	viper.Set("email.password", os.Getenv("EMAIL_PASSWORD"))
+	viper.Set("notifier.telegram.botToken", os.Getenv("TELEGRAM_BOT_TOKEN"))
 	err := viper.ReadInConfig()
 	if err != nil {
 		panic(err)
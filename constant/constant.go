@@ -5,5 +5,7 @@ const (
 	HEADER_TOKEN      = "x-access-token"
 
 	GIN_KEY_USER_ID       = "userId"
+	GIN_KEY_SESSION_ID    = "sessionId"
+	GIN_KEY_API_KEY_ID    = "apiKeyId"
 	GIN_KEY_RESPONSE_BODY = "responseBody"
 )
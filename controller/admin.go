@@ -0,0 +1,261 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/qiniu/qmgo"
+	"net/http"
+	"todo-reminder/model"
+	"todo-reminder/repository/bsoncodec"
+	"todo-reminder/util"
+)
+
+func init() {
+	registerApi(ReminderApi{
+		Endpoint:  "/admin/users/search",
+		Method:    http.MethodPost,
+		Handler:   SearchUsers,
+		AdminOnly: true,
+	})
+	registerApi(ReminderApi{
+		Endpoint:  "/admin/users/:userId/enable",
+		Method:    http.MethodPut,
+		Handler:   EnableUser,
+		AdminOnly: true,
+	})
+	registerApi(ReminderApi{
+		Endpoint:  "/admin/users/:userId/disable",
+		Method:    http.MethodPut,
+		Handler:   DisableUser,
+		AdminOnly: true,
+	})
+	registerApi(ReminderApi{
+		Endpoint:  "/admin/users/:userId/role",
+		Method:    http.MethodPut,
+		Handler:   UpdateUserRole,
+		AdminOnly: true,
+	})
+	registerApi(ReminderApi{
+		Endpoint:  "/admin/users/:userId/resetPassword",
+		Method:    http.MethodPost,
+		Handler:   ResetUserPassword,
+		AdminOnly: true,
+	})
+	registerApi(ReminderApi{
+		Endpoint:  "/admin/users/:userId/usage",
+		Method:    http.MethodGet,
+		Handler:   GetUserUsage,
+		AdminOnly: true,
+	})
+}
+
+var (
+	errOperateSelf = errors.New("can not operate on yourself")
+)
+
+type SearchUsersRequest struct {
+	UserId        string        `json:"userId"`
+	IsEnabled     *bool         `json:"isEnabled"`
+	Role          *string       `json:"role"`
+	CreatedAt     *TimeRange    `json:"createdAt"`
+	ListCondition ListCondition `json:"listCondition"`
+}
+
+type UserDetail struct {
+	UserId    string `json:"userId"`
+	IsEnabled bool   `json:"isEnabled"`
+	Role      string `json:"role"`
+	IsAdmin   bool   `json:"isAdmin"`
+	Timezone  string `json:"timezone"`
+	CreatedAt string `json:"createdAt"`
+	UpdatedAt string `json:"updatedAt"`
+}
+
+type SearchUsersResponse struct {
+	Total int64        `json:"total"`
+	Items []UserDetail `json:"items"`
+}
+
+type UpdateUserRoleRequest struct {
+	Role string `json:"role"`
+}
+
+type UserUsageResponse struct {
+	TodoCount          int64  `json:"todoCount"`
+	RecordCount        int64  `json:"recordCount"`
+	UndoneRecordCount  int64  `json:"undoneRecordCount"`
+	OverdueRecordCount int64  `json:"overdueRecordCount"`
+	MessageCount       int64  `json:"messageCount"`
+	ActiveSessions     int64  `json:"activeSessions"`
+	ActiveApiKeys      int64  `json:"activeApiKeys"`
+	LastLoginAt        string `json:"lastLoginAt"`
+	LastLoginIp        string `json:"lastLoginIp"`
+	FailedLoginsToday  int64  `json:"failedLoginsToday"`
+}
+
+// SearchUsers 查询未删除的用户，默认按创建时间倒序
+func SearchUsers(ctx *gin.Context) {
+	req := SearchUsersRequest{}
+	if err := ctx.ShouldBind(&req); err != nil {
+		ReturnError(ctx, err)
+		return
+	}
+	condition := bsoncodec.M{
+		"isDeleted": false,
+	}
+	if req.UserId != "" {
+		condition["userId"] = req.UserId
+	}
+	if req.IsEnabled != nil {
+		condition["isEnabled"] = *req.IsEnabled
+	}
+	if req.Role != nil {
+		if *req.Role == model.ROLE_USER {
+			condition["role"] = bsoncodec.M{"$in": []interface{}{nil, model.ROLE_USER}}
+		} else {
+			condition["role"] = *req.Role
+		}
+	}
+	if err := setTimeRangeCondition(condition, "createdAt", req.CreatedAt); err != nil {
+		ReturnError(ctx, err)
+		return
+	}
+	req.ListCondition = formatListCondition(req.ListCondition)
+	if len(req.ListCondition.OrderBy) == 0 {
+		req.ListCondition.OrderBy = []string{"-createdAt"}
+	}
+	total, users, err := model.CUser.ListByPagination(ctx, condition, req.ListCondition.Page, req.ListCondition.PerPage, req.ListCondition.OrderBy)
+	if err != nil {
+		ReturnError(ctx, err)
+		return
+	}
+	items := make([]UserDetail, 0, len(users))
+	for _, user := range users {
+		items = append(items, UserDetail{
+			UserId:    user.UserId,
+			IsEnabled: user.IsEnabled,
+			Role:      user.Role,
+			IsAdmin:   user.IsAdmin(),
+			Timezone:  user.Timezone,
+			CreatedAt: util.TransTimeToRFC3339(user.CreatedAt),
+			UpdatedAt: util.TransTimeToRFC3339(user.UpdatedAt),
+		})
+	}
+	ctx.JSON(http.StatusOK, SearchUsersResponse{
+		Total: total,
+		Items: items,
+	})
+}
+
+func EnableUser(ctx *gin.Context) {
+	setUserEnabled(ctx, true)
+}
+
+// DisableUser 禁用后用户所有会话失效，不能登录，也不再收到提醒
+func DisableUser(ctx *gin.Context) {
+	setUserEnabled(ctx, false)
+}
+
+func setUserEnabled(ctx *gin.Context, isEnabled bool) {
+	userId, ok := getTargetUserId(ctx)
+	if !ok {
+		return
+	}
+	if err := model.CUser.SetEnabled(ctx, userId, isEnabled); err != nil {
+		ReturnError(ctx, err)
+		return
+	}
+	event := model.AUTH_EVENT_USER_DISABLED
+	if isEnabled {
+		event = model.AUTH_EVENT_USER_ENABLED
+	}
+	recordAdminEvent(ctx, event, userId, "")
+	ctx.JSON(http.StatusOK, EmptyResponse{})
+}
+
+func UpdateUserRole(ctx *gin.Context) {
+	userId, ok := getTargetUserId(ctx)
+	if !ok {
+		return
+	}
+	req := UpdateUserRoleRequest{}
+	if err := ctx.ShouldBind(&req); err != nil {
+		ReturnError(ctx, err)
+		return
+	}
+	if !model.IsValidRole(req.Role) {
+		ReturnError(ctx, errors.New("invalid role"))
+		return
+	}
+	if err := model.CUser.SetRole(ctx, userId, req.Role); err != nil {
+		ReturnError(ctx, err)
+		return
+	}
+	recordAdminEvent(ctx, model.AUTH_EVENT_ROLE_CHANGED, userId, req.Role)
+	ctx.JSON(http.StatusOK, EmptyResponse{})
+}
+
+// ResetUserPassword 重置为随机密码并注销所有会话，用户需要使用验证码登录
+func ResetUserPassword(ctx *gin.Context) {
+	userId, ok := getTargetUserId(ctx)
+	if !ok {
+		return
+	}
+	if err := model.CUser.ForceResetPassword(ctx, userId); err != nil {
+		ReturnError(ctx, err)
+		return
+	}
+	recordAdminEvent(ctx, model.AUTH_EVENT_PASSWORD_RESET, userId, "")
+	ctx.JSON(http.StatusOK, EmptyResponse{})
+}
+
+func GetUserUsage(ctx *gin.Context) {
+	userId := ctx.Param("userId")
+	if _, err := model.CUser.GetByUserId(ctx, userId); err != nil {
+		ReturnError(ctx, err)
+		return
+	}
+	usage, err := model.CUser.GetUsage(ctx, userId)
+	if err != nil {
+		ReturnError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, UserUsageResponse{
+		TodoCount:          usage.TodoCount,
+		RecordCount:        usage.RecordCount,
+		UndoneRecordCount:  usage.UndoneRecordCount,
+		OverdueRecordCount: usage.OverdueRecordCount,
+		MessageCount:       usage.MessageCount,
+		ActiveSessions:     usage.ActiveSessions,
+		ActiveApiKeys:      usage.ActiveApiKeys,
+		LastLoginAt:        util.TransTimeToRFC3339(usage.LastLoginAt),
+		LastLoginIp:        usage.LastLoginIp,
+		FailedLoginsToday:  usage.FailedLoginsToday,
+	})
+}
+
+// getTargetUserId 管理员不能修改自己的状态，避免误操作后没有可用的管理员
+func getTargetUserId(ctx *gin.Context) (string, bool) {
+	userId := ctx.Param("userId")
+	if userId == util.ExtractUserId(ctx) {
+		ReturnError(ctx, errOperateSelf)
+		return "", false
+	}
+	_, err := model.CUser.GetByUserId(ctx, userId)
+	if err == qmgo.ErrNoSuchDocuments {
+		ReturnError(ctx, errors.New("user not found"))
+		return "", false
+	}
+	if err != nil {
+		ReturnError(ctx, err)
+		return "", false
+	}
+	return userId, true
+}
+
+// recordAdminEvent 审计记录属于被操作的用户，detail 中记录操作的管理员
+func recordAdminEvent(ctx *gin.Context, event, userId, detail string) {
+	detail = fmt.Sprintf("by %s %s", util.ExtractUserId(ctx), detail)
+	model.CAuthEvent.Record(ctx, event, userId, "", detail)
+}
@@ -0,0 +1,127 @@
+package controller
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"time"
+	"todo-reminder/model"
+	"todo-reminder/repository/bsoncodec"
+	"todo-reminder/util"
+)
+
+func init() {
+	registerApi(ReminderApi{
+		Endpoint: "/user/apiKeys",
+		Method:   http.MethodGet,
+		Handler:  ListApiKeys,
+	})
+	registerApi(ReminderApi{
+		Endpoint: "/user/apiKeys",
+		Method:   http.MethodPost,
+		Handler:  CreateApiKey,
+	})
+	registerApi(ReminderApi{
+		Endpoint: "/user/apiKeys/:id",
+		Method:   http.MethodDelete,
+		Handler:  RevokeApiKey,
+	})
+}
+
+type CreateApiKeyRequest struct {
+	Name   string   `json:"name" binding:"required"`
+	Scopes []string `json:"scopes" binding:"required"`
+	// 为空时不过期
+	ExpiredAt string `json:"expiredAt"`
+}
+
+type CreateApiKeyResponse struct {
+	Id string `json:"id"`
+	// 密钥明文只返回这一次
+	Key string `json:"key"`
+}
+
+type ApiKeyDetail struct {
+	Id         string   `json:"id"`
+	Name       string   `json:"name"`
+	KeyPrefix  string   `json:"keyPrefix"`
+	Scopes     []string `json:"scopes"`
+	ExpiredAt  string   `json:"expiredAt"`
+	LastUsedAt string   `json:"lastUsedAt"`
+	CreatedAt  string   `json:"createdAt"`
+}
+
+type ListApiKeysResponse struct {
+	Items []ApiKeyDetail `json:"items"`
+}
+
+func ListApiKeys(ctx *gin.Context) {
+	keys, err := model.CApiKey.ListByUserId(ctx, util.ExtractUserId(ctx))
+	if err != nil {
+		ReturnError(ctx, err)
+		return
+	}
+	items := make([]ApiKeyDetail, 0, len(keys))
+	for _, key := range keys {
+		items = append(items, ApiKeyDetail{
+			Id:         key.Id.Hex(),
+			Name:       key.Name,
+			KeyPrefix:  key.KeyPrefix,
+			Scopes:     key.Scopes,
+			ExpiredAt:  util.TransTimeToRFC3339(key.ExpiredAt),
+			LastUsedAt: util.TransTimeToRFC3339(key.LastUsedAt),
+			CreatedAt:  util.TransTimeToRFC3339(key.CreatedAt),
+		})
+	}
+	ctx.JSON(http.StatusOK, ListApiKeysResponse{
+		Items: items,
+	})
+}
+
+func CreateApiKey(ctx *gin.Context) {
+	req := CreateApiKeyRequest{}
+	if err := ctx.ShouldBind(&req); err != nil {
+		ReturnError(ctx, err)
+		return
+	}
+	var (
+		expiredAt time.Time
+		err       error
+	)
+	if req.ExpiredAt != "" {
+		expiredAt, err = util.TransTimeStrToTime(req.ExpiredAt)
+		if err != nil {
+			ReturnError(ctx, err)
+			return
+		}
+	}
+	apiKey := model.ApiKey{
+		UserId:    util.ExtractUserId(ctx),
+		Name:      req.Name,
+		Scopes:    req.Scopes,
+		ExpiredAt: expiredAt,
+	}
+	key, err := apiKey.Create(ctx)
+	if err != nil {
+		ReturnError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, CreateApiKeyResponse{
+		Id:  apiKey.Id.Hex(),
+		Key: key,
+	})
+}
+
+func RevokeApiKey(ctx *gin.Context) {
+	id := ctx.Param("id")
+	if !bsoncodec.IsObjectIdHex(id) {
+		ReturnError(ctx, errors.New("invalid api key id"))
+		return
+	}
+	err := model.CApiKey.Revoke(ctx, util.ExtractUserId(ctx), bsoncodec.ObjectIdHex(id))
+	if err != nil {
+		ReturnError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, EmptyResponse{})
+}
@@ -12,6 +12,10 @@ type ReminderApi struct {
 	Handler  handler
 	Method   string
 	NoAuth   bool
+	// 使用 API key 访问时需要的权限，为空时只能使用登录后获取的 token 访问
+	Scope string
+	// 只有管理员可以访问
+	AdminOnly bool
 }
 
 type ErrorResponse struct {
@@ -28,10 +32,16 @@ var (
 	APIs []ReminderApi
 
 	NoAuthPath map[string][]string
+	// method -> path -> API key 需要的权限
+	ApiScopes map[string]map[string]string
+	// method -> 只有管理员可以访问的 path
+	AdminPath map[string][]string
 )
 
 func init() {
 	NoAuthPath = map[string][]string{}
+	ApiScopes = map[string]map[string]string{}
+	AdminPath = map[string][]string{}
 }
 
 func registerApi(api ReminderApi) {
@@ -39,6 +49,15 @@ func registerApi(api ReminderApi) {
 	if api.NoAuth {
 		registerNoAuthPath(api.Method, api.Endpoint)
 	}
+	if api.Scope != "" {
+		if ApiScopes[api.Method] == nil {
+			ApiScopes[api.Method] = map[string]string{}
+		}
+		ApiScopes[api.Method][api.Endpoint] = api.Scope
+	}
+	if api.AdminOnly {
+		AdminPath[api.Method] = append(AdminPath[api.Method], api.Endpoint)
+	}
 }
 
 func registerNoAuthPath(method, path string) {
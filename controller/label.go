@@ -0,0 +1,153 @@
+package controller
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strings"
+	"todo-reminder/model"
+	"todo-reminder/repository/bsoncodec"
+	"todo-reminder/util"
+)
+
+func init() {
+	registerLabelApis("/projects", labelHandler{
+		labels: model.CProject,
+		name:   "project",
+	})
+	registerLabelApis("/tags", labelHandler{
+		labels: model.CTag,
+		name:   "tag",
+	})
+}
+
+// registerLabelApis 清单和标签的增删改查接口相同
+func registerLabelApis(endpoint string, h labelHandler) {
+	registerApi(ReminderApi{
+		Endpoint: endpoint,
+		Method:   http.MethodGet,
+		Handler:  h.List,
+	})
+	registerApi(ReminderApi{
+		Endpoint: endpoint,
+		Method:   http.MethodPost,
+		Handler:  h.Create,
+	})
+	registerApi(ReminderApi{
+		Endpoint: endpoint + "/:id",
+		Method:   http.MethodPut,
+		Handler:  h.Update,
+	})
+	registerApi(ReminderApi{
+		Endpoint: endpoint + "/:id",
+		Method:   http.MethodDelete,
+		Handler:  h.Delete,
+	})
+}
+
+// LabelRequest 创建或修改清单、标签
+type LabelRequest struct {
+	Name  string `json:"name" binding:"required"`
+	Color string `json:"color"`
+}
+
+type LabelDetail struct {
+	Id        string `json:"id"`
+	Name      string `json:"name"`
+	Color     string `json:"color"`
+	CreatedAt string `json:"createdAt"`
+}
+
+type ListLabelsResponse struct {
+	Items []LabelDetail `json:"items"`
+}
+
+type labelHandler struct {
+	labels *model.LabelCollection
+	// 用于错误信息，如 project、tag
+	name string
+}
+
+func (h labelHandler) List(ctx *gin.Context) {
+	labels, err := h.labels.ListByUserId(ctx, util.ExtractUserId(ctx))
+	if err != nil {
+		ReturnError(ctx, err)
+		return
+	}
+	items := make([]LabelDetail, 0, len(labels))
+	for _, label := range labels {
+		items = append(items, LabelDetail{
+			Id:        label.Id.Hex(),
+			Name:      label.Name,
+			Color:     label.Color,
+			CreatedAt: util.TransTimeToRFC3339(label.CreatedAt),
+		})
+	}
+	ctx.JSON(http.StatusOK, ListLabelsResponse{
+		Items: items,
+	})
+}
+
+func (h labelHandler) Create(ctx *gin.Context) {
+	req := LabelRequest{}
+	if err := ctx.ShouldBind(&req); err != nil {
+		ReturnError(ctx, err)
+		return
+	}
+	label := model.Label{
+		UserId: util.ExtractUserId(ctx),
+		Name:   strings.TrimSpace(req.Name),
+		Color:  req.Color,
+	}
+	if err := h.labels.Create(ctx, &label); err != nil {
+		ReturnError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, map[string]string{
+		"id": label.Id.Hex(),
+	})
+}
+
+func (h labelHandler) Update(ctx *gin.Context) {
+	id, ok := h.getId(ctx)
+	if !ok {
+		return
+	}
+	req := LabelRequest{}
+	if err := ctx.ShouldBind(&req); err != nil {
+		ReturnError(ctx, err)
+		return
+	}
+	label := model.Label{
+		Id:     id,
+		UserId: util.ExtractUserId(ctx),
+		Name:   strings.TrimSpace(req.Name),
+		Color:  req.Color,
+	}
+	if err := h.labels.Update(ctx, &label); err != nil {
+		ReturnError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, EmptyResponse{})
+}
+
+func (h labelHandler) Delete(ctx *gin.Context) {
+	id, ok := h.getId(ctx)
+	if !ok {
+		return
+	}
+	if err := h.labels.DeleteById(ctx, util.ExtractUserId(ctx), id); err != nil {
+		ReturnError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, EmptyResponse{})
+}
+
+func (h labelHandler) getId(ctx *gin.Context) (bsoncodec.ObjectId, bool) {
+	id := ctx.Param("id")
+	if !bsoncodec.IsObjectIdHex(id) {
+		ReturnError(ctx, fmt.Errorf("invalid %s id", h.name))
+		return "", false
+	}
+	return bsoncodec.ObjectIdHex(id), true
+}
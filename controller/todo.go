@@ -1,11 +1,16 @@
 package controller
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
+	"github.com/qiniu/qmgo"
 	"net/http"
+	"regexp"
+	"strings"
 	"time"
+	"todo-reminder/gocq"
 	"todo-reminder/model"
 	"todo-reminder/repository/bsoncodec"
 	"todo-reminder/util"
@@ -16,11 +21,25 @@ func init() {
 		Endpoint: "/todos/upsert",
 		Method:   http.MethodPost,
 		Handler:  UpsertTodo,
+		Scope:    model.API_SCOPE_WRITE_TODOS,
 	})
 	registerApi(ReminderApi{
 		Endpoint: "/todos/:id",
 		Method:   http.MethodDelete,
 		Handler:  DeleteTodo,
+		Scope:    model.API_SCOPE_WRITE_TODOS,
+	})
+	registerApi(ReminderApi{
+		Endpoint: "/todos/search",
+		Method:   http.MethodPost,
+		Handler:  SearchTodos,
+		Scope:    model.API_SCOPE_READ_TODOS,
+	})
+	registerApi(ReminderApi{
+		Endpoint: "/todos/:id",
+		Method:   http.MethodGet,
+		Handler:  GetTodoById,
+		Scope:    model.API_SCOPE_READ_TODOS,
 	})
 	registerApi(ReminderApi{
 		Endpoint: "/todos/uploadUrl",
@@ -40,28 +59,80 @@ func init() {
 }
 
 type UpsertTodoRequest struct {
-	Id               string   `json:"id"`
-	NeedRemind       bool     `json:"needRemind"`
-	Content          string   `json:"content" binding:"required"`
-	RemindAt         string   `json:"remindAt"`
-	IsRepeatable     bool     `json:"isRepeatable"`
-	RepeatType       string   `json:"repeatType"`
-	RepeatDateOffset int      `json:"repeatDateOffset"`
-	Images           []string `json:"images"`
+	Id               string           `json:"id"`
+	NeedRemind       bool             `json:"needRemind"`
+	Content          string           `json:"content" binding:"required"`
+	RemindAt         string           `json:"remindAt"`
+	IsRepeatable     bool             `json:"isRepeatable"`
+	RepeatType       string           `json:"repeatType"`
+	RepeatDateOffset int              `json:"repeatDateOffset"`
+	RRule            string           `json:"rrule"`
+	ExDates          []string         `json:"exDates"`
+	RemindOffsets    []int64          `json:"remindOffsets"`
+	Images           []string         `json:"images"`
+	NagSetting       model.NagSetting `json:"nagSetting"`
+	Timezone         string           `json:"timezone"`
+	GroupId          string           `json:"groupId"`
+	Assignees        []string         `json:"assignees"`
+	CatchUpPolicy    string           `json:"catchUpPolicy"`
+	ProjectId        string           `json:"projectId"`
+	Tags             []string         `json:"tags"`
+	Priority         int              `json:"priority"`
+	Color            string           `json:"color"`
+	// 修改待办时保留已有子任务的 id，新的子任务 id 为空
+	Checklist             []ChecklistItemRequest `json:"checklist"`
+	AutoCompleteChecklist bool                   `json:"autoCompleteChecklist"`
+	// 前置待办的 id
+	DependsOn []string `json:"dependsOn"`
+	// 截止时间，可以为空
+	DueAt string `json:"dueAt"`
+	// 每条记录在提醒时间之后多少秒截止，用于重复待办
+	DueOffset int64 `json:"dueOffset"`
+}
+
+type ChecklistItemRequest struct {
+	Id      string `json:"id"`
+	Content string `json:"content"`
+}
+
+type ChecklistItemDetail struct {
+	Id        string `json:"id"`
+	Content   string `json:"content"`
+	IsChecked bool   `json:"isChecked"`
+	CheckedAt string `json:"checkedAt"`
 }
 
 type TodoDetail struct {
-	Id          string `json:"id"`
-	CreatedAt   string `json:"createdAt"`
-	HasBeenDone bool   `json:"hasBeenDone"`
-	NeedRemind  bool   `json:"needRemind"`
-	DoneAt      string `json:"doneAt"`
-	Content     string `json:"content"`
-	RemindAt    string `json:"remindAt"`
-	RepeatType  string `json:"repeatType"`
-	Month       int    `json:"month"`
-	Weekday     int    `json:"weekday"`
-	Day         int    `json:"day"`
+	Id                    string                `json:"id"`
+	CreatedAt             string                `json:"createdAt"`
+	UpdatedAt             string                `json:"updatedAt"`
+	NeedRemind            bool                  `json:"needRemind"`
+	Content               string                `json:"content"`
+	UserId                string                `json:"userId"`
+	RemindAt              string                `json:"remindAt"`
+	IsRepeatable          bool                  `json:"isRepeatable"`
+	RepeatType            string                `json:"repeatType"`
+	RepeatDateOffset      int                   `json:"repeatDateOffset"`
+	RRule                 string                `json:"rrule"`
+	ExDates               []string              `json:"exDates"`
+	RemindOffsets         []int64               `json:"remindOffsets"`
+	CatchUpPolicy         string                `json:"catchUpPolicy"`
+	Images                []Image               `json:"images"`
+	NagSetting            model.NagSetting      `json:"nagSetting"`
+	Timezone              string                `json:"timezone"`
+	GroupId               string                `json:"groupId"`
+	Assignees             []string              `json:"assignees"`
+	ProjectId             string                `json:"projectId"`
+	Tags                  []string              `json:"tags"`
+	Priority              int                   `json:"priority"`
+	Color                 string                `json:"color"`
+	Checklist             []ChecklistItemDetail `json:"checklist"`
+	AutoCompleteChecklist bool                  `json:"autoCompleteChecklist"`
+	DependsOn             []string              `json:"dependsOn"`
+	DueAt                 string                `json:"dueAt"`
+	DueOffset             int64                 `json:"dueOffset"`
+	// 下一条未完成的记录，仅获取单个待办时返回
+	NextRecord *TodoRecordDetail `json:"nextRecord,omitempty"`
 }
 
 type TimeRange struct {
@@ -70,13 +141,21 @@ type TimeRange struct {
 }
 
 type SearchTodoRequest struct {
-	HasBeenDone bool       `json:"hasBeenDone"`
-	OrderBy     []string   `json:"orderBy"`
-	RemindAt    *TimeRange `json:"remindAt"`
+	NeedRemind *bool `json:"needRemind"`
+	// 为 none 时只返回不重复的待办
+	RepeatType string     `json:"repeatType"`
+	Keyword    string     `json:"keyword"`
+	RemindAt   *TimeRange `json:"remindAt"`
+	DueAt      *TimeRange `json:"dueAt"`
+	ProjectId  string     `json:"projectId"`
+	// 包含任意一个标签即可
+	Tags          []string      `json:"tags"`
+	Priority      *int          `json:"priority"`
+	ListCondition ListCondition `json:"listCondition"`
 }
 
 type SearchTodoResponse struct {
-	Total int          `json:"total"`
+	Total int64        `json:"total"`
 	Items []TodoDetail `json:"items"`
 }
 
@@ -89,6 +168,7 @@ func UpsertTodo(ctx *gin.Context) {
 	}
 	var (
 		remindAt time.Time
+		dueAt    time.Time
 	)
 	if req.NeedRemind {
 		remindAt, err = util.TransTimeStrToTime(req.RemindAt)
@@ -97,6 +177,17 @@ func UpsertTodo(ctx *gin.Context) {
 			return
 		}
 	}
+	if req.DueAt != "" {
+		dueAt, err = util.TransTimeStrToTime(req.DueAt)
+		if err != nil {
+			ReturnError(ctx, err)
+			return
+		}
+	}
+	if req.DueOffset < 0 {
+		ReturnError(ctx, errors.New("invalid due offset"))
+		return
+	}
 	if req.IsRepeatable && !util.StrInArray(req.RepeatType, &[]string{
 		model.REPEAT_TYPE_DAILY,
 		model.REPEAT_TYPE_WEEKLY,
@@ -104,10 +195,55 @@ func UpsertTodo(ctx *gin.Context) {
 		model.REPEAT_TYPE_YEARLY,
 		model.REPEAT_TYPE_WORKING_DAY,
 		model.REPEAT_TYPE_HOLIDAY,
+		model.REPEAT_TYPE_RRULE,
 	}) {
 		ReturnError(ctx, errors.New("invalid repeat type"))
 		return
 	}
+	if req.Timezone != "" && !util.IsValidTimezone(req.Timezone) {
+		ReturnError(ctx, errors.New("invalid timezone"))
+		return
+	}
+	if len(req.Assignees) > 0 && req.GroupId == "" {
+		ReturnError(ctx, errors.New("assignees require group id"))
+		return
+	}
+	if err := validateGroupAssignees(ctx, util.ExtractUserId(ctx), req.GroupId, req.Assignees); err != nil {
+		ReturnError(ctx, err)
+		return
+	}
+	if !model.IsValidPriority(req.Priority) {
+		ReturnError(ctx, errors.New("invalid priority"))
+		return
+	}
+	if !model.IsValidColor(req.Color) {
+		ReturnError(ctx, model.ErrInvalidColor)
+		return
+	}
+	projectId, tags, err := validateProjectAndTags(ctx, util.ExtractUserId(ctx), req.ProjectId, req.Tags)
+	if err != nil {
+		ReturnError(ctx, err)
+		return
+	}
+	checklist, err := genChecklist(req.Checklist)
+	if err != nil {
+		ReturnError(ctx, err)
+		return
+	}
+	dependsOn, err := parseObjectIds(util.Unique(req.DependsOn))
+	if err != nil {
+		ReturnError(ctx, errors.New("invalid dependency id"))
+		return
+	}
+	exDates := make([]time.Time, 0, len(req.ExDates))
+	for _, exDate := range req.ExDates {
+		t, err := util.TransTimeStrToTime(exDate)
+		if err != nil {
+			ReturnError(ctx, err)
+			return
+		}
+		exDates = append(exDates, t)
+	}
 	todo := model.Todo{
 		NeedRemind: req.NeedRemind,
 		Content:    req.Content,
@@ -118,20 +254,50 @@ func UpsertTodo(ctx *gin.Context) {
 			RepeatSetting: model.RepeatSetting{
 				Type:       req.RepeatType,
 				DateOffset: req.RepeatDateOffset,
+				RRule:      req.RRule,
+				ExDates:    exDates,
 			},
+			RemindOffsets: req.RemindOffsets,
+			CatchUpPolicy: req.CatchUpPolicy,
 		},
-		Images: req.Images,
+		Images:                req.Images,
+		NagSetting:            req.NagSetting,
+		Timezone:              req.Timezone,
+		GroupId:               req.GroupId,
+		Assignees:             req.Assignees,
+		ProjectId:             projectId,
+		Tags:                  tags,
+		Priority:              req.Priority,
+		Color:                 req.Color,
+		Checklist:             checklist,
+		AutoCompleteChecklist: req.AutoCompleteChecklist,
+		DependsOn:             dependsOn,
+		DueAt:                 dueAt,
+		DueOffset:             req.DueOffset,
+	}
+	if req.NeedRemind {
+		if err := todo.RemindSetting.Validate(); err != nil {
+			ReturnError(ctx, err)
+			return
+		}
+		if err := todo.NagSetting.Validate(); err != nil {
+			ReturnError(ctx, err)
+			return
+		}
 	}
 	if req.Id != "" {
-		if bsoncodec.IsObjectIdHex(req.Id) {
-			todo.Id = bsoncodec.ObjectIdHex(req.Id)
-		} else {
-			ReturnError(ctx, errors.New("invalid todo id"))
+		// 只有创建者可以修改，被指派的成员不能修改群待办
+		if _, ok := getOwnTodo(ctx, req.Id); !ok {
 			return
 		}
+		todo.Id = bsoncodec.ObjectIdHex(req.Id)
 	} else {
 		todo.Id = bsoncodec.NewObjectId()
 	}
+	if err := todo.ValidateDependencies(ctx); err != nil {
+		ReturnError(ctx, err)
+		return
+	}
 	err = todo.Upsert(ctx)
 	if err != nil {
 		ReturnError(ctx, err)
@@ -140,18 +306,292 @@ func UpsertTodo(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, EmptyResponse{})
 }
 
+// validateProjectAndTags 清单和标签必须属于当前用户
+func validateProjectAndTags(ctx context.Context, userId, projectIdStr string, tagStrs []string) (bsoncodec.ObjectId, []bsoncodec.ObjectId, error) {
+	var projectId bsoncodec.ObjectId
+	if projectIdStr != "" {
+		if !bsoncodec.IsObjectIdHex(projectIdStr) {
+			return "", nil, errors.New("invalid project id")
+		}
+		projectId = bsoncodec.ObjectIdHex(projectIdStr)
+		if _, err := model.CProject.GetById(ctx, userId, projectId); err != nil {
+			return "", nil, errors.New("project not found")
+		}
+	}
+	tags, err := parseObjectIds(util.Unique(tagStrs))
+	if err != nil {
+		return "", nil, errors.New("invalid tag id")
+	}
+	if len(tags) > 0 {
+		count, err := model.CTag.CountByIds(ctx, userId, tags)
+		if err != nil {
+			return "", nil, err
+		}
+		if count != int64(len(tags)) {
+			return "", nil, errors.New("tag not found")
+		}
+	}
+	return projectId, tags, nil
+}
+
+func genChecklist(items []ChecklistItemRequest) ([]model.ChecklistItem, error) {
+	checklist := make([]model.ChecklistItem, 0, len(items))
+	ids := map[string]bool{}
+	for _, item := range items {
+		content := strings.TrimSpace(item.Content)
+		if content == "" {
+			return nil, errors.New("empty checklist item")
+		}
+		id := bsoncodec.NewObjectId()
+		if bsoncodec.IsObjectIdHex(item.Id) {
+			id = bsoncodec.ObjectIdHex(item.Id)
+		}
+		// 重复的 id 会导致勾选时只更新其中一个
+		if ids[id.Hex()] {
+			return nil, errors.New("duplicate checklist item id")
+		}
+		ids[id.Hex()] = true
+		checklist = append(checklist, model.ChecklistItem{
+			Id:      id,
+			Content: content,
+		})
+	}
+	return checklist, nil
+}
+
+func formatChecklist(items []model.ChecklistItem) []ChecklistItemDetail {
+	result := make([]ChecklistItemDetail, 0, len(items))
+	for _, item := range items {
+		result = append(result, ChecklistItemDetail{
+			Id:        item.Id.Hex(),
+			Content:   item.Content,
+			IsChecked: item.IsChecked,
+			CheckedAt: util.TransTimeToRFC3339(item.CheckedAt),
+		})
+	}
+	return result
+}
+
+func parseObjectIds(strs []string) ([]bsoncodec.ObjectId, error) {
+	ids := make([]bsoncodec.ObjectId, 0, len(strs))
+	for _, str := range strs {
+		if !bsoncodec.IsObjectIdHex(str) {
+			return nil, errors.New("invalid id")
+		}
+		ids = append(ids, bsoncodec.ObjectIdHex(str))
+	}
+	return ids, nil
+}
+
+// setLabelCondition 按清单、标签和优先级过滤
+func setLabelCondition(condition bsoncodec.M, projectId string, tags []string, priority *int) error {
+	if projectId != "" {
+		if !bsoncodec.IsObjectIdHex(projectId) {
+			return errors.New("invalid project id")
+		}
+		condition["projectId"] = bsoncodec.ObjectIdHex(projectId)
+	}
+	if len(tags) > 0 {
+		ids, err := parseObjectIds(tags)
+		if err != nil {
+			return errors.New("invalid tag id")
+		}
+		condition["tags"] = bsoncodec.M{
+			"$in": ids,
+		}
+	}
+	if priority != nil {
+		condition["priority"] = *priority
+	}
+	return nil
+}
+
 func DeleteTodo(ctx *gin.Context) {
+	todo, ok := getOwnTodo(ctx, ctx.Param("id"))
+	if !ok {
+		return
+	}
+	err := model.CTodo.DeleteById(ctx, todo.Id)
+	if err != nil {
+		ReturnError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, EmptyResponse{})
+}
+
+func SearchTodos(ctx *gin.Context) {
+	req := SearchTodoRequest{}
+	err := ctx.ShouldBind(&req)
+	if err != nil {
+		ReturnError(ctx, err)
+		return
+	}
+	condition, err := genSearchTodoCondition(util.ExtractUserId(ctx), req)
+	if err != nil {
+		ReturnError(ctx, err)
+		return
+	}
+	req.ListCondition = formatListCondition(req.ListCondition)
+	if len(req.ListCondition.OrderBy) == 0 {
+		req.ListCondition.OrderBy = []string{"-createdAt"}
+	}
+	total, todos, err := model.CTodo.ListByPagination(ctx, condition, req.ListCondition.Page, req.ListCondition.PerPage, req.ListCondition.OrderBy)
+	if err != nil {
+		ReturnError(ctx, err)
+		return
+	}
+	items := make([]TodoDetail, 0, len(todos))
+	for _, todo := range todos {
+		items = append(items, formatTodoDetail(ctx, todo))
+	}
+	ctx.JSON(http.StatusOK, SearchTodoResponse{
+		Total: total,
+		Items: items,
+	})
+}
+
+func genSearchTodoCondition(userId string, req SearchTodoRequest) (bsoncodec.M, error) {
+	condition := model.GenUserTodosCondition(userId)
+	condition["isDeleted"] = false
+	if req.NeedRemind != nil {
+		condition["needRemind"] = *req.NeedRemind
+	}
+	switch req.RepeatType {
+	case "":
+	case "none":
+		condition["remindSetting.isRepeatable"] = false
+	default:
+		condition["remindSetting.isRepeatable"] = true
+		condition["remindSetting.repeatSetting.type"] = req.RepeatType
+	}
+	if keyword := strings.TrimSpace(req.Keyword); keyword != "" {
+		condition["content"] = bsoncodec.M{
+			"$regex":   regexp.QuoteMeta(keyword),
+			"$options": "i",
+		}
+	}
+	if err := setTimeRangeCondition(condition, "remindSetting.remindAt", req.RemindAt); err != nil {
+		return nil, err
+	}
+	if err := setTimeRangeCondition(condition, "dueAt", req.DueAt); err != nil {
+		return nil, err
+	}
+	if err := setLabelCondition(condition, req.ProjectId, req.Tags, req.Priority); err != nil {
+		return nil, err
+	}
+	return condition, nil
+}
+
+// setTimeRangeCondition 时间范围的开始和结束都可以为空
+func setTimeRangeCondition(condition bsoncodec.M, field string, timeRange *TimeRange) error {
+	if timeRange == nil {
+		return nil
+	}
+	rangeCondition := bsoncodec.M{}
+	if timeRange.Start != "" {
+		start, err := util.TransTimeStrToTime(timeRange.Start)
+		if err != nil {
+			return err
+		}
+		rangeCondition["$gte"] = start
+	}
+	if timeRange.End != "" {
+		end, err := util.TransTimeStrToTime(timeRange.End)
+		if err != nil {
+			return err
+		}
+		rangeCondition["$lte"] = end
+	}
+	if len(rangeCondition) > 0 {
+		condition[field] = rangeCondition
+	}
+	return nil
+}
+
+// getOwnTodo 获取当前用户创建的未删除的待办，不存在时返回 404
+func getOwnTodo(ctx *gin.Context, id string) (model.Todo, bool) {
+	if !bsoncodec.IsObjectIdHex(id) {
+		ReturnError(ctx, errors.New("invalid todo id"))
+		return model.Todo{}, false
+	}
+	todo, err := model.CTodo.GetById(ctx, bsoncodec.ObjectIdHex(id))
+	if err != nil {
+		ReturnError(ctx, err)
+		return model.Todo{}, false
+	}
+	if todo.IsDeleted || !todo.IsOwner(util.ExtractUserId(ctx)) {
+		ReturnError(ctx, qmgo.ErrNoSuchDocuments)
+		return model.Todo{}, false
+	}
+	return todo, true
+}
+
+func GetTodoById(ctx *gin.Context) {
 	id := ctx.Param("id")
 	if !bsoncodec.IsObjectIdHex(id) {
 		ReturnError(ctx, errors.New("invalid todo id"))
 		return
 	}
-	err := model.CTodo.DeleteById(ctx, bsoncodec.ObjectIdHex(id))
+	todo, err := model.CTodo.GetById(ctx, bsoncodec.ObjectIdHex(id))
 	if err != nil {
 		ReturnError(ctx, err)
 		return
 	}
-	ctx.JSON(http.StatusOK, EmptyResponse{})
+	if todo.IsDeleted || !todo.IsVisibleTo(util.ExtractUserId(ctx)) {
+		ReturnError(ctx, qmgo.ErrNoSuchDocuments)
+		return
+	}
+	detail := formatTodoDetail(ctx, todo)
+	if record, err := model.CTodoRecord.GetNextByTodoId(ctx, todo.Id); err == nil {
+		recordDetail := formatTodoRecordDetail(ctx, record)
+		detail.NextRecord = &recordDetail
+	}
+	ctx.JSON(http.StatusOK, detail)
+}
+
+func formatTodoDetail(ctx context.Context, todo model.Todo) TodoDetail {
+	exDates := make([]string, 0, len(todo.RemindSetting.RepeatSetting.ExDates))
+	for _, exDate := range todo.RemindSetting.RepeatSetting.ExDates {
+		exDates = append(exDates, util.TransTimeToRFC3339(exDate))
+	}
+	return TodoDetail{
+		Id:                    todo.Id.Hex(),
+		CreatedAt:             util.TransTimeToRFC3339(todo.CreatedAt),
+		UpdatedAt:             util.TransTimeToRFC3339(todo.UpdatedAt),
+		NeedRemind:            todo.NeedRemind,
+		Content:               todo.Content,
+		UserId:                todo.UserId,
+		RemindAt:              util.TransTimeToRFC3339(todo.RemindSetting.RemindAt),
+		IsRepeatable:          todo.RemindSetting.IsRepeatable,
+		RepeatType:            todo.RemindSetting.RepeatSetting.Type,
+		RepeatDateOffset:      todo.RemindSetting.RepeatSetting.DateOffset,
+		RRule:                 todo.RemindSetting.RepeatSetting.RRule,
+		ExDates:               exDates,
+		RemindOffsets:         append([]int64{}, todo.RemindSetting.RemindOffsets...),
+		CatchUpPolicy:         todo.RemindSetting.CatchUpPolicy,
+		Images:                formatImages(ctx, todo.Images),
+		NagSetting:            todo.NagSetting,
+		Timezone:              todo.Timezone,
+		GroupId:               todo.GroupId,
+		Assignees:             append([]string{}, todo.Assignees...),
+		ProjectId:             todo.ProjectId.Hex(),
+		Tags:                  formatObjectIds(todo.Tags),
+		Priority:              todo.Priority,
+		Color:                 todo.Color,
+		Checklist:             formatChecklist(todo.Checklist),
+		AutoCompleteChecklist: todo.AutoCompleteChecklist,
+		DependsOn:             formatObjectIds(todo.DependsOn),
+		DueAt:                 util.TransTimeToRFC3339(todo.DueAt),
+		DueOffset:             todo.DueOffset,
+	}
+}
+
+func formatObjectIds(ids []bsoncodec.ObjectId) []string {
+	result := make([]string, 0, len(ids))
+	for _, id := range ids {
+		result = append(result, id.Hex())
+	}
+	return result
 }
 
 func GenUploadUrl(ctx *gin.Context) {
@@ -204,3 +644,23 @@ func UploadProxy(ctx *gin.Context) {
 		"url":  url,
 	})
 }
+
+// validateGroupAssignees 机器人和创建者都要在群里，被指派的成员也必须是群成员，避免向任意群发送消息或 @ 任意用户
+func validateGroupAssignees(ctx context.Context, userId, groupId string, assignees []string) error {
+	if groupId == "" {
+		return nil
+	}
+	members, err := gocq.GetGocqInstance().ListGroupMembers(ctx, groupId)
+	if err != nil {
+		return fmt.Errorf("failed to get members of group %s: %w", groupId, err)
+	}
+	if !util.StrInArray(userId, &members) {
+		return errors.New("you are not a member of the group")
+	}
+	for _, assignee := range assignees {
+		if !util.StrInArray(assignee, &members) {
+			return fmt.Errorf("assignee %s is not a member of the group", assignee)
+		}
+	}
+	return nil
+}
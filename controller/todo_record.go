@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"github.com/gin-gonic/gin"
+	"github.com/qiniu/qmgo"
 	"net/http"
 	"time"
 	"todo-reminder/model"
@@ -16,11 +17,13 @@ func init() {
 		Endpoint: "/todoRecord/:id/done",
 		Method:   http.MethodPost,
 		Handler:  DoneTodoRecord,
+		Scope:    model.API_SCOPE_DONE_RECORDS,
 	})
 	registerApi(ReminderApi{
 		Endpoint: "/todoRecord/:id/undo",
 		Method:   http.MethodPost,
 		Handler:  UndoTodoRecord,
+		Scope:    model.API_SCOPE_DONE_RECORDS,
 	})
 	registerApi(ReminderApi{
 		Endpoint: "/todoRecord/:id",
@@ -31,27 +34,68 @@ func init() {
 		Endpoint: "/todoRecord/:id",
 		Method:   http.MethodGet,
 		Handler:  GetTodoRecordById,
+		Scope:    model.API_SCOPE_READ_RECORDS,
 	})
 	registerApi(ReminderApi{
 		Endpoint: "/todoRecord/:id/delay",
 		Method:   http.MethodPost,
 		Handler:  DelayTodoRecord,
 	})
+	registerApi(ReminderApi{
+		Endpoint: "/todoRecord/:id/checklist/:itemId/check",
+		Method:   http.MethodPost,
+		Handler:  CheckTodoRecordItem,
+		Scope:    model.API_SCOPE_DONE_RECORDS,
+	})
+	registerApi(ReminderApi{
+		Endpoint: "/todoRecord/:id/checklist/:itemId/uncheck",
+		Method:   http.MethodPost,
+		Handler:  UncheckTodoRecordItem,
+		Scope:    model.API_SCOPE_DONE_RECORDS,
+	})
 	registerApi(ReminderApi{
 		Endpoint: "/todoRecords/search",
 		Method:   http.MethodPost,
 		Handler:  ListTodoRecords,
+		Scope:    model.API_SCOPE_READ_RECORDS,
 	})
 }
 
-func DoneTodoRecord(ctx *gin.Context) {
-	id := ctx.Param("id")
+// getVisibleRecord 获取当前用户创建的或者被指派的记录，其他用户的记录视为不存在
+func getVisibleRecord(ctx *gin.Context, id string) (model.TodoRecord, bool) {
 	if !bsoncodec.IsObjectIdHex(id) {
 		ReturnError(ctx, errors.New("invalid id"))
-		return
+		return model.TodoRecord{}, false
 	}
-	err := model.CTodoRecord.Done(ctx, bsoncodec.ObjectIdHex(id))
+	record, err := model.CTodoRecord.GetById(ctx, bsoncodec.ObjectIdHex(id))
 	if err != nil {
+		ReturnError(ctx, err)
+		return model.TodoRecord{}, false
+	}
+	if userId := util.ExtractUserId(ctx); !record.IsOwner(userId) && !record.IsAssignee(userId) {
+		ReturnError(ctx, qmgo.ErrNoSuchDocuments)
+		return model.TodoRecord{}, false
+	}
+	return record, true
+}
+
+// getOwnRecord 获取当前用户创建的记录，被指派的成员不能删除或推迟
+func getOwnRecord(ctx *gin.Context, id string) (model.TodoRecord, bool) {
+	record, ok := getVisibleRecord(ctx, id)
+	if ok && !record.IsOwner(util.ExtractUserId(ctx)) {
+		ReturnError(ctx, qmgo.ErrNoSuchDocuments)
+		return model.TodoRecord{}, false
+	}
+	return record, ok
+}
+
+func DoneTodoRecord(ctx *gin.Context) {
+	record, ok := getVisibleRecord(ctx, ctx.Param("id"))
+	if !ok {
+		return
+	}
+	// 被指派的成员只能标记自己完成
+	if err := model.CTodoRecord.DoneByUser(ctx, record, util.ExtractUserId(ctx)); err != nil {
 		ReturnError(ctx, err)
 		return
 	}
@@ -59,21 +103,50 @@ func DoneTodoRecord(ctx *gin.Context) {
 }
 
 func UndoTodoRecord(ctx *gin.Context) {
-	id := ctx.Param("id")
-	if !bsoncodec.IsObjectIdHex(id) {
+	record, ok := getVisibleRecord(ctx, ctx.Param("id"))
+	if !ok {
+		return
+	}
+	if userId := util.ExtractUserId(ctx); !record.IsOwner(userId) {
+		model.CTodoRecord.UndoByAssignee(ctx, record.Id, userId)
+		return
+	}
+	model.CTodoRecord.Undo(ctx, record.Id)
+}
+
+func CheckTodoRecordItem(ctx *gin.Context) {
+	checkTodoRecordItem(ctx, true)
+}
+
+func UncheckTodoRecordItem(ctx *gin.Context) {
+	checkTodoRecordItem(ctx, false)
+}
+
+// checkTodoRecordItem 创建者和被指派的成员都可以修改子任务的完成状态
+func checkTodoRecordItem(ctx *gin.Context, isChecked bool) {
+	itemId := ctx.Param("itemId")
+	if !bsoncodec.IsObjectIdHex(itemId) {
 		ReturnError(ctx, errors.New("invalid id"))
 		return
 	}
-	model.CTodoRecord.Undo(ctx, bsoncodec.ObjectIdHex(id))
+	record, ok := getVisibleRecord(ctx, ctx.Param("id"))
+	if !ok {
+		return
+	}
+	err := model.CTodoRecord.CheckItem(ctx, record.Id, bsoncodec.ObjectIdHex(itemId), util.ExtractUserId(ctx), isChecked)
+	if err != nil {
+		ReturnError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, EmptyResponse{})
 }
 
 func DeleteOneRecord(ctx *gin.Context) {
-	id := ctx.Param("id")
-	if !bsoncodec.IsObjectIdHex(id) {
-		ReturnError(ctx, errors.New("invalid id"))
+	record, ok := getOwnRecord(ctx, ctx.Param("id"))
+	if !ok {
 		return
 	}
-	err := model.CTodoRecord.Delete(ctx, bsoncodec.ObjectIdHex(id))
+	err := model.CTodoRecord.Delete(ctx, record.Id)
 	if err != nil {
 		ReturnError(ctx, err)
 		return
@@ -86,9 +159,8 @@ type DelayTodoRecordRequest struct {
 }
 
 func DelayTodoRecord(ctx *gin.Context) {
-	id := ctx.Param("id")
-	if !bsoncodec.IsObjectIdHex(id) {
-		ReturnError(ctx, errors.New("invalid id"))
+	record, ok := getOwnRecord(ctx, ctx.Param("id"))
+	if !ok {
 		return
 	}
 	req := DelayTodoRecordRequest{}
@@ -97,7 +169,7 @@ func DelayTodoRecord(ctx *gin.Context) {
 		ReturnError(ctx, err)
 		return
 	}
-	err = model.CTodoRecord.Delay(ctx, bsoncodec.ObjectIdHex(id), time.Second*time.Duration(req.Second))
+	err = model.CTodoRecord.Delay(ctx, record.Id, time.Second*time.Duration(req.Second))
 	if err != nil {
 		ReturnError(ctx, err)
 		return
@@ -106,7 +178,19 @@ func DelayTodoRecord(ctx *gin.Context) {
 }
 
 type ListTodoRecordsRequest struct {
-	HasBeenDone   bool          `json:"hasBeenDone"`
+	HasBeenDone bool `json:"hasBeenDone"`
+	// 按空格分隔的关键词，匹配任意一个即可
+	Keyword      string     `json:"keyword"`
+	RemindAt     *TimeRange `json:"remindAt"`
+	DoneAt       *TimeRange `json:"doneAt"`
+	IsRepeatable *bool      `json:"isRepeatable"`
+	TodoId       string     `json:"todoId"`
+	DueAt        *TimeRange `json:"dueAt"`
+	IsOverdue    *bool      `json:"isOverdue"`
+	ProjectId    string     `json:"projectId"`
+	// 包含任意一个标签即可
+	Tags          []string      `json:"tags"`
+	Priority      *int          `json:"priority"`
 	ListCondition ListCondition `json:"listCondition"`
 }
 
@@ -121,17 +205,43 @@ type ListTodoRecordsResponse struct {
 	Total int64              `json:"total"`
 }
 type TodoRecordDetail struct {
-	Id               string  `json:"id"`
-	RemindAt         string  `json:"remindAt"`
-	HasBeenDone      bool    `json:"hasBeenDone"`
-	Content          string  `json:"content"`
-	DoneAt           string  `json:"doneAt"`
-	NeedRemind       bool    `json:"needRemind"`
-	IsRepeatable     bool    `json:"isRepeatable"`
-	RepeatType       string  `json:"repeatType"`
-	RepeatDateOffset int     `json:"repeatDateOffset"`
-	TodoId           string  `json:"todoId"`
-	Images           []Image `json:"images"`
+	Id               string                `json:"id"`
+	RemindAt         string                `json:"remindAt"`
+	HasBeenDone      bool                  `json:"hasBeenDone"`
+	Content          string                `json:"content"`
+	DoneAt           string                `json:"doneAt"`
+	NeedRemind       bool                  `json:"needRemind"`
+	IsRepeatable     bool                  `json:"isRepeatable"`
+	RepeatType       string                `json:"repeatType"`
+	RepeatDateOffset int                   `json:"repeatDateOffset"`
+	RepeatRRule      string                `json:"repeatRRule"`
+	TodoId           string                `json:"todoId"`
+	Images           []Image               `json:"images"`
+	Reminders        []ReminderDetail      `json:"reminders"`
+	NagCount         int                   `json:"nagCount"`
+	NextNagAt        string                `json:"nextNagAt"`
+	GroupId          string                `json:"groupId"`
+	Assignees        []AssigneeDetail      `json:"assignees"`
+	ProjectId        string                `json:"projectId"`
+	Tags             []string              `json:"tags"`
+	Priority         int                   `json:"priority"`
+	Color            string                `json:"color"`
+	Checklist        []ChecklistItemDetail `json:"checklist"`
+	DueAt            string                `json:"dueAt"`
+	IsOverdue        bool                  `json:"isOverdue"`
+	OverdueAt        string                `json:"overdueAt"`
+}
+
+type AssigneeDetail struct {
+	UserId      string `json:"userId"`
+	HasBeenDone bool   `json:"hasBeenDone"`
+	DoneAt      string `json:"doneAt"`
+}
+
+type ReminderDetail struct {
+	Offset          int64  `json:"offset"`
+	RemindAt        string `json:"remindAt"`
+	HasBeenReminded bool   `json:"hasBeenReminded"`
 }
 
 type Image struct {
@@ -156,11 +266,10 @@ func ListTodoRecords(ctx *gin.Context) {
 		ReturnError(ctx, err)
 		return
 	}
-	userId := util.ExtractUserId(ctx)
-	condition := bsoncodec.M{
-		"isDeleted":   false,
-		"hasBeenDone": req.HasBeenDone,
-		"userId":      userId,
+	condition, err := genListTodoRecordsCondition(util.ExtractUserId(ctx), req)
+	if err != nil {
+		ReturnError(ctx, err)
+		return
 	}
 	req.ListCondition = formatListCondition(req.ListCondition)
 	total, todoRecords, err := model.CTodoRecord.ListByPagination(ctx, condition, req.ListCondition.Page, req.ListCondition.PerPage, req.ListCondition.OrderBy)
@@ -174,15 +283,44 @@ func ListTodoRecords(ctx *gin.Context) {
 	})
 }
 
-func GetTodoRecordById(ctx *gin.Context) {
-	id := ctx.Param("id")
-	if !bsoncodec.IsObjectIdHex(id) {
-		ReturnError(ctx, errors.New("invalid id"))
-		return
+func genListTodoRecordsCondition(userId string, req ListTodoRecordsRequest) (bsoncodec.M, error) {
+	condition := model.GenUserRecordsCondition(userId)
+	condition["isDeleted"] = false
+	condition["hasBeenDone"] = req.HasBeenDone
+	// 已经使用 $or 匹配用户，关键词条件放在 $and 中
+	if keywordCondition := model.GenKeywordCondition(req.Keyword); keywordCondition != nil {
+		condition["$and"] = []bsoncodec.M{keywordCondition}
 	}
-	record, err := model.CTodoRecord.GetById(ctx, bsoncodec.ObjectIdHex(id))
-	if err != nil {
-		ReturnError(ctx, err)
+	if req.IsRepeatable != nil {
+		condition["isRepeatable"] = *req.IsRepeatable
+	}
+	if req.IsOverdue != nil {
+		condition["isOverdue"] = *req.IsOverdue
+	}
+	if req.TodoId != "" {
+		if !bsoncodec.IsObjectIdHex(req.TodoId) {
+			return nil, errors.New("invalid todo id")
+		}
+		condition["todoId"] = bsoncodec.ObjectIdHex(req.TodoId)
+	}
+	if err := setTimeRangeCondition(condition, "remindAt", req.RemindAt); err != nil {
+		return nil, err
+	}
+	if err := setTimeRangeCondition(condition, "doneAt", req.DoneAt); err != nil {
+		return nil, err
+	}
+	if err := setTimeRangeCondition(condition, "dueAt", req.DueAt); err != nil {
+		return nil, err
+	}
+	if err := setLabelCondition(condition, req.ProjectId, req.Tags, req.Priority); err != nil {
+		return nil, err
+	}
+	return condition, nil
+}
+
+func GetTodoRecordById(ctx *gin.Context) {
+	record, ok := getVisibleRecord(ctx, ctx.Param("id"))
+	if !ok {
 		return
 	}
 	ctx.JSON(http.StatusOK, formatTodoRecordDetail(ctx, record))
@@ -199,19 +337,59 @@ func formatTodoRecordDetail(ctx context.Context, record model.TodoRecord) TodoRe
 		IsRepeatable:     record.IsRepeatable,
 		RepeatType:       record.RepeatType,
 		RepeatDateOffset: record.RepeatDateOffset,
+		RepeatRRule:      record.RepeatRRule,
 		TodoId:           record.TodoId.Hex(),
-		Images: func() []Image {
-			result := make([]Image, 0, len(record.Images))
-			for _, image := range record.Images {
-				url, _ := util.MinioClient.SignObjectUrl(ctx, image)
-				result = append(result, Image{
-					Name: image,
-					Url:  url,
+		Images:           formatImages(ctx, record.Images),
+		Reminders: func() []ReminderDetail {
+			if !record.NeedRemind {
+				return []ReminderDetail{}
+			}
+			reminders := record.GetReminders()
+			result := make([]ReminderDetail, 0, len(reminders))
+			for _, reminder := range reminders {
+				result = append(result, ReminderDetail{
+					Offset:          reminder.Offset,
+					RemindAt:        util.TransTimeToRFC3339(reminder.RemindAt),
+					HasBeenReminded: reminder.HasBeenReminded,
 				})
 			}
 			return result
 		}(),
+		NagCount:  record.NagCount,
+		NextNagAt: util.TransTimeToRFC3339(record.NextNagAt),
+		GroupId:   record.GroupId,
+		Assignees: func() []AssigneeDetail {
+			result := make([]AssigneeDetail, 0, len(record.Assignees))
+			for _, assignee := range record.Assignees {
+				result = append(result, AssigneeDetail{
+					UserId:      assignee.UserId,
+					HasBeenDone: assignee.HasBeenDone,
+					DoneAt:      util.TransTimeToRFC3339(assignee.DoneAt),
+				})
+			}
+			return result
+		}(),
+		ProjectId: record.ProjectId.Hex(),
+		Tags:      formatObjectIds(record.Tags),
+		Priority:  record.Priority,
+		Color:     record.Color,
+		Checklist: formatChecklist(record.Checklist),
+		DueAt:     util.TransTimeToRFC3339(record.DueAt),
+		IsOverdue: record.IsOverdue,
+		OverdueAt: util.TransTimeToRFC3339(record.OverdueAt),
+	}
+}
+
+func formatImages(ctx context.Context, images []string) []Image {
+	result := make([]Image, 0, len(images))
+	for _, image := range images {
+		url, _ := util.MinioClient.SignObjectUrl(ctx, image)
+		result = append(result, Image{
+			Name: image,
+			Url:  url,
+		})
 	}
+	return result
 }
 
 func formatTodoRecordDetails(ctx context.Context, records []model.TodoRecord) []TodoRecordDetail {
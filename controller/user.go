@@ -1,11 +1,17 @@
 package controller
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"github.com/gin-gonic/gin"
 	"net/http"
-	"todo-reminder/gocq"
+	"strings"
+	"time"
+	"todo-reminder/constant"
 	"todo-reminder/model"
+	"todo-reminder/notifier"
+	"todo-reminder/repository/bsoncodec"
 	"todo-reminder/util"
 )
 
@@ -17,9 +23,15 @@ func init() {
 		NoAuth:   true,
 	})
 	registerApi(ReminderApi{
-		Endpoint: "/user/:userId/genPassword",
-		Method:   http.MethodGet,
-		Handler:  GetDefaultPassword,
+		Endpoint: "/user/loginCode",
+		Method:   http.MethodPost,
+		Handler:  SendLoginCode,
+		NoAuth:   true,
+	})
+	registerApi(ReminderApi{
+		Endpoint: "/user/login/code",
+		Method:   http.MethodPost,
+		Handler:  LoginWithCode,
 		NoAuth:   true,
 	})
 	registerApi(ReminderApi{
@@ -32,12 +44,43 @@ func init() {
 		Method:   http.MethodGet,
 		Handler:  GetCurrentUserId,
 	})
+	registerApi(ReminderApi{
+		Endpoint: "/user/settings",
+		Method:   http.MethodGet,
+		Handler:  GetUserSettings,
+	})
+	registerApi(ReminderApi{
+		Endpoint: "/user/settings",
+		Method:   http.MethodPut,
+		Handler:  UpdateUserSettings,
+	})
 	registerApi(ReminderApi{
 		Endpoint: "/user/validToken",
 		Method:   http.MethodPost,
 		Handler:  ValidToken,
 		NoAuth:   true,
 	})
+	registerApi(ReminderApi{
+		Endpoint: "/user/token/refresh",
+		Method:   http.MethodPost,
+		Handler:  RefreshToken,
+		NoAuth:   true,
+	})
+	registerApi(ReminderApi{
+		Endpoint: "/user/logout",
+		Method:   http.MethodPost,
+		Handler:  Logout,
+	})
+	registerApi(ReminderApi{
+		Endpoint: "/user/logoutAll",
+		Method:   http.MethodPost,
+		Handler:  LogoutAll,
+	})
+	registerApi(ReminderApi{
+		Endpoint: "/user/authEvents/search",
+		Method:   http.MethodPost,
+		Handler:  SearchAuthEvents,
+	})
 }
 
 type LoginRequest struct {
@@ -46,7 +89,44 @@ type LoginRequest struct {
 }
 
 type LoginResponse struct {
-	Token string `json:"token"`
+	// access token，放在 x-access-token 请求头中
+	Token        string `json:"token"`
+	RefreshToken string `json:"refreshToken"`
+	ExpiredAt    string `json:"expiredAt"`
+}
+
+type LoginCodeRequest struct {
+	UserId string `json:"userId" binding:"required"`
+}
+
+type LoginWithCodeRequest struct {
+	UserId string `json:"userId" binding:"required"`
+	Code   string `json:"code" binding:"required"`
+}
+
+type SearchAuthEventsRequest struct {
+	Event         string        `json:"event"`
+	CreatedAt     *TimeRange    `json:"createdAt"`
+	ListCondition ListCondition `json:"listCondition"`
+}
+
+type AuthEventDetail struct {
+	Id        string `json:"id"`
+	Event     string `json:"event"`
+	SessionId string `json:"sessionId"`
+	Ip        string `json:"ip"`
+	UserAgent string `json:"userAgent"`
+	Detail    string `json:"detail"`
+	CreatedAt string `json:"createdAt"`
+}
+
+type SearchAuthEventsResponse struct {
+	Total int64             `json:"total"`
+	Items []AuthEventDetail `json:"items"`
+}
+
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refreshToken" binding:"required"`
 }
 
 type EmptyResponse struct {
@@ -59,14 +139,88 @@ func Login(ctx *gin.Context) {
 		ReturnError(ctx, err)
 		return
 	}
-	token, err := model.CUser.Login(ctx, req.UserId, req.Password)
+	pair, err := model.CUser.Login(ctx, req.UserId, req.Password, ctx.Request.UserAgent(), ctx.ClientIP())
 	if err != nil {
 		ReturnError(ctx, err)
 		return
 	}
-	ctx.JSON(http.StatusOK, map[string]string{
-		"token": token,
-	})
+	ctx.JSON(http.StatusOK, formatLoginResponse(pair))
+}
+
+func formatLoginResponse(pair model.TokenPair) LoginResponse {
+	return LoginResponse{
+		Token:        pair.AccessToken,
+		RefreshToken: pair.RefreshToken,
+		ExpiredAt:    util.TransTimeToRFC3339(pair.ExpiredAt),
+	}
+}
+
+// RefreshToken 使用 refresh token 换取新的 access token 和 refresh token
+func RefreshToken(ctx *gin.Context) {
+	req := RefreshTokenRequest{}
+	if err := ctx.ShouldBind(&req); err != nil {
+		ReturnError(ctx, err)
+		return
+	}
+	pair, userId, err := model.CSession.Refresh(ctx, req.RefreshToken)
+	if err != nil {
+		model.CAuthEvent.Record(ctx, model.AUTH_EVENT_TOKEN_REFRESH_FAILED, userId, "", err.Error())
+		ReturnError(ctx, err)
+		return
+	}
+	model.CAuthEvent.Record(ctx, model.AUTH_EVENT_TOKEN_REFRESHED, userId, pair.SessionId, "")
+	ctx.JSON(http.StatusOK, formatLoginResponse(pair))
+}
+
+// SendLoginCode 通过 QQ 发送一次性登录验证码，用户不存在时同样返回成功
+func SendLoginCode(ctx *gin.Context) {
+	req := LoginCodeRequest{}
+	if err := ctx.ShouldBind(&req); err != nil {
+		ReturnError(ctx, err)
+		return
+	}
+	if err := model.CLoginCode.Request(ctx, req.UserId, ctx.ClientIP()); err != nil {
+		ReturnError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, EmptyResponse{})
+}
+
+func LoginWithCode(ctx *gin.Context) {
+	req := LoginWithCodeRequest{}
+	if err := ctx.ShouldBind(&req); err != nil {
+		ReturnError(ctx, err)
+		return
+	}
+	pair, err := model.CUser.LoginWithCode(ctx, req.UserId, req.Code, ctx.Request.UserAgent(), ctx.ClientIP())
+	if err != nil {
+		ReturnError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, formatLoginResponse(pair))
+}
+
+// Logout 注销当前会话
+func Logout(ctx *gin.Context) {
+	sessionId := util.ExtractSessionId(ctx)
+	err := model.CSession.Revoke(ctx, bsoncodec.ObjectIdHex(sessionId))
+	if err != nil {
+		ReturnError(ctx, err)
+		return
+	}
+	model.CAuthEvent.Record(ctx, model.AUTH_EVENT_LOGOUT, util.ExtractUserId(ctx), sessionId, "")
+	ctx.JSON(http.StatusOK, EmptyResponse{})
+}
+
+// LogoutAll 注销所有设备上的会话，包括当前会话
+func LogoutAll(ctx *gin.Context) {
+	err := model.CSession.RevokeAllByUserId(ctx, util.ExtractUserId(ctx), "")
+	if err != nil {
+		ReturnError(ctx, err)
+		return
+	}
+	model.CAuthEvent.Record(ctx, model.AUTH_EVENT_LOGOUT_ALL, util.ExtractUserId(ctx), util.ExtractSessionId(ctx), "")
+	ctx.JSON(http.StatusOK, EmptyResponse{})
 }
 
 type UpdatePasswordRequest struct {
@@ -89,33 +243,96 @@ func UpdatePassword(ctx *gin.Context) {
 		ReturnError(ctx, err)
 		return
 	}
+	// 修改密码后其他设备需要重新登录
+	err = model.CSession.RevokeAllByUserId(ctx, userId, bsoncodec.ObjectIdHex(util.ExtractSessionId(ctx)))
+	if err != nil {
+		ReturnError(ctx, err)
+		return
+	}
+	model.CAuthEvent.Record(ctx, model.AUTH_EVENT_PASSWORD_CHANGED, userId, util.ExtractSessionId(ctx), "")
 	ctx.JSON(http.StatusOK, EmptyResponse{})
 }
 
-func GetDefaultPassword(ctx *gin.Context) {
-	userId := ctx.Param("userId")
-	_, err := model.CUser.GetByUserId(ctx, userId)
+func ValidToken(ctx *gin.Context) {
+	tokenStr := ctx.GetHeader(constant.HEADER_TOKEN)
+	token, err := util.ParseToken(tokenStr)
 	if err != nil {
 		ReturnError(ctx, err)
 		return
 	}
-	password := util.GenRandomString(10)
-	err = model.CUser.UpdatePassword(ctx, userId, password)
-	if err != nil {
-		ReturnError(ctx, err)
+	if err := model.CSession.CheckActive(ctx, token.SessionId); err != nil {
+		ReturnError(ctx, model.ErrSessionRevoked)
 		return
 	}
-	err = gocq.GetGocqInstance().SendPrivateStringMessage(ctx, password, userId)
+	ctx.JSON(http.StatusOK, EmptyResponse{})
+}
+
+func GetCurrentUserId(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, map[string]string{
+		"userId": util.ExtractUserId(ctx),
+	})
+}
+
+type UserSettings struct {
+	NotifyChannels []model.NotifyChannel `json:"notifyChannels"`
+	Timezone       *string               `json:"timezone"`
+	OverdueDigest  *model.OverdueDigest  `json:"overdueDigest"`
+}
+
+func GetUserSettings(ctx *gin.Context) {
+	user, err := model.CUser.GetByUserId(ctx, util.ExtractUserId(ctx))
 	if err != nil {
 		ReturnError(ctx, err)
 		return
 	}
-	ctx.JSON(http.StatusOK, EmptyResponse{})
+	channels := user.NotifyChannels
+	if channels == nil {
+		channels = []model.NotifyChannel{}
+	}
+	ctx.JSON(http.StatusOK, UserSettings{
+		NotifyChannels: channels,
+		Timezone:       &user.Timezone,
+		OverdueDigest:  &user.OverdueDigest,
+	})
 }
 
-func ValidToken(ctx *gin.Context) {
-	tokenStr := ctx.GetHeader("x-access-token")
-	_, err := util.ParseToken(tokenStr)
+// UpdateUserSettings 只更新请求中出现的设置项
+func UpdateUserSettings(ctx *gin.Context) {
+	userId := util.ExtractUserId(ctx)
+	req := UserSettings{}
+	if err := ctx.ShouldBind(&req); err != nil {
+		ReturnError(ctx, err)
+		return
+	}
+	setter := bsoncodec.M{
+		"updatedAt": time.Now(),
+	}
+	if req.NotifyChannels != nil {
+		for _, channel := range req.NotifyChannels {
+			if err := validateNotifyChannel(ctx, channel); err != nil {
+				ReturnError(ctx, err)
+				return
+			}
+		}
+		setter["notifyChannels"] = req.NotifyChannels
+	}
+	if req.Timezone != nil {
+		if *req.Timezone != "" && !util.IsValidTimezone(*req.Timezone) {
+			ReturnError(ctx, errors.New("invalid timezone"))
+			return
+		}
+		setter["timezone"] = *req.Timezone
+	}
+	if req.OverdueDigest != nil {
+		if err := req.OverdueDigest.Validate(); err != nil {
+			ReturnError(ctx, err)
+			return
+		}
+		setter["overdueDigest"] = *req.OverdueDigest
+	}
+	err := model.CUser.UpdateByUserId(ctx, userId, bsoncodec.M{
+		"$set": setter,
+	})
 	if err != nil {
 		ReturnError(ctx, err)
 		return
@@ -123,8 +340,67 @@ func ValidToken(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, EmptyResponse{})
 }
 
-func GetCurrentUserId(ctx *gin.Context) {
-	ctx.JSON(http.StatusOK, map[string]string{
+func validateNotifyChannel(ctx context.Context, channel model.NotifyChannel) error {
+	if !notifier.IsSupported(channel.Type) {
+		return fmt.Errorf("unsupported notify channel %s", channel.Type)
+	}
+	switch channel.Type {
+	case notifier.CHANNEL_QQ:
+		return nil
+	case notifier.CHANNEL_EMAIL:
+		if !strings.Contains(channel.Target, "@") {
+			return errors.New("invalid email address")
+		}
+	case notifier.CHANNEL_WEBHOOK:
+		return notifier.ValidateWebhookUrl(ctx, channel.Target)
+	default:
+		if channel.Target == "" {
+			return errors.New("empty notify target")
+		}
+	}
+	return nil
+}
+
+// SearchAuthEvents 查询当前用户的认证记录，默认按时间倒序
+func SearchAuthEvents(ctx *gin.Context) {
+	req := SearchAuthEventsRequest{}
+	if err := ctx.ShouldBind(&req); err != nil {
+		ReturnError(ctx, err)
+		return
+	}
+	condition := bsoncodec.M{
 		"userId": util.ExtractUserId(ctx),
+	}
+	if req.Event != "" {
+		condition["event"] = req.Event
+	}
+	if err := setTimeRangeCondition(condition, "createdAt", req.CreatedAt); err != nil {
+		ReturnError(ctx, err)
+		return
+	}
+	req.ListCondition = formatListCondition(req.ListCondition)
+	if len(req.ListCondition.OrderBy) == 0 {
+		req.ListCondition.OrderBy = []string{"-createdAt"}
+	}
+	total, events, err := model.CAuthEvent.ListByPagination(ctx, condition, req.ListCondition.Page, req.ListCondition.PerPage, req.ListCondition.OrderBy)
+	if err != nil {
+		ReturnError(ctx, err)
+		return
+	}
+	items := make([]AuthEventDetail, 0, len(events))
+	for _, event := range events {
+		items = append(items, AuthEventDetail{
+			Id:        event.Id.Hex(),
+			Event:     event.Event,
+			SessionId: event.SessionId,
+			Ip:        event.Ip,
+			UserAgent: event.UserAgent,
+			Detail:    event.Detail,
+			CreatedAt: util.TransTimeToRFC3339(event.CreatedAt),
+		})
+	}
+	ctx.JSON(http.StatusOK, SearchAuthEventsResponse{
+		Total: total,
+		Items: items,
 	})
 }
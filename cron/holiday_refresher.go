@@ -16,7 +16,8 @@ const (
 )
 
 func init() {
-	registerCronTask("@weekly", RefreshHoliday, true)
+	// 租约过期前启动的实例不会重复刷新
+	registerLeasedCronTask("@weekly", "refreshHoliday", time.Hour, RefreshHoliday, true)
 }
 
 // TimorHolidayResponse https://timor.tech/api/holiday
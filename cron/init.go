@@ -1,7 +1,11 @@
 package cron
 
 import (
+	"context"
 	"github.com/robfig/cron/v3"
+	"time"
+	"todo-reminder/log"
+	"todo-reminder/model"
 	"todo-reminder/util"
 )
 
@@ -24,6 +28,58 @@ func registerCronTask(spec string, fn func(), needRunAtStart bool) {
 	})
 }
 
+// registerLeasedCronTask 多个实例同时运行时，只有持有租约 name 的实例会执行任务
+// 持有者每次执行时续期，ttl 应大于任务的执行间隔，持有者退出后其他实例在租约过期后接管
+func registerLeasedCronTask(spec string, name string, ttl time.Duration, fn func(), needRunAtStart bool) {
+	registerCronTask(spec, withLease(name, ttl, fn), needRunAtStart)
+}
+
+// withLease 任务执行期间每隔 ttl/3 续期一次，避免执行时间超过 ttl 时其他实例同时执行
+func withLease(name string, ttl time.Duration, fn func()) func() {
+	return func() {
+		ctx := context.Background()
+		if !holdLease(ctx, name, ttl) {
+			return
+		}
+		done := make(chan struct{})
+		defer close(done)
+		go renewLease(ctx, name, ttl, done)
+		fn()
+	}
+}
+
+func renewLease(ctx context.Context, name string, ttl time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if !holdLease(ctx, name, ttl) {
+				log.Warn("Lost lease while task is running", map[string]interface{}{
+					"name":       name,
+					"instanceId": util.InstanceId,
+				})
+			}
+		}
+	}
+}
+
+// holdLease 获取或续期租约，获取失败时返回 false
+func holdLease(ctx context.Context, name string, ttl time.Duration) bool {
+	ok, err := model.CLease.Acquire(ctx, name, util.InstanceId, ttl)
+	if err != nil {
+		log.Warn("Failed to acquire lease", map[string]interface{}{
+			"name":       name,
+			"instanceId": util.InstanceId,
+			"error":      err.Error(),
+		})
+		return false
+	}
+	return ok
+}
+
 func Start() {
 	c = cron.New()
 	for _, task := range tasks {
@@ -35,5 +91,6 @@ func Start() {
 			go task.fn()
 		}
 	}
+	go scheduler.run()
 	c.Start()
 }
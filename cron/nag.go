@@ -0,0 +1,43 @@
+package cron
+
+import (
+	"context"
+	"fmt"
+	"time"
+	"todo-reminder/log"
+	"todo-reminder/model"
+)
+
+func init() {
+	registerLeasedCronTask("@every 20s", "nag", time.Minute, Nag, false)
+}
+
+// Nag 对已经提醒过但仍未完成的记录重复提醒，直到完成或达到次数上限
+func Nag() {
+	ctx := context.Background()
+	records, err := model.CTodoRecord.ListNeedNagOnes(ctx)
+	if err != nil {
+		return
+	}
+	for _, record := range records {
+		now := record.Now()
+		if !record.NagSetting.IsEnabled || record.NagCount >= record.NagSetting.MaxTimes || !model.CUser.IsActiveUser(ctx, record.UserId) {
+			record.StopNagging(ctx)
+			continue
+		}
+		if record.NagSetting.QuietHours.Contains(now) {
+			record.PostponeNag(ctx, record.NagSetting.QuietHours.GetEndTime(now))
+			continue
+		}
+		dedupeKey := fmt.Sprintf("%s:nag:%d", record.Id.Hex(), record.NextNagAt.Unix())
+		if err := record.Notify(ctx, record.FormatNagMessage(), dedupeKey); err != nil {
+			continue
+		}
+		if err := record.MarkAsNagged(ctx); err != nil {
+			log.Warn("Failed to mark record as nagged", map[string]interface{}{
+				"recordId": record.Id.Hex(),
+				"error":    err.Error(),
+			})
+		}
+	}
+}
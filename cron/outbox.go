@@ -0,0 +1,14 @@
+package cron
+
+import (
+	"context"
+	"todo-reminder/gocq"
+)
+
+func init() {
+	registerCronTask("@every 3s", DispatchOutboundMessages, true)
+}
+
+func DispatchOutboundMessages() {
+	gocq.DispatchOutboundMessages(context.Background())
+}
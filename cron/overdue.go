@@ -0,0 +1,57 @@
+package cron
+
+import (
+	"context"
+	"fmt"
+	"time"
+	"todo-reminder/gocq"
+	"todo-reminder/log"
+	"todo-reminder/model"
+)
+
+func init() {
+	registerLeasedCronTask("@every 1m", "markOverdue", 3*time.Minute, MarkOverdue, true)
+	registerLeasedCronTask("@every 1m", "overdueDigest", 3*time.Minute, SendOverdueDigests, false)
+}
+
+// MarkOverdue 标记已经过了截止时间但仍未完成的记录
+func MarkOverdue() {
+	_, err := model.CTodoRecord.MarkOverdueOnes(context.Background(), time.Now())
+	if err != nil {
+		log.Warn("Failed to mark overdue records", map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+}
+
+// SendOverdueDigests 到达用户设置的时间后每天发送一次逾期待办的数量，没有逾期待办时不发送
+func SendOverdueDigests() {
+	ctx := context.Background()
+	users, err := model.CUser.ListOverdueDigestEnabledOnes(ctx)
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	for _, user := range users {
+		date := user.GetOverdueDigestDate(now)
+		if date == "" {
+			continue
+		}
+		total, records, err := model.CTodoRecord.ListOverdueOnesByUserId(ctx, user.UserId)
+		if err != nil {
+			continue
+		}
+		if total > 0 {
+			m := gocq.NewPrivateMessage(user.UserId, model.FormatOverdueDigestMessage(total, records))
+			m.DedupeKey = fmt.Sprintf("overdueDigest:%s:%s", user.UserId, date)
+			if err := gocq.Enqueue(ctx, m); err != nil {
+				log.Warn("Failed to enqueue overdue digest", map[string]interface{}{
+					"userId": user.UserId,
+					"error":  err.Error(),
+				})
+				continue
+			}
+		}
+		user.MarkOverdueDigestSent(ctx, date)
+	}
+}
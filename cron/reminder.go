@@ -2,30 +2,87 @@ package cron
 
 import (
 	"context"
+	"fmt"
+	"sync"
+	"time"
+	"todo-reminder/log"
 	"todo-reminder/model"
 	"todo-reminder/repository/bsoncodec"
 )
 
+const (
+	REMIND_LEASE_NAME = "remind"
+	remindLeaseTTL    = 3 * time.Minute
+)
+
+var (
+	// 定时轮询和 scheduler 可能同时处理同一条记录，正在处理的记录直接跳过
+	// 只锁单条记录，某个渠道响应慢时不影响其他记录的提醒
+	remindingRecords = &sync.Map{}
+)
+
 func init() {
-	registerCronTask("@every 20s", Remind, false)
+	registerLeasedCronTask("@every 1m", REMIND_LEASE_NAME, remindLeaseTTL, Remind, false)
 }
 
+// Remind 兜底轮询，发送 scheduler 遗漏的提醒，如其他实例创建的记录，并加载接下来一段时间内的提醒
 func Remind() {
 	ctx := context.Background()
 	records, err := model.CTodoRecord.ListNeedRemindOnes(ctx)
 	if err != nil {
 		return
 	}
-	var succeedIds []bsoncodec.ObjectId
-	var succeedTodoIds []bsoncodec.ObjectId
 	for _, record := range records {
-		if err := record.Notify(ctx); err == nil {
-			succeedIds = append(succeedIds, record.Id)
-			succeedTodoIds = append(succeedTodoIds, record.TodoId)
+		remindRecord(ctx, record.Id)
+	}
+	scheduler.load(ctx)
+}
+
+// remindRecord 重新读取记录后发送到期的提醒，轮询读取的记录可能已经被 scheduler 处理过
+func remindRecord(ctx context.Context, id bsoncodec.ObjectId) {
+	if _, loaded := remindingRecords.LoadOrStore(id.Hex(), true); loaded {
+		return
+	}
+	defer remindingRecords.Delete(id.Hex())
+	record, err := model.CTodoRecord.GetById(ctx, id)
+	if err != nil {
+		return
+	}
+	if record.IsDeleted || record.HasBeenDone || !record.NeedRemind {
+		return
+	}
+	reminders, err := record.ApplyCatchUpPolicy(ctx, record.GetDueReminders(time.Now()), time.Now())
+	if err != nil {
+		log.Warn("Failed to apply catch up policy", map[string]interface{}{
+			"recordId": record.Id.Hex(),
+			"error":    err.Error(),
+		})
+		return
+	}
+	// 用户被禁用或删除后不再发送提醒，直接标记为已提醒
+	isActive := model.CUser.IsActiveUser(ctx, record.UserId)
+	for _, reminder := range reminders {
+		if !isActive {
+			record.MarkReminderAsReminded(ctx, reminder.Offset)
+			continue
+		}
+		// QQ 消息的重试由发送队列负责，这里只在入队失败时下次重试
+		dedupeKey := fmt.Sprintf("%s:%d:%d", record.Id.Hex(), reminder.Offset, record.RemindAt.Unix())
+		if err := record.Notify(ctx, record.FormatReminderMessage(reminder), dedupeKey); err != nil {
+			log.Warn("Failed to send reminder", map[string]interface{}{
+				"recordId": record.Id.Hex(),
+				"offset":   reminder.Offset,
+				"error":    err.Error(),
+			})
+			break
+		}
+		if err := record.MarkReminderAsReminded(ctx, reminder.Offset); err != nil {
+			log.Warn("Failed to mark reminder as reminded", map[string]interface{}{
+				"recordId": record.Id.Hex(),
+				"offset":   reminder.Offset,
+				"error":    err.Error(),
+			})
 		}
 	}
-	model.CTodoRecord.MarkAsReminded(ctx, succeedIds)
-	//for _, id := range succeedTodoIds {
-	//	model.CTodo.GenNextRecord(ctx, id, false)
-	//}
+	scheduler.schedule(record)
 }
@@ -0,0 +1,202 @@
+package cron
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+	"time"
+	"todo-reminder/log"
+	"todo-reminder/model"
+	"todo-reminder/repository/bsoncodec"
+	"todo-reminder/util"
+)
+
+const (
+	// scheduler 只保存接下来这段时间内的提醒，之后的提醒由 Remind 轮询时加载
+	schedulerWindow = 10 * time.Minute
+)
+
+var (
+	scheduler = newReminderScheduler()
+)
+
+func init() {
+	// 记录变化后重新计算提醒时间，回调在请求中执行，因此异步读取记录
+	model.RegisterRecordChangeHook(func(ctx context.Context, id bsoncodec.ObjectId) {
+		util.Submit(func() {
+			scheduler.reload(context.Background(), id)
+		})
+	})
+}
+
+type scheduledReminder struct {
+	recordId bsoncodec.ObjectId
+	remindAt time.Time
+	index    int
+}
+
+// reminderHeap 按提醒时间排序的最小堆
+type reminderHeap []*scheduledReminder
+
+func (h reminderHeap) Len() int {
+	return len(h)
+}
+
+func (h reminderHeap) Less(i, j int) bool {
+	return h[i].remindAt.Before(h[j].remindAt)
+}
+
+func (h reminderHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *reminderHeap) Push(x interface{}) {
+	item := x.(*scheduledReminder)
+	item.index = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *reminderHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return item
+}
+
+// reminderScheduler 在提醒时间到达时立即发送，每条记录只保存最早的一个未发送的提醒
+type reminderScheduler struct {
+	lock   sync.Mutex
+	items  reminderHeap
+	index  map[bsoncodec.ObjectId]*scheduledReminder
+	wakeup chan struct{}
+}
+
+func newReminderScheduler() *reminderScheduler {
+	return &reminderScheduler{
+		index:  map[bsoncodec.ObjectId]*scheduledReminder{},
+		wakeup: make(chan struct{}, 1),
+	}
+}
+
+// load 加载接下来一段时间内需要提醒的记录
+func (s *reminderScheduler) load(ctx context.Context) {
+	records, err := model.CTodoRecord.ListNeedRemindOnesBefore(ctx, time.Now().Add(schedulerWindow))
+	if err != nil {
+		log.Warn("Failed to load upcoming reminders", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+	for _, record := range records {
+		s.schedule(record)
+	}
+}
+
+func (s *reminderScheduler) reload(ctx context.Context, id bsoncodec.ObjectId) {
+	record, err := model.CTodoRecord.GetById(ctx, id)
+	if err != nil {
+		s.remove(id)
+		return
+	}
+	s.schedule(record)
+}
+
+// schedule 添加或更新记录的下一次提醒，没有需要发送的提醒或不在时间窗口内时移除
+func (s *reminderScheduler) schedule(record model.TodoRecord) {
+	remindAt, ok := record.GetNextReminderAt()
+	if !ok || remindAt.After(time.Now().Add(schedulerWindow)) {
+		s.remove(record.Id)
+		return
+	}
+	s.lock.Lock()
+	if item, ok := s.index[record.Id]; ok {
+		item.remindAt = remindAt
+		heap.Fix(&s.items, item.index)
+	} else {
+		item := &scheduledReminder{
+			recordId: record.Id,
+			remindAt: remindAt,
+		}
+		heap.Push(&s.items, item)
+		s.index[record.Id] = item
+	}
+	s.lock.Unlock()
+	s.notify()
+}
+
+func (s *reminderScheduler) remove(id bsoncodec.ObjectId) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	item, ok := s.index[id]
+	if !ok {
+		return
+	}
+	heap.Remove(&s.items, item.index)
+	delete(s.index, id)
+}
+
+// notify 唤醒 run 重新计算等待时间
+func (s *reminderScheduler) notify() {
+	select {
+	case s.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+// popDueOnes 取出所有已到期的提醒
+func (s *reminderScheduler) popDueOnes(now time.Time) []bsoncodec.ObjectId {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var ids []bsoncodec.ObjectId
+	for len(s.items) > 0 && !s.items[0].remindAt.After(now) {
+		item := heap.Pop(&s.items).(*scheduledReminder)
+		delete(s.index, item.recordId)
+		ids = append(ids, item.recordId)
+	}
+	return ids
+}
+
+// getWaitDuration 获取距离下一个提醒的时间
+func (s *reminderScheduler) getWaitDuration(now time.Time) time.Duration {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if len(s.items) == 0 {
+		return schedulerWindow
+	}
+	return s.items[0].remindAt.Sub(now)
+}
+
+func (s *reminderScheduler) run() {
+	defer func() {
+		if err := recover(); err != nil {
+			log.Error("Reminder scheduler panicked", map[string]interface{}{
+				"error": err,
+			})
+			go s.run()
+		}
+	}()
+	ctx := context.Background()
+	s.load(ctx)
+	timer := time.NewTimer(0)
+	for {
+		select {
+		case <-timer.C:
+		case <-s.wakeup:
+			if !timer.Stop() {
+				<-timer.C
+			}
+		}
+		ids := s.popDueOnes(time.Now())
+		// 多个实例同时运行时只由持有租约的实例发送
+		if len(ids) > 0 && holdLease(ctx, REMIND_LEASE_NAME, remindLeaseTTL) {
+			for _, id := range ids {
+				remindRecord(ctx, id)
+			}
+		}
+		timer.Reset(s.getWaitDuration(time.Now()))
+	}
+}
@@ -2,13 +2,14 @@ package cron
 
 import (
 	"context"
+	"time"
 	"todo-reminder/gocq"
 	"todo-reminder/log"
 	"todo-reminder/model"
 )
 
 func init() {
-	registerCronTask("@every 1m", SyncUser, true)
+	registerLeasedCronTask("@every 1m", "syncUser", 3*time.Minute, SyncUser, true)
 }
 
 func SyncUser() {
@@ -18,18 +19,17 @@ func SyncUser() {
 		return
 	}
 	for _, id := range userIds {
-		err := UpsertUser(ctx, id)
+		_, err := model.CUser.Onboard(ctx, id)
 		if err != nil {
 			log.Warn("Failed to sync user", map[string]interface{}{
 				"userId": id,
 			})
 		}
 	}
-}
-
-func UpsertUser(ctx context.Context, userId string) error {
-	user := model.User{
-		UserId: userId,
+	// 删除机器人好友的用户不再收到提醒，重新添加好友后自动恢复
+	if err := model.CUser.DisableUnfriendedOnes(ctx, userIds); err != nil {
+		log.Warn("Failed to disable unfriended users", map[string]interface{}{
+			"error": err.Error(),
+		})
 	}
-	return user.UpsertWithoutPassword(ctx)
 }
@@ -5,6 +5,7 @@ go 1.19
 require (
 	github.com/Lofanmi/chinese-calendar-golang v0.0.0-20211214151323-ef5cb443e55e
 	github.com/gin-gonic/gin v1.8.2
+	github.com/golang-jwt/jwt/v4 v4.5.2
 	github.com/gorilla/websocket v1.5.0
 	github.com/minio/minio-go/v7 v7.0.47
 	github.com/panjf2000/ants/v2 v2.7.2
@@ -17,6 +18,7 @@ require (
 	github.com/spf13/cast v1.5.0
 	github.com/spf13/viper v1.14.0
 	github.com/stretchr/testify v1.8.1
+	github.com/teambition/rrule-go v1.8.2
 	go.mongodb.org/mongo-driver v1.9.0
 	golang.org/x/crypto v0.5.0
 	gopkg.in/gomail.v2 v2.0.0-20160411212932-81ebce5c23df
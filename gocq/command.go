@@ -0,0 +1,62 @@
+package gocq
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+const (
+	COMMAND_PREFIX = "/"
+	COMMAND_HELP   = "help"
+)
+
+// CommandHandler 处理以 / 开头的命令，args 为命令名之后以空白分隔的参数
+type CommandHandler func(ctx context.Context, event *EventBody, args []string) string
+
+type command struct {
+	name    string
+	usage   string
+	handler CommandHandler
+}
+
+var (
+	commands []command
+)
+
+// RegisterCommand 注册命令，usage 用于 /help 中展示，如 “/done <序号> 完成待办”
+func RegisterCommand(name, usage string, handler CommandHandler) {
+	commands = append(commands, command{
+		name:    strings.ToLower(name),
+		usage:   usage,
+		handler: handler,
+	})
+}
+
+func handleCommand(ctx context.Context, event *EventBody, content string) (string, bool) {
+	if !strings.HasPrefix(content, COMMAND_PREFIX) {
+		return "", false
+	}
+	fields := strings.Fields(strings.TrimPrefix(content, COMMAND_PREFIX))
+	if len(fields) == 0 {
+		return GetCommandHelp(), true
+	}
+	name := strings.ToLower(fields[0])
+	for _, c := range commands {
+		if c.name == name {
+			return c.handler(ctx, event, fields[1:]), true
+		}
+	}
+	if name == COMMAND_HELP {
+		return GetCommandHelp(), true
+	}
+	return fmt.Sprintf("不支持的命令：%s\n%s", fields[0], GetCommandHelp()), true
+}
+
+func GetCommandHelp() string {
+	lines := []string{"支持的命令："}
+	for _, c := range commands {
+		lines = append(lines, c.usage)
+	}
+	return strings.Join(lines, "\n")
+}
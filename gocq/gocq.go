@@ -2,6 +2,7 @@ package gocq
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/spf13/cast"
 	"github.com/spf13/viper"
@@ -11,12 +12,23 @@ import (
 
 type goCq interface {
 	ListFriends(ctx context.Context) ([]string, error)
-	SendPrivateStringMessage(ctx context.Context, message, userId string) error
-	SendGroupImageMessage(ctx context.Context, groupId string, fileName, filePath string) error
-	SendAtInGroup(ctx context.Context, groupId, userId string, message string) error
+	// SendPrivateStringMessage 发送私聊消息，返回消息 id，可用于匹配对该消息的回复
+	SendPrivateStringMessage(ctx context.Context, message, userId string) (int64, error)
+	SendGroupImageMessage(ctx context.Context, groupId string, fileName, fileUrl string) error
+	// SendAtInGroup 在群里发送消息并 @ userIds 中的成员，userIds 为空时不 @ 任何人，返回消息 id
+	SendAtInGroup(ctx context.Context, groupId string, userIds []string, message string) (int64, error)
 	SendPrivateImageMessage(ctx context.Context, userId string, fileName, fileUrl string) error
+	// SetFriendAddRequest 处理好友申请，flag 为申请事件中的 flag
+	SetFriendAddRequest(ctx context.Context, flag string, approve bool) error
+	// ListGroupMembers 获取群成员的 QQ 号，机器人不在群里时返回错误
+	ListGroupMembers(ctx context.Context, groupId string) ([]string, error)
 }
 
+var (
+	gocqNotAvailableErr = errors.New("gocq not available")
+)
+
+// gocqEmpty 未配置 gocq 时使用，发送消息时返回错误，避免未送达的提醒被当作已提醒
 type gocqEmpty struct {
 }
 
@@ -25,30 +37,30 @@ func (g gocqEmpty) ListFriends(ctx context.Context) ([]string, error) {
 	return nil, nil
 }
 
-func (g gocqEmpty) SendPrivateStringMessage(ctx context.Context, message, userId string) error {
+func (g gocqEmpty) SendPrivateStringMessage(ctx context.Context, message, userId string) (int64, error) {
 	log.Warn("Calling SendPrivateStringMessage", map[string]interface{}{
 		"message": message,
 		"userId":  userId,
 	})
-	return nil
+	return 0, gocqNotAvailableErr
 }
 
-func (g gocqEmpty) SendGroupImageMessage(ctx context.Context, groupId string, fileName, filePath string) error {
+func (g gocqEmpty) SendGroupImageMessage(ctx context.Context, groupId string, fileName, fileUrl string) error {
 	log.Warn("Calling SendGroupImageMessage", map[string]interface{}{
 		"groupId":  groupId,
 		"fileName": fileName,
-		"filePath": filePath,
+		"fileUrl":  fileUrl,
 	})
-	return nil
+	return gocqNotAvailableErr
 }
 
-func (g gocqEmpty) SendAtInGroup(ctx context.Context, groupId, userId string, message string) error {
-	log.Warn("Calling SendGroupImageMessage", map[string]interface{}{
+func (g gocqEmpty) SendAtInGroup(ctx context.Context, groupId string, userIds []string, message string) (int64, error) {
+	log.Warn("Calling SendAtInGroup", map[string]interface{}{
 		"groupId": groupId,
-		"userId":  userId,
+		"userIds": userIds,
 		"message": message,
 	})
-	return nil
+	return 0, gocqNotAvailableErr
 }
 
 func (g gocqEmpty) SendPrivateImageMessage(ctx context.Context, userId string, fileName, fileUrl string) error {
@@ -57,23 +69,42 @@ func (g gocqEmpty) SendPrivateImageMessage(ctx context.Context, userId string, f
 		"fileName": fileName,
 		"fileUrl":  fileUrl,
 	})
-	return nil
+	return gocqNotAvailableErr
+}
+
+func (g gocqEmpty) SetFriendAddRequest(ctx context.Context, flag string, approve bool) error {
+	log.Warn("Calling SetFriendAddRequest", map[string]interface{}{
+		"flag":    flag,
+		"approve": approve,
+	})
+	return gocqNotAvailableErr
+}
+
+func (g gocqEmpty) ListGroupMembers(ctx context.Context, groupId string) ([]string, error) {
+	log.Warn("Calling ListGroupMembers", map[string]interface{}{
+		"groupId": groupId,
+	})
+	return nil, gocqNotAvailableErr
 }
 
 type goCqHttp struct {
 }
 
 const (
-	GET_FRIEND_LIST_ENDPOINT      = "get_friend_list"
-	SEND_PRIVATE_MESSAGE_ENDPOINT = "send_private_msg"
-	SEND_GROUP_MESSAGE_ENDPOINT   = "send_group_msg"
-	GET_LOGIN_INFO                = "get_login_info"
+	GET_FRIEND_LIST_ENDPOINT        = "get_friend_list"
+	SEND_PRIVATE_MESSAGE_ENDPOINT   = "send_private_msg"
+	SEND_GROUP_MESSAGE_ENDPOINT     = "send_group_msg"
+	GET_LOGIN_INFO                  = "get_login_info"
+	SET_FRIEND_ADD_REQUEST_ENDPOINT = "set_friend_add_request"
+	GET_GROUP_MEMBER_LIST_ENDPOINT  = "get_group_member_list"
 )
 
 type BaseResponse[T any] struct {
-	Code   int    `json:"retcode"`
-	Status string `json:"status"`
-	Data   T      `json:"data"`
+	Code    int    `json:"retcode"`
+	Status  string `json:"status"`
+	Message string `json:"msg"`
+	Wording string `json:"wording"`
+	Data    T      `json:"data"`
 }
 
 type FriendItem struct {
@@ -82,6 +113,13 @@ type FriendItem struct {
 	UserId   int64  `json:"user_id"`
 }
 
+type GroupMemberItem struct {
+	GroupId  int64  `json:"group_id"`
+	UserId   int64  `json:"user_id"`
+	Nickname string `json:"nickname"`
+	Card     string `json:"card"`
+}
+
 type SendMessageResponse struct {
 	MessageId int64 `json:"message_id"`
 }
@@ -96,6 +134,9 @@ func (g goCqHttp) ListFriends(ctx context.Context) ([]string, error) {
 	if err != nil {
 		return nil, err
 	}
+	if err := resp.Err(GET_FRIEND_LIST_ENDPOINT); err != nil {
+		return nil, err
+	}
 	userIds := make([]string, 0, len(resp.Data))
 	for _, item := range resp.Data {
 		userIds = append(userIds, cast.ToString(item.UserId))
@@ -103,43 +144,84 @@ func (g goCqHttp) ListFriends(ctx context.Context) ([]string, error) {
 	return userIds, nil
 }
 
-func (g goCqHttp) SendPrivateStringMessage(ctx context.Context, message, userId string) error {
-	client := util.GetRestClient[SendMessageResponse]()
-	_, err := client.PostJSON(ctx, g.genUrl(SEND_PRIVATE_MESSAGE_ENDPOINT), nil, map[string]interface{}{
+func (g goCqHttp) SetFriendAddRequest(ctx context.Context, flag string, approve bool) error {
+	client := util.GetRestClient[BaseResponse[interface{}]]()
+	resp, err := client.PostJSON(ctx, g.genUrl(SET_FRIEND_ADD_REQUEST_ENDPOINT), nil, map[string]interface{}{
+		"flag":    flag,
+		"approve": approve,
+	})
+	if err != nil {
+		return err
+	}
+	return resp.Err(SET_FRIEND_ADD_REQUEST_ENDPOINT)
+}
+
+func (g goCqHttp) ListGroupMembers(ctx context.Context, groupId string) ([]string, error) {
+	client := util.GetRestClient[BaseResponse[[]GroupMemberItem]]()
+	resp, err := client.PostJSON(ctx, g.genUrl(GET_GROUP_MEMBER_LIST_ENDPOINT), nil, map[string]interface{}{
+		"group_id": cast.ToInt64(groupId),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := resp.Err(GET_GROUP_MEMBER_LIST_ENDPOINT); err != nil {
+		return nil, err
+	}
+	return transGroupMembers(resp.Data), nil
+}
+
+func transGroupMembers(members []GroupMemberItem) []string {
+	userIds := make([]string, 0, len(members))
+	for _, member := range members {
+		userIds = append(userIds, cast.ToString(member.UserId))
+	}
+	return userIds
+}
+
+// sendMessage 发送消息并返回消息 id
+func (g goCqHttp) sendMessage(ctx context.Context, action string, params map[string]interface{}) (int64, error) {
+	client := util.GetRestClient[BaseResponse[SendMessageResponse]]()
+	resp, err := client.PostJSON(ctx, g.genUrl(action), nil, params)
+	if err != nil {
+		return 0, err
+	}
+	if err := resp.Err(action); err != nil {
+		return 0, err
+	}
+	return resp.Data.MessageId, nil
+}
+
+func (g goCqHttp) SendPrivateStringMessage(ctx context.Context, message, userId string) (int64, error) {
+	return g.sendMessage(ctx, SEND_PRIVATE_MESSAGE_ENDPOINT, map[string]interface{}{
 		"user_id":     cast.ToInt64(userId),
 		"message":     message,
 		"auto_escape": true,
 	})
-	return err
 }
 
-func (g goCqHttp) SendGroupImageMessage(ctx context.Context, groupId string, fileName, filePath string) error {
-	client := util.GetRestClient[SendMessageResponse]()
-	_, err := client.PostJSON(ctx, g.genUrl(SEND_GROUP_MESSAGE_ENDPOINT), nil, map[string]interface{}{
+func (g goCqHttp) SendGroupImageMessage(ctx context.Context, groupId string, fileName, fileUrl string) error {
+	_, err := g.sendMessage(ctx, SEND_GROUP_MESSAGE_ENDPOINT, map[string]interface{}{
 		"group_id": cast.ToInt64(groupId),
 		"message": map[string]interface{}{
 			"type": "image",
 			"data": map[string]interface{}{
 				"file": fileName,
-				"url":  util.GenFileURI(filePath),
+				"url":  fileUrl,
 			},
 		},
 	})
 	return err
 }
 
-func (g goCqHttp) SendAtInGroup(ctx context.Context, groupId, userId string, message string) error {
-	client := util.GetRestClient[SendMessageResponse]()
-	_, err := client.PostJSON(ctx, g.genUrl(SEND_GROUP_MESSAGE_ENDPOINT), nil, map[string]interface{}{
+func (g goCqHttp) SendAtInGroup(ctx context.Context, groupId string, userIds []string, message string) (int64, error) {
+	return g.sendMessage(ctx, SEND_GROUP_MESSAGE_ENDPOINT, map[string]interface{}{
 		"group_id": cast.ToInt64(groupId),
-		"message":  fmt.Sprintf("[CQ:at,qq=%s] %s", userId, message),
+		"message":  genAtMessage(userIds, message),
 	})
-	return err
 }
 
 func (g goCqHttp) SendPrivateImageMessage(ctx context.Context, userId string, fileName, fileUrl string) error {
-	client := util.GetRestClient[SendMessageResponse]()
-	_, err := client.PostJSON(ctx, g.genUrl(SEND_PRIVATE_MESSAGE_ENDPOINT), nil, map[string]interface{}{
+	_, err := g.sendMessage(ctx, SEND_PRIVATE_MESSAGE_ENDPOINT, map[string]interface{}{
 		"user_id": cast.ToInt64(userId),
 		"message": map[string]interface{}{
 			"type": "image",
@@ -7,12 +7,12 @@ import (
 	"github.com/gorilla/websocket"
 	"github.com/spf13/cast"
 	"github.com/spf13/viper"
+	"net"
 	"strings"
 	"sync"
 	"time"
 	"todo-reminder/log"
 	"todo-reminder/openai"
-	"todo-reminder/repository/bsoncodec"
 	"todo-reminder/util"
 )
 
@@ -46,6 +46,8 @@ const (
 
 	NOTICE_TYPE_FRIEND_ADD = "friend_add"
 
+	REQUEST_TYPE_FRIEND = "friend"
+
 	MESSAGE_TYPE_PRIVATE = "private"
 	MESSAGE_TYPE_GROUP   = "group"
 
@@ -56,13 +58,16 @@ const (
 type goCqWebsocket struct {
 	action              *websocket.Conn
 	event               *websocket.Conn
-	friendList          chan []FriendItem
 	self                LoginInfo
 	heartBeat           chan HeartBeatStatus
 	lastAlertTime       time.Time
 	conversations       map[int64][]Conversation
 	lastReceivedTimeMap map[int64]time.Time
 	lock                *sync.Mutex
+	writeLock           *sync.Mutex
+	pendingLock         *sync.Mutex
+	// 等待结果的调用，key 为 echo
+	pendingCalls map[string]chan WebsocketActionResponse
 }
 
 type Conversation struct {
@@ -75,18 +80,20 @@ func init() {
 		return
 	}
 	g := &goCqWebsocket{
-		friendList:          make(chan []FriendItem),
 		heartBeat:           make(chan HeartBeatStatus),
 		conversations:       make(map[int64][]Conversation),
 		lastReceivedTimeMap: make(map[int64]time.Time),
 		lock:                &sync.Mutex{},
+		writeLock:           &sync.Mutex{},
+		pendingLock:         &sync.Mutex{},
+		pendingCalls:        make(map[string]chan WebsocketActionResponse),
 	}
 	err := g.dial(context.Background())
 	if err != nil {
 		panic(err)
 	}
-	go g.listenEventResponse(context.Background())
-	go g.listenActionResponse(context.Background())
+	go g.listenEventResponse(context.Background(), g.event)
+	go g.listenActionResponse(context.Background(), g.action)
 	go g.HeartBeat(context.Background())
 	go g.InitSelfInfo(context.Background())
 	goCqWs = g
@@ -100,19 +107,24 @@ func (g *goCqWebsocket) dial(ctx context.Context) error {
 	}
 	event, _, err := websocket.DefaultDialer.DialContext(context.Background(), fmt.Sprintf("%s/event", url), nil)
 	if err != nil {
+		action.Close()
 		return err
 	}
+	// writeJSON 在 writeLock 内使用 g.action，替换连接时也需要持有
+	g.writeLock.Lock()
 	g.action = action
 	g.event = event
+	g.writeLock.Unlock()
 	return nil
 }
 
-func (g *goCqWebsocket) close() error {
-	err := g.action.Close()
-	if err != nil {
-		return err
-	}
-	return g.event.Close()
+// close 关闭连接，并结束所有等待中的调用，避免调用方等到超时
+func (g *goCqWebsocket) close() {
+	g.writeLock.Lock()
+	g.action.Close()
+	g.event.Close()
+	g.writeLock.Unlock()
+	g.failPendingCalls("gocq websocket connection closed")
 }
 
 func (g *goCqWebsocket) retry() {
@@ -129,8 +141,12 @@ func (g *goCqWebsocket) retry() {
 		break
 	}
 	log.Warn("Gocq websocket connected", nil)
-	go g.listenActionResponse(ctx)
-	go g.listenEventResponse(ctx)
+	g.writeLock.Lock()
+	action, event := g.action, g.event
+	g.writeLock.Unlock()
+	go g.listenActionResponse(ctx, action)
+	go g.listenEventResponse(ctx, event)
+	go g.InitSelfInfo(ctx)
 }
 
 type WebsocketRequest struct {
@@ -141,7 +157,7 @@ type WebsocketRequest struct {
 
 type WebsocketActionResponse struct {
 	Status  string      `json:"status"`
-	RetCode int64       `json:"retCode"`
+	RetCode int64       `json:"retcode"`
 	Message string      `json:"msg"`
 	Wording string      `json:"wording"`
 	Data    interface{} `json:"data"`
@@ -162,6 +178,11 @@ type EventBody struct {
 	RawMessage      string          `json:"raw_message,omitempty"`
 	Sender          Sender          `json:"sender,omitempty"`
 	GroupId         int64           `json:"group_id,omitempty"`
+	RequestType     string          `json:"request_type,omitempty"`
+	// 好友申请的验证信息
+	Comment string `json:"comment,omitempty"`
+	// 处理申请时需要传回的标识
+	Flag string `json:"flag,omitempty"`
 }
 
 type HeartBeatStatus struct {
@@ -215,104 +236,117 @@ func (g *goCqWebsocket) HeartBeat(ctx context.Context) {
 }
 
 func (g *goCqWebsocket) ListFriends(ctx context.Context) ([]string, error) {
-	req := WebsocketRequest{
-		Action: GET_FRIEND_LIST_ENDPOINT,
-		Echo:   GET_FRIEND_LIST_ENDPOINT,
-	}
-	err := g.action.WriteJSON(req)
+	var list []FriendItem
+	err := g.call(ctx, GET_FRIEND_LIST_ENDPOINT, nil, &list)
 	if err != nil {
 		return nil, err
 	}
-	timer := time.NewTimer(time.Second * 3)
-	for {
-		select {
-		case list := <-g.friendList:
-			var result []string
-			for _, item := range list {
-				result = append(result, cast.ToString(item.UserId))
-			}
-			return result, nil
-		case <-timer.C:
-			return nil, errors.New("context deadline exceed")
-		}
+	result := make([]string, 0, len(list))
+	for _, item := range list {
+		result = append(result, cast.ToString(item.UserId))
 	}
+	return result, nil
 }
 
-func (g *goCqWebsocket) SendPrivateStringMessage(ctx context.Context, message, userId string) error {
-	req := WebsocketRequest{
-		Action: SEND_PRIVATE_MESSAGE_ENDPOINT,
-		Echo:   SEND_PRIVATE_MESSAGE_ENDPOINT + bsoncodec.NewObjectId().Hex(),
-		Params: map[string]interface{}{
-			"user_id":     cast.ToInt64(userId),
-			"message":     message,
-			"auto_escape": true,
-		},
+func (g *goCqWebsocket) ListGroupMembers(ctx context.Context, groupId string) ([]string, error) {
+	var list []GroupMemberItem
+	err := g.call(ctx, GET_GROUP_MEMBER_LIST_ENDPOINT, map[string]interface{}{
+		"group_id": cast.ToInt64(groupId),
+	}, &list)
+	if err != nil {
+		return nil, err
+	}
+	return transGroupMembers(list), nil
+}
+
+func (g *goCqWebsocket) SetFriendAddRequest(ctx context.Context, flag string, approve bool) error {
+	return g.call(ctx, SET_FRIEND_ADD_REQUEST_ENDPOINT, map[string]interface{}{
+		"flag":    flag,
+		"approve": approve,
+	}, nil)
+}
+
+func (g *goCqWebsocket) SendPrivateStringMessage(ctx context.Context, message, userId string) (int64, error) {
+	return g.sendMessage(ctx, SEND_PRIVATE_MESSAGE_ENDPOINT, map[string]interface{}{
+		"user_id":     cast.ToInt64(userId),
+		"message":     message,
+		"auto_escape": true,
+	})
+}
+
+// sendMessage 发送消息并返回消息 id
+func (g *goCqWebsocket) sendMessage(ctx context.Context, action string, params map[string]interface{}) (int64, error) {
+	resp := SendMessageResponse{}
+	err := g.call(ctx, action, params, &resp)
+	if err != nil {
+		return 0, err
 	}
-	return g.action.WriteJSON(req)
+	return resp.MessageId, nil
 }
 
 func (g *goCqWebsocket) SendPrivateImageMessage(ctx context.Context, userId string, fileName, fileUrl string) error {
-	req := WebsocketRequest{
-		Action: SEND_PRIVATE_MESSAGE_ENDPOINT,
-		Params: map[string]interface{}{
-			"user_id": cast.ToInt64(userId),
-			"message": map[string]interface{}{
-				"type": "image",
-				"data": map[string]interface{}{
-					"file": fileName,
-					"url":  fileUrl,
-				},
+	_, err := g.sendMessage(ctx, SEND_PRIVATE_MESSAGE_ENDPOINT, map[string]interface{}{
+		"user_id": cast.ToInt64(userId),
+		"message": map[string]interface{}{
+			"type": "image",
+			"data": map[string]interface{}{
+				"file": fileName,
+				"url":  fileUrl,
 			},
 		},
-	}
-	return g.action.WriteJSON(req)
-}
-
-func (g *goCqWebsocket) SendGroupImageMessage(ctx context.Context, groupId string, fileName, filePath string) error {
-	req := WebsocketRequest{
-		Action: SEND_GROUP_MESSAGE_ENDPOINT,
-		Echo:   SEND_GROUP_MESSAGE_ENDPOINT + bsoncodec.NewObjectId().Hex(),
-		Params: map[string]interface{}{
-			"group_id": cast.ToInt64(groupId),
-			"message": map[string]interface{}{
-				"type": "image",
-				"data": map[string]interface{}{
-					"file": fileName,
-					"url":  util.GenFileURI(filePath),
-				},
+	})
+	return err
+}
+
+func (g *goCqWebsocket) SendGroupImageMessage(ctx context.Context, groupId string, fileName, fileUrl string) error {
+	_, err := g.sendMessage(ctx, SEND_GROUP_MESSAGE_ENDPOINT, map[string]interface{}{
+		"group_id": cast.ToInt64(groupId),
+		"message": map[string]interface{}{
+			"type": "image",
+			"data": map[string]interface{}{
+				"file": fileName,
+				"url":  fileUrl,
 			},
-			"auto_escape": true,
 		},
-	}
-	return g.action.WriteJSON(req)
+		"auto_escape": true,
+	})
+	return err
 }
 
-func (g *goCqWebsocket) SendAtInGroup(ctx context.Context, groupId, userId string, message string) error {
-	req := WebsocketRequest{
-		Action: SEND_GROUP_MESSAGE_ENDPOINT,
-		Echo:   SEND_GROUP_MESSAGE_ENDPOINT + bsoncodec.NewObjectId().Hex(),
-		Params: map[string]interface{}{
-			"group_id": cast.ToInt64(groupId),
-			"message":  fmt.Sprintf("[CQ:at,qq=%s] %s", userId, message),
-		},
-	}
-	return g.action.WriteJSON(req)
+func (g *goCqWebsocket) SendAtInGroup(ctx context.Context, groupId string, userIds []string, message string) (int64, error) {
+	return g.sendMessage(ctx, SEND_GROUP_MESSAGE_ENDPOINT, map[string]interface{}{
+		"group_id": cast.ToInt64(groupId),
+		"message":  genAtMessage(userIds, message),
+	})
 }
 
 func (g *goCqWebsocket) InitSelfInfo(ctx context.Context) error {
-	req := WebsocketRequest{
-		Action: GET_LOGIN_INFO,
-		Echo:   GET_LOGIN_INFO,
+	loginInfo := LoginInfo{}
+	err := g.call(ctx, GET_LOGIN_INFO, nil, &loginInfo)
+	if err != nil {
+		log.Warn("Failed to get login info", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return err
 	}
-	return g.action.WriteJSON(req)
+	g.self = loginInfo
+	return nil
 }
 
-func (g *goCqWebsocket) listenActionResponse(ctx context.Context) {
+// listenActionResponse 读取指定的连接，重连后由新的 goroutine 读取新的连接
+func (g *goCqWebsocket) listenActionResponse(ctx context.Context, conn *websocket.Conn) {
 	for {
 		resp := &WebsocketActionResponse{}
-		err := g.action.ReadJSON(resp)
+		err := conn.ReadJSON(resp)
 		if err != nil {
-			if websocket.IsCloseError(err) || websocket.IsUnexpectedCloseError(err) {
+			if isConnClosed(err) {
+				// 重连时 close 已经结束了等待中的调用，旧连接不能影响新连接上的调用
+				g.writeLock.Lock()
+				isCurrent := g.action == conn
+				g.writeLock.Unlock()
+				if isCurrent {
+					g.failPendingCalls("gocq websocket connection closed")
+				}
 				return
 			}
 			log.Warn("Failed to read action response", map[string]interface{}{
@@ -320,31 +354,16 @@ func (g *goCqWebsocket) listenActionResponse(ctx context.Context) {
 			})
 			continue
 		}
-		switch resp.Echo {
-		case GET_FRIEND_LIST_ENDPOINT:
-			var list []FriendItem
-			err := util.CopyByJson(resp.Data, &list)
-			if err != nil {
-				continue
-			}
-			g.friendList <- list
-		case GET_LOGIN_INFO:
-			loginInfo := LoginInfo{}
-			err := util.CopyByJson(resp.Data, &loginInfo)
-			if err != nil {
-				continue
-			}
-			g.self = loginInfo
-		}
+		g.resolveCall(*resp)
 	}
 }
 
-func (g *goCqWebsocket) listenEventResponse(ctx context.Context) {
+func (g *goCqWebsocket) listenEventResponse(ctx context.Context, conn *websocket.Conn) {
 	for {
 		event := &EventBody{}
-		err := g.event.ReadJSON(event)
+		err := conn.ReadJSON(event)
 		if err != nil {
-			if websocket.IsCloseError(err) || websocket.IsUnexpectedCloseError(err) {
+			if isConnClosed(err) {
 				go g.retry()
 				log.Warn("Gocq websocket connection closed", nil)
 				return
@@ -385,31 +404,46 @@ func (e *EventBody) handleEvent(ctx context.Context, ws *goCqWebsocket) error {
 func (e *EventBody) handleMessageEvent(ctx context.Context, ws *goCqWebsocket) error {
 	switch e.MessageType {
 	case MESSAGE_TYPE_PRIVATE:
-		return nil
-	case MESSAGE_TYPE_GROUP:
-		if !util.IsCQCode(e.RawMessage) {
+		if e.SubType != MESSAGE_SUB_TYPE_FRIEND {
 			return nil
 		}
-		params, prefix, suffix := util.GetCQCodeParams(e.RawMessage)
-		if params["type"] != "at" {
+		reply, handled := handleMessage(ctx, e, GetPlainText(e.RawMessage))
+		if !handled || reply == "" {
 			return nil
 		}
-		if cast.ToInt64(params["qq"]) != ws.self.UserId {
+		return Enqueue(ctx, NewPrivateMessage(cast.ToString(e.UserId), reply))
+	case MESSAGE_TYPE_GROUP:
+		if !util.IsCQCode(e.RawMessage) {
 			return nil
 		}
-		content := prefix
-		if content == "" {
-			content = suffix
+		// 回复消息时 @ 之前还有 reply 码，因此检查所有的 CQ 码
+		params, content := util.GetAllCQParams(e.RawMessage)
+		if !isMentioned(params, ws.self.UserId) {
+			return nil
 		}
+		content = strings.TrimSpace(content)
 		if content == "" {
 			return nil
 		}
+		if reply, handled := handleMessage(ctx, e, GetPlainText(content)); handled {
+			if reply == "" {
+				return nil
+			}
+			return e.replyInGroup(ctx, reply)
+		}
 		if strings.Contains(content, "图片") {
 			absPath, fileName, err := openai.GetOpenAIClient().GenImage(ctx, strings.Join(strings.Split(content, "图片"), ""))
 			if err != nil {
-				return ws.SendAtInGroup(ctx, cast.ToString(e.GroupId), cast.ToString(e.UserId), err.Error())
+				return e.replyInGroup(ctx, err.Error())
+			}
+			message := NewGroupMessage(cast.ToString(e.GroupId), nil, "")
+			message.Images = []OutboundImage{
+				{
+					Name: fileName,
+					Url:  util.GenFileURI(absPath),
+				},
 			}
-			return ws.SendGroupImageMessage(ctx, cast.ToString(e.GroupId), fileName, absPath)
+			return Enqueue(ctx, message)
 		} else {
 			ws.lock.Lock()
 			lastReceivedTime := ws.lastReceivedTimeMap[e.UserId]
@@ -435,23 +469,42 @@ func (e *EventBody) handleMessageEvent(ctx context.Context, ws *goCqWebsocket) e
 				ws.conversations[e.UserId] = conversations
 				ws.lock.Unlock()
 			}
-			return ws.SendAtInGroup(ctx, cast.ToString(e.GroupId), cast.ToString(e.UserId), message)
+			return e.replyInGroup(ctx, message)
 		}
 	default:
 		return errors.New("unsupported message type")
 	}
 }
 
+// replyInGroup 在群里回复消息并 @ 发送者
+func (e *EventBody) replyInGroup(ctx context.Context, message string) error {
+	return Enqueue(ctx, NewGroupMessage(cast.ToString(e.GroupId), []string{cast.ToString(e.UserId)}, message))
+}
+
 func (e *EventBody) handleMessageSentEvent(ctx context.Context, ws *goCqWebsocket) error {
 	return nil
 }
 
+// handleRequestEvent 只处理好友申请，不同意的申请保持待处理状态
 func (e *EventBody) handleRequestEvent(ctx context.Context, ws *goCqWebsocket) error {
+	if e.RequestType != REQUEST_TYPE_FRIEND {
+		return nil
+	}
+	if !shouldApproveFriendRequest(ctx, e) {
+		return nil
+	}
+	// 同意失败时在这里记录日志，申请保持待处理状态
+	if err := ws.SetFriendAddRequest(ctx, e.Flag, true); err != nil {
+		log.Warn("Failed to approve friend request", map[string]interface{}{
+			"userId": e.UserId,
+			"error":  err.Error(),
+		})
+	}
 	return nil
 }
 
 func (e *EventBody) handleNoticeEvent(ctx context.Context, ws *goCqWebsocket) error {
-	return nil
+	return handleNotice(ctx, e)
 }
 
 func (e *EventBody) handleMetaInfoEvent(ctx context.Context, ws *goCqWebsocket) error {
@@ -471,3 +524,8 @@ func transConversationsToSlice(conversations []Conversation) ([]string, []string
 	}
 	return s1, s2
 }
+
+// isConnClosed 对方关闭连接或者连接已经被本地关闭
+func isConnClosed(err error) bool {
+	return websocket.IsCloseError(err) || websocket.IsUnexpectedCloseError(err) || errors.Is(err, net.ErrClosed)
+}
@@ -0,0 +1,113 @@
+package gocq
+
+import (
+	"context"
+	"fmt"
+	"github.com/spf13/cast"
+	"html"
+	"strings"
+	"todo-reminder/util"
+)
+
+var (
+	cqTextEscaper = strings.NewReplacer("&", "&amp;", "[", "&#91;", "]", "&#93;")
+)
+
+// MessageHandler 处理收到的消息，content 为去掉 CQ 码后的纯文本，handled 为 false 时交给下一个 handler 处理
+type MessageHandler func(ctx context.Context, event *EventBody, content string) (reply string, handled bool)
+
+// FriendRequestHandler 判断是否同意好友申请，所有 handler 都不同意时不处理申请，由人工处理
+type FriendRequestHandler func(ctx context.Context, event *EventBody) (approve bool)
+
+// NoticeHandler 处理好友添加等通知事件
+type NoticeHandler func(ctx context.Context, event *EventBody) error
+
+var (
+	messageHandlers       []MessageHandler
+	friendRequestHandlers []FriendRequestHandler
+	noticeHandlers        []NoticeHandler
+)
+
+func RegisterMessageHandler(handler MessageHandler) {
+	messageHandlers = append(messageHandlers, handler)
+}
+
+func RegisterFriendRequestHandler(handler FriendRequestHandler) {
+	friendRequestHandlers = append(friendRequestHandlers, handler)
+}
+
+func RegisterNoticeHandler(handler NoticeHandler) {
+	noticeHandlers = append(noticeHandlers, handler)
+}
+
+func handleMessage(ctx context.Context, event *EventBody, content string) (string, bool) {
+	if reply, handled := handleCommand(ctx, event, content); handled {
+		return reply, true
+	}
+	for _, handler := range messageHandlers {
+		if reply, handled := handler(ctx, event, content); handled {
+			return reply, true
+		}
+	}
+	return "", false
+}
+
+func shouldApproveFriendRequest(ctx context.Context, event *EventBody) bool {
+	for _, handler := range friendRequestHandlers {
+		if handler(ctx, event) {
+			return true
+		}
+	}
+	return false
+}
+
+func handleNotice(ctx context.Context, event *EventBody) error {
+	for _, handler := range noticeHandlers {
+		if err := handler(ctx, event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetPlainText 获取消息中去掉 CQ 码后的纯文本
+func GetPlainText(rawMessage string) string {
+	text := rawMessage
+	if util.IsCQCode(rawMessage) {
+		_, text = util.GetAllCQParams(rawMessage)
+	}
+	return html.UnescapeString(text)
+}
+
+// GetReplyMessageId 获取消息中 [CQ:reply,id=xxx] 回复的消息 id
+func GetReplyMessageId(rawMessage string) (string, bool) {
+	if !util.IsCQCode(rawMessage) {
+		return "", false
+	}
+	params, _ := util.GetAllCQParams(rawMessage)
+	for _, param := range params {
+		if param["type"] == "reply" && param["id"] != "" {
+			return cast.ToString(param["id"]), true
+		}
+	}
+	return "", false
+}
+
+// genAtMessage 生成 @ 多个成员的消息，message 中的特殊字符会被转义
+func genAtMessage(userIds []string, message string) string {
+	var builder strings.Builder
+	for _, userId := range userIds {
+		builder.WriteString(fmt.Sprintf("[CQ:at,qq=%s] ", userId))
+	}
+	builder.WriteString(cqTextEscaper.Replace(message))
+	return builder.String()
+}
+
+func isMentioned(params []map[string]string, selfId int64) bool {
+	for _, param := range params {
+		if param["type"] == "at" && cast.ToInt64(param["qq"]) == selfId {
+			return true
+		}
+	}
+	return false
+}
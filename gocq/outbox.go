@@ -0,0 +1,389 @@
+package gocq
+
+import (
+	"context"
+	"errors"
+	"github.com/qiniu/qmgo"
+	"github.com/qiniu/qmgo/options"
+	"github.com/spf13/viper"
+	mgo_option "go.mongodb.org/mongo-driver/mongo/options"
+	"sync"
+	"time"
+	"todo-reminder/log"
+	"todo-reminder/repository"
+	"todo-reminder/repository/bsoncodec"
+	"todo-reminder/util"
+)
+
+const (
+	C_OUTBOUND_MESSAGE = "outboundMessage"
+
+	OUTBOUND_STATUS_PENDING = "pending"
+	OUTBOUND_STATUS_SENDING = "sending"
+	OUTBOUND_STATUS_SENT    = "sent"
+	// 超过最大重试次数后不再发送
+	OUTBOUND_STATUS_DEAD = "dead"
+
+	OUTBOUND_TARGET_PRIVATE = "private"
+	OUTBOUND_TARGET_GROUP   = "group"
+
+	defaultMaxAttempts           = 6
+	defaultPerRecipientPerMinute = 10
+	defaultSendIntervalMillis    = 500
+	defaultRetentionDays         = 30
+	// 第一次重试的间隔，之后每次翻倍
+	retryBaseInterval = 10 * time.Second
+	retryMaxInterval  = 30 * time.Minute
+	// 处于 sending 状态超过该时间的消息视为发送进程已退出，重新发送
+	sendingTimeout = 5 * time.Minute
+	// 每次最多处理的消息数量
+	dispatchBatchSize = 50
+)
+
+var (
+	outboundCallbacks = map[string]OutboundCallback{}
+	dispatchLock      = &sync.Mutex{}
+)
+
+func init() {
+	repository.Mongo.CreateIndex(context.Background(), C_OUTBOUND_MESSAGE, options.IndexModel{
+		Key: []string{"status", "nextAttemptAt"},
+		IndexOptions: &mgo_option.IndexOptions{
+			Background: util.PtrValue[bool](true),
+		},
+	})
+	repository.Mongo.CreateIndex(context.Background(), C_OUTBOUND_MESSAGE, options.IndexModel{
+		Key: []string{"targetType", "targetId", "sentAt"},
+		IndexOptions: &mgo_option.IndexOptions{
+			Background: util.PtrValue[bool](true),
+		},
+	})
+	repository.Mongo.CreateIndex(context.Background(), C_OUTBOUND_MESSAGE, options.IndexModel{
+		Key: []string{"dedupeKey"},
+		IndexOptions: &mgo_option.IndexOptions{
+			Background: util.PtrValue[bool](true),
+			Unique:     util.PtrValue[bool](true),
+			Sparse:     util.PtrValue[bool](true),
+		},
+	})
+	// 发送完成的消息保留一段时间后自动删除
+	repository.Mongo.CreateIndex(context.Background(), C_OUTBOUND_MESSAGE, options.IndexModel{
+		Key: []string{"expiredAt"},
+		IndexOptions: &mgo_option.IndexOptions{
+			Background:         util.PtrValue[bool](true),
+			ExpireAfterSeconds: util.PtrValue[int32](0),
+		},
+	})
+}
+
+// OutboundCallback 消息发送成功或进入死信状态后调用，delivered 表示是否发送成功
+type OutboundCallback func(ctx context.Context, message OutboundMessage, delivered bool)
+
+// OutboundMessage 待发送的 QQ 消息，所有发往 gocq 的消息都先写入队列再由 DispatchOutboundMessages 发送
+type OutboundMessage struct {
+	Id         bsoncodec.ObjectId `bson:"_id"`
+	CreatedAt  time.Time          `bson:"createdAt"`
+	UpdatedAt  time.Time          `bson:"updatedAt"`
+	TargetType string             `bson:"targetType"`
+	// 私聊为 QQ 号，群消息为群号
+	TargetId string `bson:"targetId"`
+	// 群消息中需要 @ 的成员
+	Mentions []string        `bson:"mentions,omitempty"`
+	Message  string          `bson:"message,omitempty"`
+	Images   []OutboundImage `bson:"images,omitempty"`
+	// 不为空时相同 dedupeKey 的消息只会发送一次
+	DedupeKey     string            `bson:"dedupeKey,omitempty"`
+	Status        string            `bson:"status"`
+	AttemptCount  int               `bson:"attemptCount"`
+	MaxAttempts   int               `bson:"maxAttempts"`
+	Attempts      []DeliveryAttempt `bson:"attempts,omitempty"`
+	NextAttemptAt time.Time         `bson:"nextAttemptAt"`
+	ClaimedAt     time.Time         `bson:"claimedAt,omitempty"`
+	SentAt        time.Time         `bson:"sentAt,omitempty"`
+	MessageId     int64             `bson:"messageId,omitempty"`
+	// 通过 RegisterOutboundCallback 注册的回调名称及参数
+	Callback     string            `bson:"callback,omitempty"`
+	CallbackData map[string]string `bson:"callbackData,omitempty"`
+	// 包含验证码等敏感信息，发送完成后清空消息内容
+	IsSensitive bool `bson:"isSensitive,omitempty"`
+	// 发送成功或进入 dead 状态后设置，到期后由 TTL 索引删除
+	ExpiredAt time.Time `bson:"expiredAt,omitempty"`
+}
+
+type OutboundImage struct {
+	Name string `bson:"name"`
+	Url  string `bson:"url"`
+}
+
+type DeliveryAttempt struct {
+	AttemptedAt time.Time `bson:"attemptedAt"`
+	Error       string    `bson:"error,omitempty"`
+	MessageId   int64     `bson:"messageId,omitempty"`
+}
+
+func RegisterOutboundCallback(name string, callback OutboundCallback) {
+	outboundCallbacks[name] = callback
+}
+
+func NewPrivateMessage(userId, message string) *OutboundMessage {
+	return &OutboundMessage{
+		TargetType: OUTBOUND_TARGET_PRIVATE,
+		TargetId:   userId,
+		Message:    message,
+	}
+}
+
+func NewGroupMessage(groupId string, mentions []string, message string) *OutboundMessage {
+	return &OutboundMessage{
+		TargetType: OUTBOUND_TARGET_GROUP,
+		TargetId:   groupId,
+		Mentions:   mentions,
+		Message:    message,
+	}
+}
+
+// Enqueue 将消息写入发送队列，dedupeKey 重复时忽略
+func Enqueue(ctx context.Context, m *OutboundMessage) error {
+	if m.Message == "" && len(m.Images) == 0 {
+		return errors.New("empty message")
+	}
+	m.Id = bsoncodec.NewObjectId()
+	m.CreatedAt = time.Now()
+	m.UpdatedAt = time.Now()
+	m.Status = OUTBOUND_STATUS_PENDING
+	m.NextAttemptAt = time.Now()
+	if m.MaxAttempts == 0 {
+		m.MaxAttempts = getMaxAttempts()
+	}
+	err := repository.Mongo.Insert(ctx, C_OUTBOUND_MESSAGE, m)
+	if qmgo.IsDup(err) {
+		return nil
+	}
+	return err
+}
+
+// GetRetryInterval 获取第 attemptCount 次发送失败后的重试间隔，指数增长直到上限
+func GetRetryInterval(attemptCount int) time.Duration {
+	interval := retryBaseInterval
+	for i := 1; i < attemptCount && interval < retryMaxInterval; i++ {
+		interval *= 2
+	}
+	if interval > retryMaxInterval {
+		return retryMaxInterval
+	}
+	return interval
+}
+
+// DispatchOutboundMessages 发送队列中到期的消息，同一时间只有一个在执行
+func DispatchOutboundMessages(ctx context.Context) {
+	if !dispatchLock.TryLock() {
+		return
+	}
+	defer dispatchLock.Unlock()
+	interval := time.Duration(getIntSetting("outbox.sendIntervalMillis", defaultSendIntervalMillis)) * time.Millisecond
+	for i := 0; i < dispatchBatchSize; i++ {
+		m, err := claimOutboundMessage(ctx)
+		if err != nil {
+			if err != qmgo.ErrNoSuchDocuments {
+				log.Warn("Failed to claim outbound message", map[string]interface{}{
+					"error": err.Error(),
+				})
+			}
+			return
+		}
+		if m.isRateLimited(ctx) {
+			m.postpone(ctx, time.Minute/time.Duration(getPerRecipientPerMinute()))
+			continue
+		}
+		m.deliver(ctx)
+		time.Sleep(interval)
+	}
+}
+
+// claimOutboundMessage 原子地将一条到期的消息改为 sending 状态，避免重复发送
+func claimOutboundMessage(ctx context.Context) (OutboundMessage, error) {
+	now := time.Now()
+	condition := bsoncodec.M{
+		"$or": []bsoncodec.M{
+			{
+				"status": OUTBOUND_STATUS_PENDING,
+				"nextAttemptAt": bsoncodec.M{
+					"$lte": now,
+				},
+			},
+			{
+				"status": OUTBOUND_STATUS_SENDING,
+				"claimedAt": bsoncodec.M{
+					"$lte": now.Add(-sendingTimeout),
+				},
+			},
+		},
+	}
+	change := qmgo.Change{
+		ReturnNew: true,
+		Update: bsoncodec.M{
+			"$set": bsoncodec.M{
+				"status":    OUTBOUND_STATUS_SENDING,
+				"claimedAt": now,
+				"updatedAt": now,
+			},
+		},
+	}
+	m := OutboundMessage{}
+	err := repository.Mongo.FindAndApplyWithSorter(ctx, C_OUTBOUND_MESSAGE, []string{"nextAttemptAt"}, condition, change, &m)
+	return m, err
+}
+
+// isRateLimited 一分钟内发给同一个接收者的消息是否超过限制
+func (m *OutboundMessage) isRateLimited(ctx context.Context) bool {
+	condition := bsoncodec.M{
+		"targetType": m.TargetType,
+		"targetId":   m.TargetId,
+		"sentAt": bsoncodec.M{
+			"$gte": time.Now().Add(-time.Minute),
+		},
+	}
+	count, err := repository.Mongo.Count(ctx, C_OUTBOUND_MESSAGE, condition)
+	if err != nil {
+		return false
+	}
+	return count >= int64(getPerRecipientPerMinute())
+}
+
+// postpone 被限流的消息延后发送，不计入重试次数
+func (m *OutboundMessage) postpone(ctx context.Context, d time.Duration) {
+	m.updateById(ctx, bsoncodec.M{
+		"$set": bsoncodec.M{
+			"status":        OUTBOUND_STATUS_PENDING,
+			"nextAttemptAt": time.Now().Add(d),
+			"updatedAt":     time.Now(),
+		},
+	})
+}
+
+func (m *OutboundMessage) deliver(ctx context.Context) {
+	messageId, err := m.send(ctx)
+	attempt := DeliveryAttempt{
+		AttemptedAt: time.Now(),
+		MessageId:   messageId,
+	}
+	m.AttemptCount++
+	setter := bsoncodec.M{
+		"attemptCount": m.AttemptCount,
+		"updatedAt":    time.Now(),
+	}
+	if err == nil {
+		m.Status = OUTBOUND_STATUS_SENT
+		m.MessageId = messageId
+		setter["sentAt"] = time.Now()
+		setter["messageId"] = messageId
+	} else {
+		attempt.Error = err.Error()
+		m.Status = OUTBOUND_STATUS_PENDING
+		if m.AttemptCount >= m.MaxAttempts {
+			m.Status = OUTBOUND_STATUS_DEAD
+		}
+		setter["nextAttemptAt"] = time.Now().Add(GetRetryInterval(m.AttemptCount))
+		log.Warn("Failed to deliver outbound message", map[string]interface{}{
+			"id":           m.Id.Hex(),
+			"targetType":   m.TargetType,
+			"targetId":     m.TargetId,
+			"attemptCount": m.AttemptCount,
+			"error":        err.Error(),
+		})
+	}
+	setter["status"] = m.Status
+	updater := bsoncodec.M{
+		"$set": setter,
+		"$push": bsoncodec.M{
+			"attempts": attempt,
+		},
+	}
+	if m.Status != OUTBOUND_STATUS_PENDING {
+		setter["expiredAt"] = time.Now().AddDate(0, 0, GetRetentionDays())
+		if m.IsSensitive {
+			updater["$unset"] = bsoncodec.M{
+				"message": "",
+			}
+		}
+	}
+	err = m.updateById(ctx, updater)
+	if err != nil {
+		log.Warn("Failed to update outbound message", map[string]interface{}{
+			"id":    m.Id.Hex(),
+			"error": err.Error(),
+		})
+	}
+	if m.Status == OUTBOUND_STATUS_PENDING {
+		return
+	}
+	if callback, ok := outboundCallbacks[m.Callback]; ok {
+		callback(ctx, *m, m.Status == OUTBOUND_STATUS_SENT)
+	}
+}
+
+// send 先发送文字再发送图片，文字发送成功即视为送达
+func (m *OutboundMessage) send(ctx context.Context) (int64, error) {
+	var (
+		messageId int64
+		err       error
+	)
+	instance := GetGocqInstance()
+	if m.Message != "" {
+		if m.TargetType == OUTBOUND_TARGET_GROUP {
+			messageId, err = instance.SendAtInGroup(ctx, m.TargetId, m.Mentions, m.Message)
+		} else {
+			messageId, err = instance.SendPrivateStringMessage(ctx, m.Message, m.TargetId)
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	for _, image := range m.Images {
+		if m.TargetType == OUTBOUND_TARGET_GROUP {
+			err = instance.SendGroupImageMessage(ctx, m.TargetId, image.Name, image.Url)
+		} else {
+			err = instance.SendPrivateImageMessage(ctx, m.TargetId, image.Name, image.Url)
+		}
+		if err == nil {
+			continue
+		}
+		// 只有图片的消息图片发送失败时需要重试
+		if m.Message == "" {
+			return 0, err
+		}
+		log.Warn("Failed to send image", map[string]interface{}{
+			"id":    m.Id.Hex(),
+			"image": image.Name,
+			"error": err.Error(),
+		})
+	}
+	return messageId, nil
+}
+
+func (m *OutboundMessage) updateById(ctx context.Context, updater bsoncodec.M) error {
+	condition := bsoncodec.M{
+		"_id": m.Id,
+	}
+	return repository.Mongo.UpdateOne(ctx, C_OUTBOUND_MESSAGE, condition, updater)
+}
+
+// GetRetentionDays 发送完成的消息保留的天数
+func GetRetentionDays() int {
+	return getIntSetting("outbox.retentionDays", defaultRetentionDays)
+}
+
+func getMaxAttempts() int {
+	return getIntSetting("outbox.maxAttempts", defaultMaxAttempts)
+}
+
+func getPerRecipientPerMinute() int {
+	return getIntSetting("outbox.perRecipientPerMinute", defaultPerRecipientPerMinute)
+}
+
+func getIntSetting(key string, defaultValue int) int {
+	if value := viper.GetInt(key); value > 0 {
+		return value
+	}
+	return defaultValue
+}
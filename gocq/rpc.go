@@ -0,0 +1,145 @@
+package gocq
+
+import (
+	"context"
+	"fmt"
+	"time"
+	"todo-reminder/repository/bsoncodec"
+	"todo-reminder/util"
+)
+
+const (
+	ACTION_STATUS_OK     = "ok"
+	ACTION_STATUS_ASYNC  = "async"
+	ACTION_STATUS_FAILED = "failed"
+
+	// 调用方的 ctx 没有设置超时时间时使用的超时时间
+	defaultCallTimeoutSeconds = 10
+)
+
+// ActionError gocq 返回的 status 不为 ok 时的错误
+type ActionError struct {
+	Action  string
+	Status  string
+	RetCode int64
+	Message string
+	Wording string
+}
+
+func (e *ActionError) Error() string {
+	message := e.Wording
+	if message == "" {
+		message = e.Message
+	}
+	return fmt.Sprintf("gocq action %s %s, retcode: %d, message: %s", e.Action, e.Status, e.RetCode, message)
+}
+
+func (r *WebsocketActionResponse) Err(action string) error {
+	if r.Status == ACTION_STATUS_OK || r.Status == ACTION_STATUS_ASYNC {
+		return nil
+	}
+	return &ActionError{
+		Action:  action,
+		Status:  r.Status,
+		RetCode: r.RetCode,
+		Message: r.Message,
+		Wording: r.Wording,
+	}
+}
+
+func (r *BaseResponse[T]) Err(action string) error {
+	if r.Status == ACTION_STATUS_OK || r.Status == ACTION_STATUS_ASYNC {
+		return nil
+	}
+	return &ActionError{
+		Action:  action,
+		Status:  r.Status,
+		RetCode: int64(r.Code),
+		Message: r.Message,
+		Wording: r.Wording,
+	}
+}
+
+func genEcho(action string) string {
+	return fmt.Sprintf("%s:%s", action, bsoncodec.NewObjectId().Hex())
+}
+
+// call 调用 gocq 的 action 并等待结果，result 不为 nil 时将返回的 data 解析到 result 中
+func (g *goCqWebsocket) call(ctx context.Context, action string, params map[string]interface{}, result interface{}) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultCallTimeoutSeconds*time.Second)
+		defer cancel()
+	}
+	req := WebsocketRequest{
+		Action: action,
+		Echo:   genEcho(action),
+		Params: params,
+	}
+	pending := make(chan WebsocketActionResponse, 1)
+	g.pendingLock.Lock()
+	g.pendingCalls[req.Echo] = pending
+	g.pendingLock.Unlock()
+	defer func() {
+		g.pendingLock.Lock()
+		delete(g.pendingCalls, req.Echo)
+		g.pendingLock.Unlock()
+	}()
+	if err := g.writeJSON(req); err != nil {
+		return err
+	}
+	select {
+	case resp := <-pending:
+		if err := resp.Err(action); err != nil {
+			return err
+		}
+		if result == nil {
+			return nil
+		}
+		return util.CopyByJson(resp.Data, result)
+	case <-ctx.Done():
+		return fmt.Errorf("gocq action %s: %w", action, ctx.Err())
+	}
+}
+
+// writeJSON websocket 连接不支持并发写
+func (g *goCqWebsocket) writeJSON(v interface{}) error {
+	g.writeLock.Lock()
+	defer g.writeLock.Unlock()
+	return g.action.WriteJSON(v)
+}
+
+// resolveCall 在锁内取出等待的调用，保证每个调用只会收到一个结果
+func (g *goCqWebsocket) resolveCall(resp WebsocketActionResponse) {
+	g.pendingLock.Lock()
+	pending, ok := g.pendingCalls[resp.Echo]
+	delete(g.pendingCalls, resp.Echo)
+	g.pendingLock.Unlock()
+	if ok {
+		deliverResponse(pending, resp)
+	}
+}
+
+// failPendingCalls 连接断开时结束所有等待中的调用
+func (g *goCqWebsocket) failPendingCalls(message string) {
+	g.pendingLock.Lock()
+	calls := g.pendingCalls
+	g.pendingCalls = make(map[string]chan WebsocketActionResponse)
+	g.pendingLock.Unlock()
+	for echo, pending := range calls {
+		deliverResponse(pending, WebsocketActionResponse{
+			Status:  ACTION_STATUS_FAILED,
+			RetCode: -1,
+			Message: message,
+			Echo:    echo,
+		})
+	}
+}
+
+// deliverResponse 不阻塞发送，channel 中已经有结果时丢弃
+func deliverResponse(pending chan WebsocketActionResponse, resp WebsocketActionResponse) {
+	select {
+	case pending <- resp:
+	default:
+	}
+}
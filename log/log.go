@@ -20,6 +20,10 @@ func init() {
 	logrus.SetLevel(logrus.WarnLevel)
 }
 
+func Info(message string, fields logrus.Fields) {
+	logrus.WithFields(fields).Info(message)
+}
+
 func Warn(message string, fields logrus.Fields) {
 	logrus.WithFields(fields).Warn(message)
 }
@@ -9,6 +9,8 @@ import (
 	"net/http"
 	"os"
 	"strings"
+	_ "time/tzdata"
+	_ "todo-reminder/bot"
 	_ "todo-reminder/conf"
 	"todo-reminder/controller"
 	"todo-reminder/cron"
@@ -90,6 +92,7 @@ func main() {
 		}
 		return
 	}
+	model.RunMigrations(context.Background())
 	go cron.Start()
 	startGin()
 }
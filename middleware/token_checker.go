@@ -2,9 +2,11 @@ package middleware
 
 import (
 	"github.com/gin-gonic/gin"
+	"github.com/qiniu/qmgo"
 	"net/http"
 	"todo-reminder/constant"
 	"todo-reminder/controller"
+	"todo-reminder/model"
 	"todo-reminder/util"
 )
 
@@ -24,15 +26,73 @@ func checkToken(ctx *gin.Context) {
 		})
 		return
 	}
+	if model.IsApiKey(tokenStr) {
+		checkApiKey(ctx, tokenStr)
+		return
+	}
 	token, err := util.ParseToken(tokenStr)
 	if err != nil {
 		controller.ReturnError(ctx, err)
 		return
 	}
+	// 会话注销后 access token 立即失效
+	if err := model.CSession.CheckActive(ctx, token.SessionId); err != nil {
+		controller.ReturnError(ctx, model.ErrSessionRevoked)
+		return
+	}
+	if !checkUser(ctx, token.UserId) {
+		return
+	}
 	ctx.Set(constant.GIN_KEY_USER_ID, token.UserId)
+	ctx.Set(constant.GIN_KEY_SESSION_ID, token.SessionId)
 	ctx.Next()
 }
 
+// checkApiKey API key 只能访问注册时声明了 scope 且密钥具备该 scope 的接口
+func checkApiKey(ctx *gin.Context, key string) {
+	apiKey, err := model.CApiKey.Authenticate(ctx, key)
+	if err != nil {
+		controller.ReturnError(ctx, err)
+		return
+	}
+	scope := controller.ApiScopes[ctx.Request.Method][ctx.FullPath()]
+	if !apiKey.HasScope(scope) {
+		ctx.AbortWithStatusJSON(http.StatusForbidden, map[string]string{
+			"message": "api key is not allowed to access this api",
+		})
+		return
+	}
+	if !checkUser(ctx, apiKey.UserId) {
+		return
+	}
+	ctx.Set(constant.GIN_KEY_USER_ID, apiKey.UserId)
+	ctx.Set(constant.GIN_KEY_API_KEY_ID, apiKey.Id.Hex())
+	ctx.Next()
+}
+
+// checkUser 已删除或被禁用的用户无法访问接口，管理员接口只有管理员可以访问
+func checkUser(ctx *gin.Context, userId string) bool {
+	user, err := model.CUser.GetByUserId(ctx, userId)
+	if err != nil && err != qmgo.ErrNoSuchDocuments {
+		controller.ReturnError(ctx, err)
+		return false
+	}
+	if err != nil || !user.IsEnabled {
+		ctx.AbortWithStatusJSON(http.StatusForbidden, map[string]string{
+			"message": model.ErrUserDisabled.Error(),
+		})
+		return false
+	}
+	paths := controller.AdminPath[ctx.Request.Method]
+	if util.StrInArray(ctx.FullPath(), &paths) && !user.IsAdmin() {
+		ctx.AbortWithStatusJSON(http.StatusForbidden, map[string]string{
+			"message": "admin only",
+		})
+		return false
+	}
+	return true
+}
+
 func noAuthHandler(ctx *gin.Context) bool {
 	path := ctx.FullPath()
 	method := ctx.Request.Method
@@ -22,6 +22,15 @@ const (
 
 var (
 	CAccessLog = &AccessLog{}
+
+	// 请求或响应中包含密码、验证码或 token 的接口，不记录请求体和响应体
+	sensitivePaths = []string{
+		"/user/login",
+		"/user/login/code",
+		"/user/token/refresh",
+		"/user/password",
+		"/user/apiKeys",
+	}
 )
 
 type AccessLog struct {
@@ -50,6 +59,9 @@ func (*AccessLog) Init(ctx *gin.Context) AccessLog {
 	return AccessLog{
 		Id: bsoncodec.NewObjectId(),
 		Body: func() string {
+			if isSensitivePath(ctx) {
+				return ""
+			}
 			if utf8.Valid(buf.Bytes()) {
 				return buf.String()
 			}
@@ -72,12 +84,16 @@ func (log *AccessLog) Record(ctx *gin.Context) {
 	log.EndTime = time.Now()
 	log.StatusCode = ctx.Writer.Status()
 	value, exists := ctx.Get(constant.GIN_KEY_RESPONSE_BODY)
-	if exists {
+	if exists && !isSensitivePath(ctx) {
 		log.ResponseBody = cast.ToString(value)
 	}
 	log.Create(ctx)
 }
 
+func isSensitivePath(ctx *gin.Context) bool {
+	return util.StrInArray(ctx.FullPath(), &sensitivePaths)
+}
+
 func (log *AccessLog) Create(ctx context.Context) error {
 	return repository.Mongo.Insert(ctx, C_ACCESS_LOG, log)
 }
@@ -0,0 +1,187 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"github.com/qiniu/qmgo/options"
+	mgo_option "go.mongodb.org/mongo-driver/mongo/options"
+	"strings"
+	"time"
+	"todo-reminder/repository"
+	"todo-reminder/repository/bsoncodec"
+	"todo-reminder/util"
+)
+
+const (
+	C_API_KEY = "apiKey"
+
+	// API key 的前缀，用于和 access token 区分
+	API_KEY_PREFIX = "trk_"
+
+	API_SCOPE_READ_RECORDS = "records:read"
+	API_SCOPE_DONE_RECORDS = "records:done"
+	API_SCOPE_READ_TODOS   = "todos:read"
+	API_SCOPE_WRITE_TODOS  = "todos:write"
+
+	maxApiKeyCount = 20
+	// 最近使用时间的更新间隔，避免每个请求都写数据库
+	apiKeyLastUsedInterval = time.Minute
+)
+
+var (
+	CApiKey = &ApiKey{}
+
+	ErrInvalidApiKey   = errors.New("invalid api key")
+	ErrTooManyApiKeys  = errors.New("too many api keys")
+	ErrInvalidApiScope = errors.New("invalid api scope")
+
+	apiScopes = []string{
+		API_SCOPE_READ_RECORDS,
+		API_SCOPE_DONE_RECORDS,
+		API_SCOPE_READ_TODOS,
+		API_SCOPE_WRITE_TODOS,
+	}
+)
+
+func init() {
+	repository.Mongo.CreateIndex(context.Background(), C_API_KEY, options.IndexModel{
+		Key: []string{"keyHash"},
+		IndexOptions: &mgo_option.IndexOptions{
+			Background: util.PtrValue[bool](true),
+			Unique:     util.PtrValue[bool](true),
+		},
+	})
+	repository.Mongo.CreateIndex(context.Background(), C_API_KEY, options.IndexModel{
+		Key: []string{"userId", "isRevoked"},
+		IndexOptions: &mgo_option.IndexOptions{
+			Background: util.PtrValue[bool](true),
+		},
+	})
+}
+
+// ApiKey 用户为脚本等自动化工具创建的密钥，只能访问 scopes 允许的接口
+type ApiKey struct {
+	Id        bsoncodec.ObjectId `bson:"_id"`
+	UserId    string             `bson:"userId"`
+	Name      string             `bson:"name"`
+	CreatedAt time.Time          `bson:"createdAt"`
+	UpdatedAt time.Time          `bson:"updatedAt"`
+	// 只保存密钥的摘要，密钥只在创建时返回一次
+	KeyHash string `bson:"keyHash"`
+	// 密钥的前几位，用于用户区分不同的密钥
+	KeyPrefix string   `bson:"keyPrefix"`
+	Scopes    []string `bson:"scopes"`
+	// 为空时不过期
+	ExpiredAt  time.Time `bson:"expiredAt,omitempty"`
+	LastUsedAt time.Time `bson:"lastUsedAt,omitempty"`
+	IsRevoked  bool      `bson:"isRevoked"`
+	RevokedAt  time.Time `bson:"revokedAt,omitempty"`
+}
+
+func IsValidApiScope(scope string) bool {
+	return util.StrInArray(scope, &apiScopes)
+}
+
+func IsApiKey(token string) bool {
+	return strings.HasPrefix(token, API_KEY_PREFIX)
+}
+
+func (k *ApiKey) HasScope(scope string) bool {
+	return scope != "" && util.StrInArray(scope, &k.Scopes)
+}
+
+// Create 创建密钥并返回密钥明文
+func (k *ApiKey) Create(ctx context.Context) (string, error) {
+	k.Name = strings.TrimSpace(k.Name)
+	if k.Name == "" {
+		return "", errors.New("empty name")
+	}
+	k.Scopes = util.Unique(k.Scopes)
+	if len(k.Scopes) == 0 {
+		return "", ErrInvalidApiScope
+	}
+	for _, scope := range k.Scopes {
+		if !IsValidApiScope(scope) {
+			return "", ErrInvalidApiScope
+		}
+	}
+	if !k.ExpiredAt.IsZero() && k.ExpiredAt.Before(time.Now()) {
+		return "", errors.New("invalid expiry")
+	}
+	count, err := repository.Mongo.Count(ctx, C_API_KEY, genActiveApiKeysCondition(k.UserId))
+	if err != nil {
+		return "", err
+	}
+	if count >= maxApiKeyCount {
+		return "", ErrTooManyApiKeys
+	}
+	key := API_KEY_PREFIX + util.GenSecureToken(32)
+	k.Id = bsoncodec.NewObjectId()
+	k.KeyHash = util.HashToken(key)
+	k.KeyPrefix = key[:len(API_KEY_PREFIX)+6]
+	k.CreatedAt = time.Now()
+	k.UpdatedAt = time.Now()
+	k.IsRevoked = false
+	if err := repository.Mongo.Insert(ctx, C_API_KEY, k); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// genActiveApiKeysCondition 未注销且未过期的密钥
+func genActiveApiKeysCondition(userId string) bsoncodec.M {
+	return bsoncodec.M{
+		"userId":    userId,
+		"isRevoked": false,
+		"$or": []bsoncodec.M{
+			{"expiredAt": bsoncodec.M{"$exists": false}},
+			{"expiredAt": bsoncodec.M{"$gt": time.Now()}},
+		},
+	}
+}
+
+func (*ApiKey) ListByUserId(ctx context.Context, userId string) ([]ApiKey, error) {
+	var keys []ApiKey
+	err := repository.Mongo.FindAllWithSorter(ctx, C_API_KEY, []string{"-createdAt"}, genActiveApiKeysCondition(userId), &keys)
+	return keys, err
+}
+
+func (*ApiKey) Revoke(ctx context.Context, userId string, id bsoncodec.ObjectId) error {
+	condition := bsoncodec.M{
+		"_id":       id,
+		"userId":    userId,
+		"isRevoked": false,
+	}
+	updater := bsoncodec.M{
+		"$set": bsoncodec.M{
+			"isRevoked": true,
+			"revokedAt": time.Now(),
+			"updatedAt": time.Now(),
+		},
+	}
+	return repository.Mongo.UpdateOne(ctx, C_API_KEY, condition, updater)
+}
+
+// Authenticate 校验密钥明文，返回未注销且未过期的密钥
+func (*ApiKey) Authenticate(ctx context.Context, key string) (ApiKey, error) {
+	if !IsApiKey(key) {
+		return ApiKey{}, ErrInvalidApiKey
+	}
+	apiKey := ApiKey{}
+	err := repository.Mongo.FindOne(ctx, C_API_KEY, bsoncodec.M{"keyHash": util.HashToken(key)}, &apiKey)
+	if err != nil {
+		return ApiKey{}, ErrInvalidApiKey
+	}
+	now := time.Now()
+	if apiKey.IsRevoked || (!apiKey.ExpiredAt.IsZero() && !apiKey.ExpiredAt.After(now)) {
+		return ApiKey{}, ErrInvalidApiKey
+	}
+	if now.Sub(apiKey.LastUsedAt) > apiKeyLastUsedInterval {
+		repository.Mongo.UpdateOne(ctx, C_API_KEY, bsoncodec.M{"_id": apiKey.Id}, bsoncodec.M{
+			"$set": bsoncodec.M{
+				"lastUsedAt": now,
+			},
+		})
+	}
+	return apiKey, nil
+}
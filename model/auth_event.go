@@ -0,0 +1,101 @@
+package model
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/qiniu/qmgo/options"
+	mgo_option "go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+	"todo-reminder/log"
+	"todo-reminder/repository"
+	"todo-reminder/repository/bsoncodec"
+	"todo-reminder/util"
+)
+
+const (
+	C_AUTH_EVENT = "authEvent"
+
+	AUTH_EVENT_LOGIN_SUCCEEDED      = "loginSucceeded"
+	AUTH_EVENT_LOGIN_FAILED         = "loginFailed"
+	AUTH_EVENT_LOGIN_LOCKED         = "loginLocked"
+	AUTH_EVENT_LOGIN_CODE_SENT      = "loginCodeSent"
+	AUTH_EVENT_LOGIN_CODE_REJECTED  = "loginCodeRejected"
+	AUTH_EVENT_TOKEN_REFRESHED      = "tokenRefreshed"
+	AUTH_EVENT_TOKEN_REFRESH_FAILED = "tokenRefreshFailed"
+	AUTH_EVENT_LOGOUT               = "logout"
+	AUTH_EVENT_LOGOUT_ALL           = "logoutAll"
+	AUTH_EVENT_PASSWORD_CHANGED     = "passwordChanged"
+	AUTH_EVENT_USER_ENABLED         = "userEnabled"
+	AUTH_EVENT_USER_DISABLED        = "userDisabled"
+	AUTH_EVENT_ROLE_CHANGED         = "roleChanged"
+	AUTH_EVENT_PASSWORD_RESET       = "passwordReset"
+)
+
+var (
+	CAuthEvent = &AuthEvent{}
+)
+
+func init() {
+	repository.Mongo.CreateIndex(context.Background(), C_AUTH_EVENT, options.IndexModel{
+		Key: []string{"userId", "-createdAt"},
+		IndexOptions: &mgo_option.IndexOptions{
+			Background: util.PtrValue[bool](true),
+		},
+	})
+	repository.Mongo.CreateIndex(context.Background(), C_AUTH_EVENT, options.IndexModel{
+		Key: []string{"ip", "-createdAt"},
+		IndexOptions: &mgo_option.IndexOptions{
+			Background: util.PtrValue[bool](true),
+		},
+	})
+	repository.Mongo.CreateIndex(context.Background(), C_AUTH_EVENT, options.IndexModel{
+		Key: []string{"event", "-createdAt"},
+		IndexOptions: &mgo_option.IndexOptions{
+			Background: util.PtrValue[bool](true),
+		},
+	})
+}
+
+// AuthEvent 登录、刷新 token、注销等认证相关的审计记录
+type AuthEvent struct {
+	Id        bsoncodec.ObjectId `bson:"_id"`
+	Event     string             `bson:"event"`
+	UserId    string             `bson:"userId,omitempty"`
+	SessionId string             `bson:"sessionId,omitempty"`
+	Ip        string             `bson:"ip,omitempty"`
+	UserAgent string             `bson:"userAgent,omitempty"`
+	RequestId string             `bson:"requestId,omitempty"`
+	// 登录方式或失败原因
+	Detail    string    `bson:"detail,omitempty"`
+	CreatedAt time.Time `bson:"createdAt"`
+}
+
+// Record 记录认证事件，请求来自 gin 时同时记录 IP 和 User-Agent，写入失败不影响认证流程
+func (*AuthEvent) Record(ctx context.Context, event, userId, sessionId, detail string) {
+	e := AuthEvent{
+		Id:        bsoncodec.NewObjectId(),
+		Event:     event,
+		UserId:    userId,
+		SessionId: sessionId,
+		RequestId: util.ExtractRequestId(ctx),
+		Detail:    detail,
+		CreatedAt: time.Now(),
+	}
+	if ginCtx, ok := ctx.(*gin.Context); ok {
+		e.Ip = ginCtx.ClientIP()
+		e.UserAgent = ginCtx.Request.UserAgent()
+	}
+	if err := repository.Mongo.Insert(ctx, C_AUTH_EVENT, e); err != nil {
+		log.Warn("Failed to record auth event", map[string]interface{}{
+			"event":  event,
+			"userId": userId,
+			"error":  err.Error(),
+		})
+	}
+}
+
+func (*AuthEvent) ListByPagination(ctx context.Context, condition bsoncodec.M, page, perPage int64, orderBy []string) (int64, []AuthEvent, error) {
+	var events []AuthEvent
+	total, err := repository.Mongo.FindAllWithPage(ctx, C_AUTH_EVENT, orderBy, page, perPage, condition, &events)
+	return total, events, err
+}
@@ -0,0 +1,170 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/qiniu/qmgo"
+	"github.com/spf13/viper"
+	"time"
+	"todo-reminder/repository"
+	"todo-reminder/repository/bsoncodec"
+)
+
+const (
+	C_AUTH_THROTTLE = "authThrottle"
+
+	defaultMaxAccountFailures   = 5
+	defaultMaxIpFailures        = 20
+	defaultFailureWindowMinutes = 15
+	defaultBaseLockoutSeconds   = 60
+	defaultMaxLockoutMinutes    = 24 * 60
+)
+
+var (
+	CAuthThrottle = &AuthThrottle{}
+
+	ErrTooManyAttempts = errors.New("too many attempts")
+)
+
+// AuthThrottle 记录一个账号或 IP 在时间窗口内的失败次数，达到上限后锁定，每次锁定的时间翻倍
+type AuthThrottle struct {
+	// 如 user:password:10000@127.0.0.1、ip:127.0.0.1
+	Key             string    `bson:"_id"`
+	FailureCount    int       `bson:"failureCount"`
+	WindowStartedAt time.Time `bson:"windowStartedAt"`
+	LockCount       int       `bson:"lockCount"`
+	LockedUntil     time.Time `bson:"lockedUntil,omitempty"`
+	UpdatedAt       time.Time `bson:"updatedAt"`
+}
+
+// GenAccountThrottleKey 账号的失败次数按登录方式和 IP 分开记录，
+// 其他人猜密码不会锁定账号本人的登录，密码登录被锁定时也可以使用验证码登录
+func GenAccountThrottleKey(userId, ip, method string) string {
+	return fmt.Sprintf("user:%s:%s@%s", method, userId, ip)
+}
+
+func GenIpThrottleKey(ip string) string {
+	return "ip:" + ip
+}
+
+// GetMaxFailures 账号和 IP 分别由 auth.maxAccountFailures 和 auth.maxIpFailures 配置
+func GetMaxFailures(isIp bool) int {
+	if isIp {
+		return getPositiveInt("auth.maxIpFailures", defaultMaxIpFailures)
+	}
+	return getPositiveInt("auth.maxAccountFailures", defaultMaxAccountFailures)
+}
+
+func getPositiveInt(key string, defaultValue int) int {
+	if value := viper.GetInt(key); value > 0 {
+		return value
+	}
+	return defaultValue
+}
+
+// GetLockoutDuration 第 lockCount 次锁定的时长，从 auth.baseLockoutSeconds 开始翻倍，不超过 auth.maxLockoutMinutes
+func GetLockoutDuration(lockCount int) time.Duration {
+	duration := time.Duration(getPositiveInt("auth.baseLockoutSeconds", defaultBaseLockoutSeconds)) * time.Second
+	maxDuration := time.Duration(getPositiveInt("auth.maxLockoutMinutes", defaultMaxLockoutMinutes)) * time.Minute
+	for i := 1; i < lockCount && duration < maxDuration; i++ {
+		duration *= 2
+	}
+	if duration > maxDuration {
+		return maxDuration
+	}
+	return duration
+}
+
+func getFailureWindow() time.Duration {
+	return time.Duration(getPositiveInt("auth.failureWindowMinutes", defaultFailureWindowMinutes)) * time.Minute
+}
+
+// Check 任意一个 key 处于锁定状态时返回 ErrTooManyAttempts
+func (*AuthThrottle) Check(ctx context.Context, keys ...string) error {
+	condition := bsoncodec.M{
+		"_id": bsoncodec.M{
+			"$in": keys,
+		},
+		"lockedUntil": bsoncodec.M{
+			"$gt": time.Now(),
+		},
+	}
+	var throttles []AuthThrottle
+	err := repository.Mongo.FindAllWithSorter(ctx, C_AUTH_THROTTLE, []string{"-lockedUntil"}, condition, &throttles)
+	if err != nil {
+		return err
+	}
+	if len(throttles) > 0 {
+		return fmt.Errorf("%w, retry after %s", ErrTooManyAttempts, throttles[0].LockedUntil.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// RecordFailure 记录一次失败，时间窗口内失败次数达到 maxFailures 后锁定并重新计数
+func (*AuthThrottle) RecordFailure(ctx context.Context, key string, maxFailures int) error {
+	now := time.Now()
+	// 时间窗口已经结束时重新计数
+	_, err := repository.Mongo.UpdateAll(ctx, C_AUTH_THROTTLE, bsoncodec.M{
+		"_id": key,
+		"windowStartedAt": bsoncodec.M{
+			"$lt": now.Add(-getFailureWindow()),
+		},
+	}, bsoncodec.M{
+		"$set": bsoncodec.M{
+			"failureCount":    0,
+			"windowStartedAt": now,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	change := qmgo.Change{
+		Upsert:    true,
+		ReturnNew: true,
+		Update: bsoncodec.M{
+			"$inc": bsoncodec.M{
+				"failureCount": 1,
+			},
+			"$set": bsoncodec.M{
+				"updatedAt": now,
+			},
+			"$setOnInsert": bsoncodec.M{
+				"windowStartedAt": now,
+				"lockCount":       0,
+			},
+		},
+	}
+	throttle := AuthThrottle{}
+	err = repository.Mongo.FindAndApply(ctx, C_AUTH_THROTTLE, bsoncodec.M{"_id": key}, change, &throttle)
+	if err != nil {
+		return err
+	}
+	if throttle.FailureCount < maxFailures {
+		return nil
+	}
+	return repository.Mongo.UpdateOne(ctx, C_AUTH_THROTTLE, bsoncodec.M{"_id": key}, bsoncodec.M{
+		"$set": bsoncodec.M{
+			"failureCount":    0,
+			"windowStartedAt": now,
+			"lockCount":       throttle.LockCount + 1,
+			"lockedUntil":     now.Add(GetLockoutDuration(throttle.LockCount + 1)),
+			"updatedAt":       now,
+		},
+	})
+}
+
+// Reset 登录成功后清除账号的失败记录、锁定次数和锁定状态
+func (*AuthThrottle) Reset(ctx context.Context, key string) error {
+	_, err := repository.Mongo.UpdateAll(ctx, C_AUTH_THROTTLE, bsoncodec.M{"_id": key}, bsoncodec.M{
+		"$set": bsoncodec.M{
+			"failureCount": 0,
+			"lockCount":    0,
+			"updatedAt":    time.Now(),
+		},
+		"$unset": bsoncodec.M{
+			"lockedUntil": "",
+		},
+	})
+	return err
+}
@@ -0,0 +1,123 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"github.com/spf13/viper"
+	"time"
+	"todo-reminder/repository/bsoncodec"
+	"todo-reminder/util"
+)
+
+const (
+	// 服务恢复后补发所有错过的提醒
+	CATCH_UP_POLICY_ALL = "all"
+	// 只补发最近一次错过的提醒
+	CATCH_UP_POLICY_LATEST = "latest"
+	// 不补发，重复待办顺延到下一次
+	CATCH_UP_POLICY_SKIP = "skip"
+
+	defaultLateThreshold = 5 * time.Minute
+	// 顺延重复待办时最多向后计算的次数
+	maxRollForwardTimes = 1000
+)
+
+func IsValidCatchUpPolicy(policy string) bool {
+	return policy == "" || util.StrInArray(policy, &[]string{CATCH_UP_POLICY_ALL, CATCH_UP_POLICY_LATEST, CATCH_UP_POLICY_SKIP})
+}
+
+// GetLateThreshold 提醒超过该时间未发送视为错过，由 reminder.lateThresholdSeconds 配置
+func GetLateThreshold() time.Duration {
+	if seconds := viper.GetInt64("reminder.lateThresholdSeconds"); seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	return defaultLateThreshold
+}
+
+func (r Reminder) IsLate(now time.Time) bool {
+	return now.Sub(r.RemindAt) > GetLateThreshold()
+}
+
+// ApplyCatchUpPolicy 根据待办的补发策略筛选需要发送的提醒，不需要发送的提醒标记为已提醒
+func (t *TodoRecord) ApplyCatchUpPolicy(ctx context.Context, reminders []Reminder, now time.Time) ([]Reminder, error) {
+	var late, onTime []Reminder
+	for _, reminder := range reminders {
+		if reminder.IsLate(now) {
+			late = append(late, reminder)
+		} else {
+			onTime = append(onTime, reminder)
+		}
+	}
+	if len(late) == 0 {
+		return reminders, nil
+	}
+	switch t.CatchUpPolicy {
+	case CATCH_UP_POLICY_LATEST:
+		// reminders 按提醒时间排序，保留最后一个
+		for _, reminder := range reminders[:len(reminders)-1] {
+			if err := t.MarkReminderAsReminded(ctx, reminder.Offset); err != nil {
+				return nil, err
+			}
+		}
+		return reminders[len(reminders)-1:], nil
+	case CATCH_UP_POLICY_SKIP:
+		// 重复待办的所有提醒都已错过时整体顺延到下一次
+		if t.IsRepeatable && len(onTime) == 0 && t.isAllLate(now) {
+			err := t.rollForward(ctx, now)
+			switch {
+			case err == nil:
+				// 已经顺延到下一次，本次不再提醒
+				return nil, nil
+			case errors.Is(err, ErrNoMoreOccurrence):
+				// 没有下一次时将错过的提醒标记为已提醒
+			default:
+				return nil, err
+			}
+		}
+		for _, reminder := range late {
+			if err := t.MarkReminderAsReminded(ctx, reminder.Offset); err != nil {
+				return nil, err
+			}
+		}
+		return onTime, nil
+	}
+	return reminders, nil
+}
+
+func (t *TodoRecord) isAllLate(now time.Time) bool {
+	for _, reminder := range t.GetReminders() {
+		if !reminder.IsLate(now) {
+			return false
+		}
+	}
+	return true
+}
+
+// rollForward 将记录推迟到待办在 now 之后的下一次提醒时间
+func (t *TodoRecord) rollForward(ctx context.Context, now time.Time) error {
+	todo, err := CTodo.GetById(ctx, t.TodoId)
+	if err != nil {
+		return err
+	}
+	loc := util.LoadLocation(t.Timezone)
+	setting := todo.RemindSetting
+	next := t.RemindAt
+	for i := 0; !next.After(now); i++ {
+		if i >= maxRollForwardTimes {
+			return errors.New("too many occurrences to roll forward")
+		}
+		next, err = setting.GetNextRemindAt(ctx, loc)
+		if err != nil {
+			return err
+		}
+	}
+	updater := bsoncodec.M{
+		"$set": bsoncodec.M{
+			"remindSetting": setting,
+		},
+	}
+	if err := todo.UpdateById(ctx, todo.Id, updater); err != nil {
+		return err
+	}
+	return CTodoRecord.Delay(ctx, t.Id, next.Sub(t.RemindAt))
+}
@@ -0,0 +1,93 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"github.com/qiniu/qmgo"
+	"time"
+	"todo-reminder/repository"
+	"todo-reminder/repository/bsoncodec"
+)
+
+// ChecklistItem 待办中的子任务，按顺序排列
+type ChecklistItem struct {
+	Id        bsoncodec.ObjectId `json:"id" bson:"id"`
+	Content   string             `json:"content" bson:"content"`
+	IsChecked bool               `json:"isChecked" bson:"isChecked"`
+	CheckedAt time.Time          `json:"checkedAt" bson:"checkedAt,omitempty"`
+}
+
+// GenChecklist 根据待办的清单生成记录的清单，所有子任务都未完成
+func GenChecklist(items []ChecklistItem) []ChecklistItem {
+	if len(items) == 0 {
+		return nil
+	}
+	checklist := make([]ChecklistItem, 0, len(items))
+	for _, item := range items {
+		checklist = append(checklist, ChecklistItem{
+			Id:      item.Id,
+			Content: item.Content,
+		})
+	}
+	return checklist
+}
+
+// GetChecklistProgress 获取已完成的子任务数量和子任务总数
+func (t *TodoRecord) GetChecklistProgress() (int, int) {
+	checked := 0
+	for _, item := range t.Checklist {
+		if item.IsChecked {
+			checked++
+		}
+	}
+	return checked, len(t.Checklist)
+}
+
+func (t *TodoRecord) formatChecklistProgress() string {
+	checked, total := t.GetChecklistProgress()
+	if total == 0 {
+		return ""
+	}
+	return fmt.Sprintf("（%d/%d）", checked, total)
+}
+
+// CheckItem 修改子任务的完成状态，开启自动完成时所有子任务完成后按 userId 的身份完成记录
+func (*TodoRecord) CheckItem(ctx context.Context, id, itemId bsoncodec.ObjectId, userId string, isChecked bool) error {
+	condition := bsoncodec.M{
+		"_id":          id,
+		"checklist.id": itemId,
+	}
+	updater := bsoncodec.M{
+		"$set": bsoncodec.M{
+			"checklist.$.isChecked": isChecked,
+			"checklist.$.checkedAt": time.Now(),
+			"updatedAt":             time.Now(),
+		},
+	}
+	if !isChecked {
+		updater = bsoncodec.M{
+			"$set": bsoncodec.M{
+				"checklist.$.isChecked": false,
+				"updatedAt":             time.Now(),
+			},
+			"$unset": bsoncodec.M{
+				"checklist.$.checkedAt": "",
+			},
+		}
+	}
+	change := qmgo.Change{
+		Upsert:    false,
+		ReturnNew: true,
+		Update:    updater,
+	}
+	r := TodoRecord{}
+	err := repository.Mongo.FindAndApply(ctx, C_TODO_RECORD, condition, change, &r)
+	if err != nil {
+		return err
+	}
+	checked, total := r.GetChecklistProgress()
+	if isChecked && r.AutoCompleteChecklist && !r.HasBeenDone && checked == total {
+		return CTodoRecord.DoneByUser(ctx, r, userId)
+	}
+	return nil
+}
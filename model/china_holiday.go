@@ -101,5 +101,5 @@ func (c *ChinaHoliday) ParseDate(arg time.Time) (time.Time, error) {
 	if err != nil {
 		return t, err
 	}
-	return time.Date(t.Year(), t.Month(), t.Day(), arg.Hour(), arg.Minute(), arg.Second(), arg.Nanosecond(), time.Local), nil
+	return time.Date(t.Year(), t.Month(), t.Day(), arg.Hour(), arg.Minute(), arg.Second(), arg.Nanosecond(), arg.Location()), nil
 }
@@ -0,0 +1,195 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"github.com/qiniu/qmgo"
+	"github.com/qiniu/qmgo/options"
+	mgo_option "go.mongodb.org/mongo-driver/mongo/options"
+	"regexp"
+	"time"
+	"todo-reminder/repository"
+	"todo-reminder/repository/bsoncodec"
+	"todo-reminder/util"
+)
+
+const (
+	C_PROJECT = "project"
+	C_TAG     = "tag"
+)
+
+var (
+	// CProject 待办所属的清单，一个待办最多属于一个清单
+	CProject = &LabelCollection{
+		collection: C_PROJECT,
+		field:      "projectId",
+	}
+	// CTag 待办的标签，一个待办可以有多个标签
+	CTag = &LabelCollection{
+		collection: C_TAG,
+		field:      "tags",
+		isMultiple: true,
+	}
+
+	ErrDuplicateName = errors.New("duplicate name")
+	ErrInvalidColor  = errors.New("invalid color")
+
+	colorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+)
+
+func init() {
+	for _, collection := range []string{C_PROJECT, C_TAG} {
+		// 同一个用户未删除的清单或标签不能重名
+		repository.Mongo.CreateIndex(context.Background(), collection, options.IndexModel{
+			Key: []string{"userId", "name"},
+			IndexOptions: &mgo_option.IndexOptions{
+				Background: util.PtrValue[bool](true),
+				Unique:     util.PtrValue[bool](true),
+				PartialFilterExpression: bsoncodec.M{
+					"isDeleted": false,
+				},
+			},
+		})
+	}
+}
+
+// Label 清单和标签，两者的字段相同，保存在不同的集合中
+type Label struct {
+	Id        bsoncodec.ObjectId `bson:"_id"`
+	IsDeleted bool               `bson:"isDeleted"`
+	CreatedAt time.Time          `bson:"createdAt"`
+	UpdatedAt time.Time          `bson:"updatedAt"`
+	UserId    string             `bson:"userId"`
+	Name      string             `bson:"name"`
+	// #RRGGBB 格式，可以为空
+	Color string `bson:"color,omitempty"`
+}
+
+// LabelCollection 清单或标签的集合，field 为待办和记录中引用它的字段
+type LabelCollection struct {
+	collection string
+	field      string
+	// 引用字段是否为数组
+	isMultiple bool
+}
+
+// IsValidColor 颜色为空或 #RRGGBB 格式
+func IsValidColor(color string) bool {
+	return color == "" || colorPattern.MatchString(color)
+}
+
+func (c *LabelCollection) Create(ctx context.Context, l *Label) error {
+	if err := l.validate(); err != nil {
+		return err
+	}
+	l.Id = bsoncodec.NewObjectId()
+	l.IsDeleted = false
+	l.CreatedAt = time.Now()
+	l.UpdatedAt = time.Now()
+	return transDuplicateNameError(repository.Mongo.Insert(ctx, c.collection, l))
+}
+
+func (c *LabelCollection) Update(ctx context.Context, l *Label) error {
+	if err := l.validate(); err != nil {
+		return err
+	}
+	condition := bsoncodec.M{
+		"_id":       l.Id,
+		"userId":    l.UserId,
+		"isDeleted": false,
+	}
+	updater := bsoncodec.M{
+		"$set": bsoncodec.M{
+			"name":      l.Name,
+			"color":     l.Color,
+			"updatedAt": time.Now(),
+		},
+	}
+	return transDuplicateNameError(repository.Mongo.UpdateOne(ctx, c.collection, condition, updater))
+}
+
+func (l *Label) validate() error {
+	if l.Name == "" {
+		return errors.New("missing name")
+	}
+	if !IsValidColor(l.Color) {
+		return ErrInvalidColor
+	}
+	return nil
+}
+
+// transDuplicateNameError 重名由唯一索引保证
+func transDuplicateNameError(err error) error {
+	if qmgo.IsDup(err) {
+		return ErrDuplicateName
+	}
+	return err
+}
+
+// DeleteById 删除清单或标签，同时从待办和记录中移除
+func (c *LabelCollection) DeleteById(ctx context.Context, userId string, id bsoncodec.ObjectId) error {
+	condition := bsoncodec.M{
+		"_id":       id,
+		"userId":    userId,
+		"isDeleted": false,
+	}
+	updater := bsoncodec.M{
+		"$set": bsoncodec.M{
+			"isDeleted": true,
+			"updatedAt": time.Now(),
+		},
+	}
+	if err := repository.Mongo.UpdateOne(ctx, c.collection, condition, updater); err != nil {
+		return err
+	}
+	detacher := bsoncodec.M{
+		"$unset": bsoncodec.M{
+			c.field: "",
+		},
+	}
+	if c.isMultiple {
+		detacher = bsoncodec.M{
+			"$pull": bsoncodec.M{
+				c.field: id,
+			},
+		}
+	}
+	if _, err := repository.Mongo.UpdateAll(ctx, C_TODO, bsoncodec.M{c.field: id}, detacher); err != nil {
+		return err
+	}
+	_, err := repository.Mongo.UpdateAll(ctx, C_TODO_RECORD, bsoncodec.M{c.field: id}, detacher)
+	return err
+}
+
+func (c *LabelCollection) ListByUserId(ctx context.Context, userId string) ([]Label, error) {
+	condition := bsoncodec.M{
+		"userId":    userId,
+		"isDeleted": false,
+	}
+	var labels []Label
+	err := repository.Mongo.FindAllWithSorter(ctx, c.collection, []string{"createdAt"}, condition, &labels)
+	return labels, err
+}
+
+func (c *LabelCollection) GetById(ctx context.Context, userId string, id bsoncodec.ObjectId) (Label, error) {
+	condition := bsoncodec.M{
+		"_id":       id,
+		"userId":    userId,
+		"isDeleted": false,
+	}
+	l := Label{}
+	err := repository.Mongo.FindOne(ctx, c.collection, condition, &l)
+	return l, err
+}
+
+// CountByIds 统计 ids 中属于该用户且未删除的数量，用于校验待办的标签
+func (c *LabelCollection) CountByIds(ctx context.Context, userId string, ids []bsoncodec.ObjectId) (int64, error) {
+	condition := bsoncodec.M{
+		"_id": bsoncodec.M{
+			"$in": ids,
+		},
+		"userId":    userId,
+		"isDeleted": false,
+	}
+	return repository.Mongo.Count(ctx, c.collection, condition)
+}
@@ -0,0 +1,83 @@
+package model
+
+import (
+	"context"
+	"github.com/qiniu/qmgo"
+	"time"
+	"todo-reminder/repository"
+	"todo-reminder/repository/bsoncodec"
+)
+
+const (
+	C_LEASE = "lease"
+)
+
+var (
+	CLease = &Lease{}
+)
+
+// Lease 多个实例同时运行时，同一个定时任务只能由持有租约的实例执行
+type Lease struct {
+	// 租约名称，一般为定时任务的名称
+	Name      string    `bson:"_id"`
+	Owner     string    `bson:"owner"`
+	ExpiredAt time.Time `bson:"expiredAt"`
+	UpdatedAt time.Time `bson:"updatedAt"`
+}
+
+// Acquire 获取或续期租约，租约被其他实例持有且未过期时返回 false
+func (*Lease) Acquire(ctx context.Context, name, owner string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	condition := bsoncodec.M{
+		"_id": name,
+		"$or": []bsoncodec.M{
+			{
+				"owner": owner,
+			},
+			{
+				"expiredAt": bsoncodec.M{
+					"$lte": now,
+				},
+			},
+		},
+	}
+	change := qmgo.Change{
+		Upsert:    true,
+		ReturnNew: true,
+		Update: bsoncodec.M{
+			"$set": bsoncodec.M{
+				"owner":     owner,
+				"expiredAt": now.Add(ttl),
+				"updatedAt": now,
+			},
+		},
+	}
+	err := repository.Mongo.FindAndApply(ctx, C_LEASE, condition, change, &Lease{})
+	// 租约被其他实例持有时条件不匹配，upsert 会因为 _id 重复而失败
+	if qmgo.IsDup(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Release 释放租约，其他实例可以立即获取
+func (*Lease) Release(ctx context.Context, name, owner string) error {
+	condition := bsoncodec.M{
+		"_id":   name,
+		"owner": owner,
+	}
+	updater := bsoncodec.M{
+		"$set": bsoncodec.M{
+			"expiredAt": time.Now(),
+			"updatedAt": time.Now(),
+		},
+	}
+	err := repository.Mongo.UpdateOne(ctx, C_LEASE, condition, updater)
+	if err == qmgo.ErrNoSuchDocuments {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,166 @@
+package model
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"github.com/qiniu/qmgo"
+	"time"
+	"todo-reminder/gocq"
+	"todo-reminder/repository"
+	"todo-reminder/repository/bsoncodec"
+	"todo-reminder/util"
+)
+
+const (
+	C_LOGIN_CODE = "loginCode"
+
+	loginCodeLength                 = 6
+	defaultLoginCodeTTLSeconds      = 300
+	defaultLoginCodeCooldownSeconds = 60
+	// 每个验证码最多尝试的次数
+	maxLoginCodeAttempts = 5
+)
+
+var (
+	CLoginCode = &LoginCode{}
+
+	ErrInvalidLoginCode = errors.New("invalid or expired login code")
+	ErrLoginCodeTooSoon = errors.New("login code was sent recently, try again later")
+)
+
+// LoginCode 通过 QQ 发送的一次性登录验证码，每个用户同时只有一个有效的验证码
+type LoginCode struct {
+	UserId string `bson:"_id"`
+	// 只保存验证码的摘要
+	CodeHash     string    `bson:"codeHash"`
+	ExpiredAt    time.Time `bson:"expiredAt"`
+	AttemptCount int       `bson:"attemptCount"`
+	LastSentAt   time.Time `bson:"lastSentAt"`
+	UpdatedAt    time.Time `bson:"updatedAt"`
+}
+
+func getLoginCodeTTL() time.Duration {
+	return time.Duration(getPositiveInt("auth.loginCodeTTLSeconds", defaultLoginCodeTTLSeconds)) * time.Second
+}
+
+func getLoginCodeCooldown() time.Duration {
+	return time.Duration(getPositiveInt("auth.loginCodeCooldownSeconds", defaultLoginCodeCooldownSeconds)) * time.Second
+}
+
+// Generate 生成新的验证码并使旧的验证码失效，距离上次发送不足冷却时间时返回 ErrLoginCodeTooSoon
+func (*LoginCode) Generate(ctx context.Context, userId string) (string, error) {
+	now := time.Now()
+	code := util.GenSecureDigits(loginCodeLength)
+	condition := bsoncodec.M{
+		"_id": userId,
+		"lastSentAt": bsoncodec.M{
+			"$lte": now.Add(-getLoginCodeCooldown()),
+		},
+	}
+	change := qmgo.Change{
+		Upsert:    true,
+		ReturnNew: true,
+		Update: bsoncodec.M{
+			"$set": bsoncodec.M{
+				"codeHash":     util.HashToken(code),
+				"expiredAt":    now.Add(getLoginCodeTTL()),
+				"attemptCount": 0,
+				"lastSentAt":   now,
+				"updatedAt":    now,
+			},
+		},
+	}
+	err := repository.Mongo.FindAndApply(ctx, C_LOGIN_CODE, condition, change, &LoginCode{})
+	// 冷却时间内条件不匹配，upsert 会因为 _id 重复而失败
+	if qmgo.IsDup(err) {
+		return "", ErrLoginCodeTooSoon
+	}
+	if err != nil {
+		return "", err
+	}
+	return code, nil
+}
+
+// Send 生成验证码并通过 QQ 私聊发送给用户
+func (*LoginCode) Send(ctx context.Context, userId string) error {
+	code, err := CLoginCode.Generate(ctx, userId)
+	if err != nil {
+		return err
+	}
+	message := gocq.NewPrivateMessage(userId, FormatLoginCodeMessage(code))
+	message.IsSensitive = true
+	return gocq.Enqueue(ctx, message)
+}
+
+// Request 处理未登录用户获取验证码的请求，同一个 IP 的请求次数受 auth.maxIpFailures 限制
+// 用户不存在时不发送也不返回错误，避免通过该接口探测用户
+func (*LoginCode) Request(ctx context.Context, userId, ip string) error {
+	key := "loginCode:" + GenIpThrottleKey(ip)
+	if err := CAuthThrottle.Check(ctx, key); err != nil {
+		return err
+	}
+	// 每次请求都计数
+	if err := CAuthThrottle.RecordFailure(ctx, key, GetMaxFailures(true)); err != nil {
+		return err
+	}
+	if _, err := CUser.GetByUserId(ctx, userId); err != nil {
+		if err == qmgo.ErrNoSuchDocuments {
+			return nil
+		}
+		return err
+	}
+	if err := CLoginCode.Send(ctx, userId); err != nil {
+		CAuthEvent.Record(ctx, AUTH_EVENT_LOGIN_CODE_REJECTED, userId, "", err.Error())
+		return err
+	}
+	CAuthEvent.Record(ctx, AUTH_EVENT_LOGIN_CODE_SENT, userId, "", "")
+	return nil
+}
+
+func FormatLoginCodeMessage(code string) string {
+	return fmt.Sprintf("登录验证码：%s，%d 分钟内有效。如果不是你本人的操作，请忽略这条消息。", code, int(getLoginCodeTTL().Minutes()))
+}
+
+// Verify 校验验证码，验证成功后验证码失效，超过尝试次数后需要重新获取
+func (*LoginCode) Verify(ctx context.Context, userId, code string) error {
+	now := time.Now()
+	condition := bsoncodec.M{
+		"_id": userId,
+		"expiredAt": bsoncodec.M{
+			"$gt": now,
+		},
+		"attemptCount": bsoncodec.M{
+			"$lt": maxLoginCodeAttempts,
+		},
+	}
+	change := qmgo.Change{
+		ReturnNew: true,
+		Update: bsoncodec.M{
+			"$inc": bsoncodec.M{
+				"attemptCount": 1,
+			},
+			"$set": bsoncodec.M{
+				"updatedAt": now,
+			},
+		},
+	}
+	loginCode := LoginCode{}
+	err := repository.Mongo.FindAndApply(ctx, C_LOGIN_CODE, condition, change, &loginCode)
+	if err == qmgo.ErrNoSuchDocuments {
+		return ErrInvalidLoginCode
+	}
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare([]byte(loginCode.CodeHash), []byte(util.HashToken(code))) != 1 {
+		return ErrInvalidLoginCode
+	}
+	return repository.Mongo.UpdateOne(ctx, C_LOGIN_CODE, bsoncodec.M{"_id": userId}, bsoncodec.M{
+		"$set": bsoncodec.M{
+			"expiredAt": now,
+			"updatedAt": now,
+		},
+	})
+}
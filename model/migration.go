@@ -0,0 +1,117 @@
+package model
+
+import (
+	"context"
+	"github.com/qiniu/qmgo"
+	"time"
+	"todo-reminder/gocq"
+	"todo-reminder/log"
+	"todo-reminder/repository"
+	"todo-reminder/repository/bsoncodec"
+)
+
+const (
+	C_MIGRATION = "migration"
+)
+
+var (
+	migrations []migration
+)
+
+type migration struct {
+	name string
+	fn   func(ctx context.Context) error
+}
+
+// Migration 已经执行过的数据迁移
+type Migration struct {
+	Name      string    `bson:"_id"`
+	CreatedAt time.Time `bson:"createdAt"`
+}
+
+func init() {
+	registerMigration("enableExistingUsers", enableExistingUsers)
+	registerMigration("expireOutboundMessages", expireOutboundMessages)
+	registerMigration("genRecordKeywords", genRecordKeywords)
+}
+
+// registerMigration 按注册顺序执行，每个迁移只执行一次，必须可以重复执行
+func registerMigration(name string, fn func(ctx context.Context) error) {
+	migrations = append(migrations, migration{
+		name: name,
+		fn:   fn,
+	})
+}
+
+// RunMigrations 启动时执行未执行过的迁移，多个实例同时启动时只有一个实例会执行
+func RunMigrations(ctx context.Context) {
+	for _, m := range migrations {
+		err := repository.Mongo.Insert(ctx, C_MIGRATION, Migration{
+			Name:      m.name,
+			CreatedAt: time.Now(),
+		})
+		if qmgo.IsDup(err) {
+			continue
+		}
+		if err == nil {
+			err = m.fn(ctx)
+			if err == nil {
+				continue
+			}
+			// 执行失败时删除记录，下次启动时重试
+			repository.Mongo.RemoveAll(ctx, C_MIGRATION, bsoncodec.M{"_id": m.name})
+		}
+		log.Error("Failed to run migration", map[string]interface{}{
+			"name":  m.name,
+			"error": err.Error(),
+		})
+		return
+	}
+}
+
+// enableExistingUsers 之前 isEnabled 表示是否登录过，现在表示是否被管理员禁用，已有的用户都视为启用
+func enableExistingUsers(ctx context.Context) error {
+	_, err := repository.Mongo.UpdateAll(ctx, C_USER, bsoncodec.M{
+		"isEnabled": bsoncodec.M{
+			"$ne": true,
+		},
+	}, bsoncodec.M{
+		"$set": bsoncodec.M{
+			"isEnabled": true,
+			"updatedAt": time.Now(),
+		},
+	})
+	return err
+}
+
+// expireOutboundMessages 之前发送完成的消息没有过期时间，从现在开始计算保留时间，并清空其中包含登录验证码的消息内容
+func expireOutboundMessages(ctx context.Context) error {
+	_, err := repository.Mongo.UpdateAll(ctx, gocq.C_OUTBOUND_MESSAGE, bsoncodec.M{
+		"status": bsoncodec.M{
+			"$in": []string{gocq.OUTBOUND_STATUS_SENT, gocq.OUTBOUND_STATUS_DEAD},
+		},
+		"expiredAt": bsoncodec.M{
+			"$exists": false,
+		},
+	}, bsoncodec.M{
+		"$set": bsoncodec.M{
+			"expiredAt": time.Now().AddDate(0, 0, gocq.GetRetentionDays()),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = repository.Mongo.UpdateAll(ctx, gocq.C_OUTBOUND_MESSAGE, bsoncodec.M{
+		"status": bsoncodec.M{
+			"$in": []string{gocq.OUTBOUND_STATUS_SENT, gocq.OUTBOUND_STATUS_DEAD},
+		},
+		"message": bsoncodec.M{
+			"$regex": "^(登录验证码|欢迎使用待办提醒)",
+		},
+	}, bsoncodec.M{
+		"$unset": bsoncodec.M{
+			"message": "",
+		},
+	})
+	return err
+}
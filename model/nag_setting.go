@@ -0,0 +1,88 @@
+package model
+
+import (
+	"errors"
+	"time"
+	"todo-reminder/util"
+)
+
+type NagSetting struct {
+	IsEnabled bool `json:"isEnabled" bson:"isEnabled"`
+	// 每隔多少分钟重复提醒一次
+	IntervalMinutes int `json:"intervalMinutes" bson:"intervalMinutes"`
+	// 最多重复提醒多少次
+	MaxTimes   int        `json:"maxTimes" bson:"maxTimes"`
+	QuietHours QuietHours `json:"quietHours" bson:"quietHours"`
+}
+
+// QuietHours 免打扰时段，格式为 HH:mm，start 大于 end 时表示跨天，如 22:00 到 08:00
+type QuietHours struct {
+	Start string `json:"start" bson:"start,omitempty"`
+	End   string `json:"end" bson:"end,omitempty"`
+}
+
+func (s NagSetting) Validate() error {
+	if !s.IsEnabled {
+		return nil
+	}
+	if s.IntervalMinutes < 1 {
+		return errors.New("invalid nag interval")
+	}
+	if s.MaxTimes < 1 {
+		return errors.New("invalid nag max times")
+	}
+	return s.QuietHours.Validate()
+}
+
+// GetNextNagAt 获取下一次重复提醒的时间，落在免打扰时段内时顺延到免打扰结束
+func (s NagSetting) GetNextNagAt(from time.Time) time.Time {
+	next := from.Add(time.Duration(s.IntervalMinutes) * time.Minute)
+	if s.QuietHours.Contains(next) {
+		return s.QuietHours.GetEndTime(next)
+	}
+	return next
+}
+
+func (q QuietHours) IsEmpty() bool {
+	return q.Start == "" || q.End == ""
+}
+
+func (q QuietHours) Validate() error {
+	if q.IsEmpty() {
+		return nil
+	}
+	if !util.IsValidClock(q.Start) || !util.IsValidClock(q.End) {
+		return errors.New("invalid quiet hours")
+	}
+	return nil
+}
+
+// Contains 按当天的分钟数比较，不能按字符串比较，否则 10:00 会小于 9:00
+func (q QuietHours) Contains(t time.Time) bool {
+	if q.IsEmpty() {
+		return false
+	}
+	start, err := util.ParseClock(q.Start)
+	if err != nil {
+		return false
+	}
+	end, err := util.ParseClock(q.End)
+	if err != nil {
+		return false
+	}
+	minute := util.GetMinuteOfDay(t)
+	if start <= end {
+		return minute >= start && minute < end
+	}
+	return minute >= start || minute < end
+}
+
+// GetEndTime 获取 t 所在免打扰时段的结束时间
+func (q QuietHours) GetEndTime(t time.Time) time.Time {
+	end, _ := util.ParseClock(q.End)
+	endTime := time.Date(t.Year(), t.Month(), t.Day(), end/60, end%60, 0, 0, t.Location())
+	if !endTime.After(t) {
+		endTime = endTime.AddDate(0, 0, 1)
+	}
+	return endTime
+}
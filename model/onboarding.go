@@ -0,0 +1,150 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"github.com/spf13/viper"
+	"strings"
+	"time"
+	"todo-reminder/gocq"
+	"todo-reminder/log"
+	"todo-reminder/repository"
+	"todo-reminder/repository/bsoncodec"
+	"todo-reminder/util"
+)
+
+const (
+	defaultMaxUnfriendedPerSync = 5
+)
+
+// CanAutoApproveFriend 开启 onboarding.autoApprove、在白名单中或者验证信息中包含邀请码时自动同意好友申请
+// 被管理员禁用的用户不会自动同意
+func CanAutoApproveFriend(ctx context.Context, userId, comment string) bool {
+	user, err := CUser.GetByUserId(ctx, userId)
+	if err == nil && !user.IsEnabled && user.DisabledReason != USER_DISABLED_BY_UNFRIENDED {
+		return false
+	}
+	if viper.GetBool("onboarding.autoApprove") {
+		return true
+	}
+	if util.StrInArray(userId, util.PtrValue(viper.GetStringSlice("onboarding.allowlist"))) {
+		return true
+	}
+	for _, code := range viper.GetStringSlice("onboarding.inviteCodes") {
+		code = strings.TrimSpace(code)
+		if code != "" && strings.Contains(comment, code) {
+			return true
+		}
+	}
+	return false
+}
+
+// Onboard 添加好友后创建用户，之前因为删除好友被禁用的用户重新启用
+func (*User) Onboard(ctx context.Context, userId string) (User, error) {
+	user := User{
+		UserId: userId,
+	}
+	if err := user.UpsertWithoutPassword(ctx); err != nil {
+		return user, err
+	}
+	if user.IsEnabled || user.DisabledReason != USER_DISABLED_BY_UNFRIENDED {
+		return user, nil
+	}
+	err := CUser.UpdateByUserId(ctx, userId, bsoncodec.M{
+		"$set": bsoncodec.M{
+			"isEnabled": true,
+			"updatedAt": time.Now(),
+		},
+		"$unset": bsoncodec.M{
+			"disabledReason": "",
+		},
+	})
+	if err != nil {
+		return user, err
+	}
+	user.IsEnabled = true
+	user.DisabledReason = ""
+	return user, nil
+}
+
+// SendWelcome 发送欢迎消息，包含一次性登录验证码和命令说明，验证码生成失败时只发送命令说明
+func (*User) SendWelcome(ctx context.Context, userId, dedupeKey string) error {
+	code, err := CLoginCode.Generate(ctx, userId)
+	if err != nil {
+		log.Warn("Failed to generate welcome login code", map[string]interface{}{
+			"userId": userId,
+			"error":  err.Error(),
+		})
+		code = ""
+	}
+	message := gocq.NewPrivateMessage(userId, FormatWelcomeMessage(code))
+	message.DedupeKey = dedupeKey
+	message.IsSensitive = code != ""
+	return gocq.Enqueue(ctx, message)
+}
+
+func FormatWelcomeMessage(code string) string {
+	lines := []string{"欢迎使用待办提醒！直接发送消息即可创建待办，如“明天早上九点开会”。"}
+	if code != "" {
+		lines = append(lines, fmt.Sprintf("网页端登录账号为你的 QQ 号，首次登录可以使用验证码：%s，%d 分钟内有效，登录后请设置密码。", code, int(getLoginCodeTTL().Minutes())))
+	} else {
+		lines = append(lines, "网页端登录账号为你的 QQ 号，可以在登录页获取验证码登录。")
+	}
+	lines = append(lines, gocq.GetCommandHelp())
+	return strings.Join(lines, "\n")
+}
+
+// DisableUnfriendedOnes 禁用不在好友列表中的用户，管理员、被管理员手动启用的用户和有可用 API key 的用户除外
+// 好友列表为空或者需要禁用的用户过多时认为好友列表异常，不做处理
+func (*User) DisableUnfriendedOnes(ctx context.Context, friendIds []string) error {
+	if len(friendIds) == 0 {
+		return nil
+	}
+	condition := bsoncodec.M{
+		"isDeleted":        false,
+		"isEnabled":        true,
+		"role":             bsoncodec.M{"$ne": ROLE_ADMIN},
+		"isEnabledByAdmin": bsoncodec.M{"$ne": true},
+		"userId": bsoncodec.M{
+			"$nin": friendIds,
+		},
+	}
+	var users []User
+	if err := repository.Mongo.FindAll(ctx, C_USER, condition, &users); err != nil {
+		return err
+	}
+	unfriended := make([]User, 0, len(users))
+	for _, user := range users {
+		if user.IsAdmin() {
+			continue
+		}
+		count, err := repository.Mongo.Count(ctx, C_API_KEY, genActiveApiKeysCondition(user.UserId))
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			unfriended = append(unfriended, user)
+		}
+	}
+	if limit := getMaxUnfriendedPerSync(); len(unfriended) > limit {
+		return fmt.Errorf("%d users are missing from the friend list, more than %d, skip disabling", len(unfriended), limit)
+	}
+	for _, user := range unfriended {
+		if err := CUser.Disable(ctx, user.UserId, USER_DISABLED_BY_UNFRIENDED); err != nil {
+			return err
+		}
+		log.Warn("Disabled unfriended user", map[string]interface{}{
+			"userId": user.UserId,
+		})
+	}
+	return nil
+}
+
+// getMaxUnfriendedPerSync 一次同步最多禁用的用户数量，超过时通常是获取的好友列表不完整
+func getMaxUnfriendedPerSync() int {
+	limit := viper.GetInt("onboarding.maxUnfriendedPerSync")
+	if limit <= 0 {
+		return defaultMaxUnfriendedPerSync
+	}
+	return limit
+}
@@ -0,0 +1,125 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"todo-reminder/repository"
+	"todo-reminder/repository/bsoncodec"
+	"todo-reminder/util"
+)
+
+const (
+	// 逾期汇总中最多列出的待办数量
+	maxOverdueDigestItemCount = 5
+)
+
+// OverdueDigest 每天在指定时间通过 QQ 发送一次逾期待办的汇总
+type OverdueDigest struct {
+	IsEnabled bool `json:"isEnabled" bson:"isEnabled"`
+	// 用户时区下的 HH:mm
+	Time string `json:"time" bson:"time"`
+}
+
+func (d OverdueDigest) Validate() error {
+	if !d.IsEnabled {
+		return nil
+	}
+	if !util.IsValidClock(d.Time) {
+		return errors.New("invalid overdue digest time")
+	}
+	return nil
+}
+
+// GetRecordDueAt 计算提醒时间为 remindAt 的记录的截止时间，设置了 DueOffset 时以提醒时间为准
+func (t *Todo) GetRecordDueAt(remindAt time.Time) time.Time {
+	if t.DueOffset > 0 && !remindAt.IsZero() {
+		return remindAt.Add(time.Duration(t.DueOffset) * time.Second)
+	}
+	return t.DueAt
+}
+
+// IsOverdueAt 记录在 now 时是否已经逾期
+func (t *TodoRecord) IsOverdueAt(now time.Time) bool {
+	return !t.HasBeenDone && !t.DueAt.IsZero() && t.DueAt.Before(now)
+}
+
+// MarkOverdueOnes 将 now 之前到期且未完成的记录标记为逾期，完成后保留逾期标记
+func (*TodoRecord) MarkOverdueOnes(ctx context.Context, now time.Time) (int64, error) {
+	condition := bsoncodec.M{
+		"isDeleted":   false,
+		"hasBeenDone": false,
+		"isOverdue":   false,
+		"dueAt": bsoncodec.M{
+			"$lte": now,
+		},
+	}
+	updater := bsoncodec.M{
+		"$set": bsoncodec.M{
+			"isOverdue": true,
+			"overdueAt": now,
+			"updatedAt": time.Now(),
+		},
+	}
+	return repository.Mongo.UpdateAll(ctx, C_TODO_RECORD, condition, updater)
+}
+
+// ListOverdueOnesByUserId 返回用户逾期未完成的记录总数和最早到期的几条
+func (*TodoRecord) ListOverdueOnesByUserId(ctx context.Context, userId string) (int64, []TodoRecord, error) {
+	condition := GenUserRecordsCondition(userId)
+	condition["isDeleted"] = false
+	condition["hasBeenDone"] = false
+	condition["isOverdue"] = true
+	return CTodoRecord.ListByPagination(ctx, condition, 1, maxOverdueDigestItemCount, []string{"dueAt"})
+}
+
+func (*User) ListOverdueDigestEnabledOnes(ctx context.Context) ([]User, error) {
+	condition := bsoncodec.M{
+		"isDeleted":               false,
+		"isEnabled":               true,
+		"overdueDigest.isEnabled": true,
+	}
+	var users []User
+	err := repository.Mongo.FindAll(ctx, C_USER, condition, &users)
+	return users, err
+}
+
+// GetOverdueDigestDate 到达用户设置的时间后返回用户时区下的当天日期，否则返回空
+func (u *User) GetOverdueDigestDate(now time.Time) string {
+	if !u.OverdueDigest.IsEnabled {
+		return ""
+	}
+	now = now.In(util.LoadLocation(u.Timezone))
+	// 按当天的分钟数比较，兼容之前保存的 9:00 这种小时只有一位的时刻
+	digestAt, err := util.ParseClock(u.OverdueDigest.Time)
+	if err != nil || util.GetMinuteOfDay(now) < digestAt {
+		return ""
+	}
+	date := now.Format("2006-01-02")
+	if date == u.LastOverdueDigestDate {
+		return ""
+	}
+	return date
+}
+
+func (u *User) MarkOverdueDigestSent(ctx context.Context, date string) error {
+	return CUser.UpdateByUserId(ctx, u.UserId, bsoncodec.M{
+		"$set": bsoncodec.M{
+			"lastOverdueDigestDate": date,
+			"updatedAt":             time.Now(),
+		},
+	})
+}
+
+func FormatOverdueDigestMessage(total int64, records []TodoRecord) string {
+	lines := []string{fmt.Sprintf("你有 %d 个待办已逾期：", total)}
+	for _, record := range records {
+		lines = append(lines, fmt.Sprintf("· %s（截止 %s）", record.Content, record.DueAt.In(util.LoadLocation(record.Timezone)).Format("01-02 15:04")))
+	}
+	if total > int64(len(records)) {
+		lines = append(lines, fmt.Sprintf("等 %d 个", total))
+	}
+	return strings.Join(lines, "\n")
+}
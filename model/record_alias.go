@@ -0,0 +1,73 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"github.com/qiniu/qmgo"
+	"github.com/qiniu/qmgo/options"
+	mgo_option "go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+	"todo-reminder/repository"
+	"todo-reminder/repository/bsoncodec"
+	"todo-reminder/util"
+)
+
+const (
+	C_RECORD_ALIAS = "recordAlias"
+)
+
+var (
+	CRecordAlias = &RecordAlias{}
+
+	ErrInvalidAlias = errors.New("invalid alias")
+)
+
+func init() {
+	repository.Mongo.CreateIndex(context.Background(), C_RECORD_ALIAS, options.IndexModel{
+		Key: []string{"userId"},
+		IndexOptions: &mgo_option.IndexOptions{
+			Background: util.PtrValue[bool](true),
+			Unique:     util.PtrValue[bool](true),
+		},
+	})
+}
+
+// RecordAlias 用户最近一次在 QQ 中查看的待办列表，序号 n 对应 recordIds 中的第 n 个
+type RecordAlias struct {
+	Id        bsoncodec.ObjectId   `json:"id" bson:"_id"`
+	UserId    string               `json:"userId" bson:"userId"`
+	RecordIds []bsoncodec.ObjectId `json:"recordIds" bson:"recordIds"`
+	UpdatedAt time.Time            `json:"updatedAt" bson:"updatedAt"`
+}
+
+func (*RecordAlias) Save(ctx context.Context, userId string, recordIds []bsoncodec.ObjectId) error {
+	condition := bsoncodec.M{
+		"userId": userId,
+	}
+	change := qmgo.Change{
+		Upsert:    true,
+		ReturnNew: true,
+		Update: bsoncodec.M{
+			"$set": bsoncodec.M{
+				"recordIds": recordIds,
+				"updatedAt": time.Now(),
+			},
+		},
+	}
+	return repository.Mongo.FindAndApply(ctx, C_RECORD_ALIAS, condition, change, &RecordAlias{})
+}
+
+// GetRecordId 获取序号对应的待办记录，序号从 1 开始
+func (*RecordAlias) GetRecordId(ctx context.Context, userId string, alias int) (bsoncodec.ObjectId, error) {
+	condition := bsoncodec.M{
+		"userId": userId,
+	}
+	a := RecordAlias{}
+	if err := repository.Mongo.FindOne(ctx, C_RECORD_ALIAS, condition, &a); err != nil {
+		return "", err
+	}
+	if alias < 1 || alias > len(a.RecordIds) {
+		return "", ErrInvalidAlias
+	}
+	return a.RecordIds[alias-1], nil
+}
@@ -0,0 +1,141 @@
+package model
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"todo-reminder/repository"
+	"todo-reminder/repository/bsoncodec"
+	"unicode"
+)
+
+const (
+	genKeywordsBatchSize = 500
+)
+
+// GenContentKeywords 中文没有空格分词，文本索引无法匹配其中的片段，
+// 因此保存每段连续汉字的单字和相邻两个字，搜索时按关键词的二元组匹配
+func GenContentKeywords(content string) []string {
+	var keywords []string
+	seen := map[string]bool{}
+	for _, run := range splitHanRuns(content) {
+		for i := range run {
+			grams := []string{string(run[i])}
+			if i+1 < len(run) {
+				grams = append(grams, string(run[i:i+2]))
+			}
+			for _, gram := range grams {
+				if !seen[gram] {
+					seen[gram] = true
+					keywords = append(keywords, gram)
+				}
+			}
+		}
+	}
+	return keywords
+}
+
+// genKeywordGrams 搜索关键词中汉字的二元组，只有一个汉字时使用单字
+func genKeywordGrams(keyword string) []string {
+	var grams []string
+	for _, run := range splitHanRuns(keyword) {
+		if len(run) == 1 {
+			grams = append(grams, string(run))
+			continue
+		}
+		for i := 0; i+1 < len(run); i++ {
+			grams = append(grams, string(run[i:i+2]))
+		}
+	}
+	return grams
+}
+
+func splitHanRuns(s string) [][]rune {
+	var runs [][]rune
+	var run []rune
+	for _, r := range s {
+		if unicode.Is(unicode.Han, r) {
+			run = append(run, r)
+			continue
+		}
+		if len(run) > 0 {
+			runs = append(runs, run)
+			run = nil
+		}
+	}
+	if len(run) > 0 {
+		runs = append(runs, run)
+	}
+	return runs
+}
+
+// GenKeywordCondition 匹配任意一个关键词，不含汉字的关键词使用文本索引，
+// 含汉字的关键词先通过 keywords 索引筛选，再用正则排除二元组不连续的记录
+func GenKeywordCondition(keyword string) bsoncodec.M {
+	var words []string
+	var clauses []bsoncodec.M
+	for _, word := range strings.Fields(keyword) {
+		grams := genKeywordGrams(word)
+		if len(grams) == 0 {
+			words = append(words, word)
+			continue
+		}
+		clauses = append(clauses, bsoncodec.M{
+			"keywords": bsoncodec.M{
+				"$all": grams,
+			},
+			"content": bsoncodec.M{
+				"$regex":   regexp.QuoteMeta(word),
+				"$options": "i",
+			},
+		})
+	}
+	if len(words) > 0 {
+		clauses = append(clauses, bsoncodec.M{
+			"$text": bsoncodec.M{
+				"$search": strings.Join(words, " "),
+			},
+		})
+	}
+	switch len(clauses) {
+	case 0:
+		return nil
+	case 1:
+		return clauses[0]
+	}
+	return bsoncodec.M{
+		"$or": clauses,
+	}
+}
+
+// genRecordKeywords 为已有的包含汉字的记录生成 keywords
+func genRecordKeywords(ctx context.Context) error {
+	condition := bsoncodec.M{
+		"keywords": bsoncodec.M{
+			"$exists": false,
+		},
+		"content": bsoncodec.M{
+			"$regex": `\p{Han}`,
+		},
+	}
+	for {
+		var records []TodoRecord
+		_, err := repository.Mongo.FindAllWithPage(ctx, C_TODO_RECORD, []string{"_id"}, 1, genKeywordsBatchSize, condition, &records)
+		if err != nil {
+			return err
+		}
+		for _, record := range records {
+			_, err := repository.Mongo.UpdateAll(ctx, C_TODO_RECORD, bsoncodec.M{"_id": record.Id}, bsoncodec.M{
+				"$set": bsoncodec.M{
+					"keywords": GenContentKeywords(record.Content),
+				},
+			})
+			if err != nil {
+				return err
+			}
+		}
+		if len(records) < genKeywordsBatchSize {
+			return nil
+		}
+	}
+}
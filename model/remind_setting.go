@@ -2,6 +2,10 @@ package model
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"github.com/teambition/rrule-go"
+	"strings"
 	"time"
 )
 
@@ -12,6 +16,11 @@ const (
 	REPEAT_TYPE_YEARLY      = "yearly"
 	REPEAT_TYPE_WORKING_DAY = "workingDay"
 	REPEAT_TYPE_HOLIDAY     = "holiday"
+	REPEAT_TYPE_RRULE       = "rrule"
+)
+
+var (
+	ErrNoMoreOccurrence = errors.New("no more occurrence")
 )
 
 type RemindSetting struct {
@@ -19,86 +28,148 @@ type RemindSetting struct {
 	LastRemindAt  time.Time     `json:"lastRemindAt" bson:"lastRemindAt"`
 	IsRepeatable  bool          `json:"isRepeatable" bson:"isRepeatable"`
 	RepeatSetting RepeatSetting `json:"repeatSetting" bson:"repeatSetting"`
+	// 每次提醒提前的秒数，如 86400、3600、0 表示提前一天、提前一小时和到点各提醒一次，为空时只在到点提醒
+	RemindOffsets []int64 `json:"remindOffsets" bson:"remindOffsets,omitempty"`
+	// 服务停止期间错过的提醒的补发策略，为空时补发所有
+	CatchUpPolicy string `json:"catchUpPolicy" bson:"catchUpPolicy,omitempty"`
 }
 
 type RepeatSetting struct {
 	Type       string `json:"type" bson:"type"`
 	DateOffset int    `json:"dateOffset" bson:"dateOffset"`
+	// RFC 5545 格式的规则，如 FREQ=WEEKLY;BYDAY=MO,WE,FR，可以附带 EXDATE 行，仅 type 为 rrule 时生效
+	RRule   string      `json:"rrule" bson:"rrule,omitempty"`
+	ExDates []time.Time `json:"exDates" bson:"exDates,omitempty"`
 }
 
-type dateOffset struct {
-	year  int
-	month int
-	week  int
-	day   int
+// ToRRule 将重复设置转换为 RRULE，daily、weekly、monthly、yearly 都是 RRULE 的简写
+func (s RepeatSetting) ToRRule() string {
+	interval := s.DateOffset
+	if interval < 1 {
+		interval = 1
+	}
+	switch s.Type {
+	case REPEAT_TYPE_DAILY:
+		return fmt.Sprintf("FREQ=DAILY;INTERVAL=%d", interval)
+	case REPEAT_TYPE_WEEKLY:
+		return fmt.Sprintf("FREQ=WEEKLY;INTERVAL=%d", interval)
+	case REPEAT_TYPE_MONTHLY:
+		return fmt.Sprintf("FREQ=MONTHLY;INTERVAL=%d", interval)
+	case REPEAT_TYPE_YEARLY:
+		return fmt.Sprintf("FREQ=YEARLY;INTERVAL=%d", interval)
+	case REPEAT_TYPE_RRULE:
+		return s.RRule
+	}
+	return ""
+}
+
+func (r *RemindSetting) Validate() error {
+	if !IsValidCatchUpPolicy(r.CatchUpPolicy) {
+		return errors.New("invalid catch up policy")
+	}
+	for _, offset := range r.RemindOffsets {
+		if offset < 0 {
+			return errors.New("invalid remind offset")
+		}
+	}
+	if !r.IsRepeatable {
+		return nil
+	}
+	switch r.RepeatSetting.Type {
+	case REPEAT_TYPE_WORKING_DAY, REPEAT_TYPE_HOLIDAY:
+		return nil
+	}
+	_, err := r.getRRuleSet()
+	return err
 }
 
-func (r *RemindSetting) GetNextRemindAt(ctx context.Context) time.Time {
+// GetNextRemindAt 在 loc 时区下计算下一次提醒时间，保证夏令时切换前后提醒的本地时间不变
+func (r *RemindSetting) GetNextRemindAt(ctx context.Context, loc *time.Location) (time.Time, error) {
 	if !r.IsRepeatable {
-		return r.RemindAt
+		return r.RemindAt, nil
 	}
-	now := time.Now()
+	r.RemindAt = r.RemindAt.In(loc)
+	r.LastRemindAt = r.LastRemindAt.In(loc)
 	var (
-		offset     dateOffset
-		targetDate time.Time
+		nextRemindAt time.Time
+		err          error
 	)
 	switch r.RepeatSetting.Type {
-	case REPEAT_TYPE_DAILY:
-		offset.day = r.RepeatSetting.DateOffset
-	case REPEAT_TYPE_WEEKLY:
-		offset.week = r.RepeatSetting.DateOffset
-	case REPEAT_TYPE_MONTHLY:
-		offset.month = r.RepeatSetting.DateOffset
-	case REPEAT_TYPE_YEARLY:
-		offset.year = r.RepeatSetting.DateOffset
+	case REPEAT_TYPE_HOLIDAY, REPEAT_TYPE_WORKING_DAY:
+		nextRemindAt, err = r.getNextChinaHolidayRemindAt(ctx)
+	default:
+		nextRemindAt, err = r.getNextRRuleRemindAt()
 	}
-	if r.RepeatSetting.Type == REPEAT_TYPE_HOLIDAY {
-		temp := r.LastRemindAt
-		// 第一次提醒
-		if r.LastRemindAt.Unix() < 0 {
-			temp = r.RemindAt
-			if now.After(r.RemindAt) {
-				temp = r.RemindAt.AddDate(0, 0, 1)
-			}
-		} else {
-			temp = temp.AddDate(0, 0, 1)
-		}
-		nextRemindAt, err := CChinaHoliday.GetNextHoliday(ctx, temp)
-		if err != nil {
-			return time.Time{}
-		}
-		r.LastRemindAt = nextRemindAt
-		targetDate = nextRemindAt
-	} else if r.RepeatSetting.Type == REPEAT_TYPE_WORKING_DAY {
-		temp := r.LastRemindAt
-		// 第一次提醒
-		if r.LastRemindAt.Unix() < 0 {
-			temp = r.RemindAt
-			if now.After(r.RemindAt) {
-				temp = r.RemindAt.AddDate(0, 0, 1)
-			}
-		} else {
-			temp = temp.AddDate(0, 0, 1)
-		}
-		nextRemindAt, err := CChinaHoliday.GetNextWorkingDay(ctx, temp)
-		if err != nil {
-			return time.Time{}
+	if err != nil {
+		return time.Time{}, err
+	}
+	r.LastRemindAt = nextRemindAt
+	return nextRemindAt, nil
+}
+
+func (r *RemindSetting) getNextChinaHolidayRemindAt(ctx context.Context) (time.Time, error) {
+	temp := r.LastRemindAt
+	// 第一次提醒
+	if r.LastRemindAt.Unix() < 0 {
+		temp = r.RemindAt
+		if time.Now().After(r.RemindAt) {
+			temp = r.RemindAt.AddDate(0, 0, 1)
 		}
-		r.LastRemindAt = nextRemindAt
-		targetDate = nextRemindAt
 	} else {
-		targetDate := r.LastRemindAt
-		// 从未提醒过
-		if r.LastRemindAt.Unix() < 0 {
-			targetDate = r.RemindAt
-			if now.After(r.RemindAt) {
-				targetDate = r.RemindAt.AddDate(offset.year, offset.month, offset.week*7+offset.day)
-			}
-		} else {
-			targetDate = r.LastRemindAt.AddDate(offset.year, offset.month, offset.week*7+offset.day)
+		temp = temp.AddDate(0, 0, 1)
+	}
+	if r.RepeatSetting.Type == REPEAT_TYPE_HOLIDAY {
+		return CChinaHoliday.GetNextHoliday(ctx, temp)
+	}
+	return CChinaHoliday.GetNextWorkingDay(ctx, temp)
+}
+
+func (r *RemindSetting) getNextRRuleRemindAt() (time.Time, error) {
+	set, err := r.getRRuleSet()
+	if err != nil {
+		return time.Time{}, err
+	}
+	var nextRemindAt time.Time
+	// 从未提醒过
+	if r.LastRemindAt.Unix() < 0 {
+		nextRemindAt = set.After(time.Now(), true)
+	} else {
+		nextRemindAt = set.After(r.LastRemindAt, false)
+	}
+	// 超过 COUNT 或 UNTIL 后不再有下一次
+	if nextRemindAt.IsZero() {
+		return time.Time{}, ErrNoMoreOccurrence
+	}
+	return nextRemindAt, nil
+}
+
+func (r *RemindSetting) getRRuleSet() (*rrule.Set, error) {
+	rule := r.RepeatSetting.ToRRule()
+	if rule == "" {
+		return nil, errors.New("invalid repeat type")
+	}
+	var lines []string
+	for _, line := range strings.Split(rule, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		// 没有属性名的行视为 RRULE
+		if !strings.Contains(line, ":") {
+			line = "RRULE:" + line
 		}
-		r.LastRemindAt = targetDate
-		return targetDate
+		lines = append(lines, line)
+	}
+	set, err := rrule.StrSliceToRRuleSetInLoc(lines, r.RemindAt.Location())
+	if err != nil {
+		return nil, err
+	}
+	if set.GetRRule() == nil {
+		return nil, errors.New("missing RRULE")
+	}
+	set.DTStart(r.RemindAt)
+	for _, exDate := range r.RepeatSetting.ExDates {
+		set.ExDate(exDate)
 	}
-	return targetDate
+	return set, nil
 }
@@ -0,0 +1,215 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"github.com/qiniu/qmgo"
+	"github.com/qiniu/qmgo/options"
+	"github.com/spf13/viper"
+	mgo_option "go.mongodb.org/mongo-driver/mongo/options"
+	"strings"
+	"time"
+	"todo-reminder/repository"
+	"todo-reminder/repository/bsoncodec"
+	"todo-reminder/util"
+)
+
+const (
+	C_SESSION = "session"
+
+	defaultRefreshTokenValidDays = 30
+)
+
+var (
+	CSession = &Session{}
+
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrSessionRevoked      = errors.New("session has been revoked")
+)
+
+func init() {
+	repository.Mongo.CreateIndex(context.Background(), C_SESSION, options.IndexModel{
+		Key: []string{"userId", "isRevoked"},
+		IndexOptions: &mgo_option.IndexOptions{
+			Background: util.PtrValue[bool](true),
+		},
+	})
+	// 过期的会话由 MongoDB 自动删除
+	repository.Mongo.CreateIndex(context.Background(), C_SESSION, options.IndexModel{
+		Key: []string{"expiredAt"},
+		IndexOptions: &mgo_option.IndexOptions{
+			Background:         util.PtrValue[bool](true),
+			ExpireAfterSeconds: util.PtrValue[int32](0),
+		},
+	})
+}
+
+// Session 每次登录创建一个会话，会话持有 refresh token，access token 通过 sid 关联到会话
+type Session struct {
+	Id        bsoncodec.ObjectId `bson:"_id"`
+	UserId    string             `bson:"userId"`
+	CreatedAt time.Time          `bson:"createdAt"`
+	UpdatedAt time.Time          `bson:"updatedAt"`
+	ExpiredAt time.Time          `bson:"expiredAt"`
+	// 只保存当前 refresh token 的摘要，每次刷新后更换
+	RefreshTokenHash string    `bson:"refreshTokenHash"`
+	LastRefreshedAt  time.Time `bson:"lastRefreshedAt,omitempty"`
+	IsRevoked        bool      `bson:"isRevoked"`
+	RevokedAt        time.Time `bson:"revokedAt,omitempty"`
+	UserAgent        string    `bson:"userAgent,omitempty"`
+	Ip               string    `bson:"ip,omitempty"`
+}
+
+type TokenPair struct {
+	SessionId    string
+	AccessToken  string
+	RefreshToken string
+	// access token 的过期时间
+	ExpiredAt time.Time
+}
+
+func getRefreshTokenTTL() time.Duration {
+	days := viper.GetInt("token.refreshTokenValidDays")
+	if days <= 0 {
+		days = defaultRefreshTokenValidDays
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// refresh token 的格式为 会话 id.随机串
+func genRefreshToken(id bsoncodec.ObjectId) (string, string) {
+	secret := util.GenSecureToken(32)
+	return id.Hex() + "." + secret, util.HashToken(secret)
+}
+
+func parseRefreshToken(refreshToken string) (bsoncodec.ObjectId, string, error) {
+	parts := strings.SplitN(refreshToken, ".", 2)
+	if len(parts) != 2 || !bsoncodec.IsObjectIdHex(parts[0]) || parts[1] == "" {
+		return "", "", ErrInvalidRefreshToken
+	}
+	return bsoncodec.ObjectIdHex(parts[0]), util.HashToken(parts[1]), nil
+}
+
+func (*Session) Create(ctx context.Context, userId, userAgent, ip string) (TokenPair, error) {
+	s := Session{
+		Id:        bsoncodec.NewObjectId(),
+		UserId:    userId,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+		ExpiredAt: time.Now().Add(getRefreshTokenTTL()),
+		UserAgent: userAgent,
+		Ip:        ip,
+	}
+	refreshToken, hash := genRefreshToken(s.Id)
+	s.RefreshTokenHash = hash
+	if err := repository.Mongo.Insert(ctx, C_SESSION, s); err != nil {
+		return TokenPair{}, err
+	}
+	return s.genTokenPair(refreshToken)
+}
+
+func (s *Session) genTokenPair(refreshToken string) (TokenPair, error) {
+	accessToken, expiredAt, err := util.GenerateToken(s.UserId, s.Id.Hex())
+	if err != nil {
+		return TokenPair{}, err
+	}
+	return TokenPair{
+		SessionId:    s.Id.Hex(),
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiredAt:    expiredAt,
+	}, nil
+}
+
+// Refresh 使用 refresh token 换取新的 token，旧的 refresh token 随即失效
+// 已经使用过的 refresh token 再次出现说明可能已经泄露，注销整个会话
+// 返回的 userId 用于记录审计日志，会话不存在时为空
+func (*Session) Refresh(ctx context.Context, refreshToken string) (TokenPair, string, error) {
+	id, hash, err := parseRefreshToken(refreshToken)
+	if err != nil {
+		return TokenPair{}, "", err
+	}
+	now := time.Now()
+	newRefreshToken, newHash := genRefreshToken(id)
+	condition := bsoncodec.M{
+		"_id":              id,
+		"isRevoked":        false,
+		"refreshTokenHash": hash,
+		"expiredAt": bsoncodec.M{
+			"$gt": now,
+		},
+	}
+	change := qmgo.Change{
+		ReturnNew: true,
+		Update: bsoncodec.M{
+			"$set": bsoncodec.M{
+				"refreshTokenHash": newHash,
+				"lastRefreshedAt":  now,
+				"expiredAt":        now.Add(getRefreshTokenTTL()),
+				"updatedAt":        now,
+			},
+		},
+	}
+	s := Session{}
+	err = repository.Mongo.FindAndApply(ctx, C_SESSION, condition, change, &s)
+	if err == qmgo.ErrNoSuchDocuments {
+		CSession.Revoke(ctx, id)
+		repository.Mongo.FindOne(ctx, C_SESSION, bsoncodec.M{"_id": id}, &s)
+		return TokenPair{}, s.UserId, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return TokenPair{}, "", err
+	}
+	pair, err := s.genTokenPair(newRefreshToken)
+	return pair, s.UserId, err
+}
+
+// CheckActive 会话未注销且未过期时返回 nil
+func (*Session) CheckActive(ctx context.Context, id string) error {
+	if !bsoncodec.IsObjectIdHex(id) {
+		return ErrSessionRevoked
+	}
+	s := Session{}
+	err := repository.Mongo.FindOne(ctx, C_SESSION, bsoncodec.M{"_id": bsoncodec.ObjectIdHex(id)}, &s)
+	if err != nil {
+		return err
+	}
+	if s.IsRevoked || !s.ExpiredAt.After(time.Now()) {
+		return ErrSessionRevoked
+	}
+	return nil
+}
+
+func (*Session) Revoke(ctx context.Context, id bsoncodec.ObjectId) error {
+	condition := bsoncodec.M{
+		"_id":       id,
+		"isRevoked": false,
+	}
+	_, err := repository.Mongo.UpdateAll(ctx, C_SESSION, condition, genRevokeSessionUpdater())
+	return err
+}
+
+// RevokeAllByUserId 注销用户的所有会话，exceptId 不为空时保留该会话
+func (*Session) RevokeAllByUserId(ctx context.Context, userId string, exceptId bsoncodec.ObjectId) error {
+	condition := bsoncodec.M{
+		"userId":    userId,
+		"isRevoked": false,
+	}
+	if exceptId != "" {
+		condition["_id"] = bsoncodec.M{
+			"$ne": exceptId,
+		}
+	}
+	_, err := repository.Mongo.UpdateAll(ctx, C_SESSION, condition, genRevokeSessionUpdater())
+	return err
+}
+
+func genRevokeSessionUpdater() bsoncodec.M {
+	return bsoncodec.M{
+		"$set": bsoncodec.M{
+			"isRevoked": true,
+			"revokedAt": time.Now(),
+			"updatedAt": time.Now(),
+		},
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/qiniu/qmgo/options"
 	mgo_option "go.mongodb.org/mongo-driver/mongo/options"
 	"time"
+	"todo-reminder/log"
 	"todo-reminder/repository"
 	"todo-reminder/repository/bsoncodec"
 	"todo-reminder/util"
@@ -14,6 +15,11 @@ import (
 
 const (
 	C_TODO = "todo"
+
+	PRIORITY_NONE   = 0
+	PRIORITY_LOW    = 1
+	PRIORITY_MEDIUM = 2
+	PRIORITY_HIGH   = 3
 )
 
 var (
@@ -33,6 +39,28 @@ func init() {
 			Background: util.PtrValue[bool](true),
 		},
 	})
+	repository.Mongo.CreateIndex(context.Background(), C_TODO, options.IndexModel{
+		Key: []string{"isDeleted", "userId", "projectId"},
+		IndexOptions: &mgo_option.IndexOptions{
+			Background: util.PtrValue[bool](true),
+		},
+	})
+	repository.Mongo.CreateIndex(context.Background(), C_TODO, options.IndexModel{
+		Key: []string{"isDeleted", "userId", "tags"},
+		IndexOptions: &mgo_option.IndexOptions{
+			Background: util.PtrValue[bool](true),
+		},
+	})
+	repository.Mongo.CreateIndex(context.Background(), C_TODO, options.IndexModel{
+		Key: []string{"isDeleted", "dependsOn"},
+		IndexOptions: &mgo_option.IndexOptions{
+			Background: util.PtrValue[bool](true),
+		},
+	})
+}
+
+func IsValidPriority(priority int) bool {
+	return priority >= PRIORITY_NONE && priority <= PRIORITY_HIGH
 }
 
 type Todo struct {
@@ -45,6 +73,29 @@ type Todo struct {
 	UserId        string             `json:"userId" bson:"userId"`
 	RemindSetting RemindSetting      `json:"remindSetting" bson:"remindSetting"`
 	Images        []string           `json:"images" bson:"images,omitempty"`
+	NagSetting    NagSetting         `json:"nagSetting" bson:"nagSetting"`
+	// IANA 时区，为空时使用用户的时区
+	Timezone string `json:"timezone" bson:"timezone,omitempty"`
+	// 不为空时提醒发送到该 QQ 群，UserId 为待办的创建者
+	GroupId string `json:"groupId" bson:"groupId,omitempty"`
+	// 需要完成该待办的群成员，提醒时会 @ 还未完成的成员
+	Assignees []string             `json:"assignees" bson:"assignees,omitempty"`
+	ProjectId bsoncodec.ObjectId   `json:"projectId" bson:"projectId,omitempty"`
+	Tags      []bsoncodec.ObjectId `json:"tags" bson:"tags,omitempty"`
+	Priority  int                  `json:"priority" bson:"priority"`
+	// #RRGGBB 格式，可以为空
+	Color     string          `json:"color" bson:"color,omitempty"`
+	Checklist []ChecklistItem `json:"checklist" bson:"checklist,omitempty"`
+	// 所有子任务完成后自动完成记录
+	AutoCompleteChecklist bool `json:"autoCompleteChecklist" bson:"autoCompleteChecklist"`
+	// 前置待办，所有前置待办都完成后才会生成第一条记录
+	DependsOn []bsoncodec.ObjectId `json:"dependsOn" bson:"dependsOn,omitempty"`
+	// 前置待办都完成后开始的时间，用于避免多个前置待办同时完成时重复生成记录
+	StartedAt time.Time `json:"startedAt" bson:"startedAt,omitempty"`
+	// 截止时间，与提醒时间无关，为空时不会逾期
+	DueAt time.Time `json:"dueAt" bson:"dueAt,omitempty"`
+	// 每条记录在提醒时间之后多少秒截止，大于 0 时优先于 DueAt，用于重复待办
+	DueOffset int64 `json:"dueOffset" bson:"dueOffset"`
 }
 
 func (t *Todo) Create(ctx context.Context) error {
@@ -92,7 +143,17 @@ func (*Todo) DeleteById(ctx context.Context, id bsoncodec.ObjectId) error {
 	if err != nil {
 		return err
 	}
-	return CTodoRecord.DeleteByTodoId(ctx, id)
+	if err := CTodoRecord.DeleteByTodoId(ctx, id); err != nil {
+		return err
+	}
+	// 删除的前置待办视为已完成，依赖它的待办可能可以开始了
+	if err := CTodo.StartDependents(ctx, id); err != nil {
+		log.Warn("Failed to start dependents of deleted todo", map[string]interface{}{
+			"todoId": id.Hex(),
+			"error":  err.Error(),
+		})
+	}
+	return nil
 }
 
 func (*Todo) ListByCondition(ctx context.Context, condition bsoncodec.M) ([]Todo, error) {
@@ -105,23 +166,52 @@ func (t *Todo) Upsert(ctx context.Context) error {
 	condition := bsoncodec.M{
 		"_id": t.Id,
 	}
+	setter := bsoncodec.M{
+		"updatedAt":             time.Now(),
+		"needRemind":            t.NeedRemind,
+		"content":               t.Content,
+		"remindSetting":         t.RemindSetting,
+		"images":                t.Images,
+		"nagSetting":            t.NagSetting,
+		"timezone":              t.Timezone,
+		"groupId":               t.GroupId,
+		"assignees":             t.Assignees,
+		"tags":                  t.Tags,
+		"priority":              t.Priority,
+		"color":                 t.Color,
+		"checklist":             t.Checklist,
+		"autoCompleteChecklist": t.AutoCompleteChecklist,
+		"dependsOn":             t.DependsOn,
+		"dueOffset":             t.DueOffset,
+	}
+	updater := bsoncodec.M{
+		"$set": setter,
+		// 修改时不能改变创建者
+		"$setOnInsert": bsoncodec.M{
+			"userId":    t.UserId,
+			"isDeleted": false,
+			"createdAt": time.Now(),
+		},
+	}
+	unsetter := bsoncodec.M{}
+	// 空的 ObjectId 无法编码，不属于任何清单时删除该字段
+	if t.ProjectId != "" {
+		setter["projectId"] = t.ProjectId
+	} else {
+		unsetter["projectId"] = ""
+	}
+	if !t.DueAt.IsZero() {
+		setter["dueAt"] = t.DueAt
+	} else {
+		unsetter["dueAt"] = ""
+	}
+	if len(unsetter) > 0 {
+		updater["$unset"] = unsetter
+	}
 	change := qmgo.Change{
 		Upsert:    true,
 		ReturnNew: true,
-		Update: bsoncodec.M{
-			"$set": bsoncodec.M{
-				"updatedAt":     time.Now(),
-				"needRemind":    t.NeedRemind,
-				"content":       t.Content,
-				"userId":        t.UserId,
-				"remindSetting": t.RemindSetting,
-				"images":        t.Images,
-			},
-			"$setOnInsert": bsoncodec.M{
-				"isDeleted": false,
-				"createdAt": time.Now(),
-			},
-		},
+		Update:    updater,
 	}
 
 	err := repository.Mongo.FindAndApply(ctx, C_TODO, condition, change, t)
@@ -147,6 +237,10 @@ func (*Todo) GenNextRecord(ctx context.Context, id bsoncodec.ObjectId, isFirst b
 	if (!t.NeedRemind || !t.RemindSetting.IsRepeatable) && !isFirst {
 		return nil
 	}
+	// 前置待办未完成时不生成记录，前置待办完成后由 StartDependents 生成
+	if unblocked, err := t.IsUnblocked(ctx); err != nil || !unblocked {
+		return err
+	}
 	if total, err := CTodoRecord.CountNotDoneRecordsByTodoId(ctx, id); err == nil {
 		if total >= 1 {
 			return nil
@@ -155,19 +249,37 @@ func (*Todo) GenNextRecord(ctx context.Context, id bsoncodec.ObjectId, isFirst b
 		return err
 	}
 	r := TodoRecord{
-		UserId:     t.UserId,
-		NeedRemind: t.NeedRemind,
-		Content:    t.Content,
-		TodoId:     t.Id,
-		Images:     t.Images,
+		UserId:                t.UserId,
+		NeedRemind:            t.NeedRemind,
+		Content:               t.Content,
+		TodoId:                t.Id,
+		Images:                t.Images,
+		NagSetting:            t.NagSetting,
+		Timezone:              t.GetTimezone(ctx),
+		GroupId:               t.GroupId,
+		Assignees:             GenAssignees(t.Assignees),
+		CatchUpPolicy:         t.RemindSetting.CatchUpPolicy,
+		ProjectId:             t.ProjectId,
+		Tags:                  t.Tags,
+		Priority:              t.Priority,
+		Color:                 t.Color,
+		Checklist:             GenChecklist(t.Checklist),
+		AutoCompleteChecklist: t.AutoCompleteChecklist,
 	}
 	if t.NeedRemind && t.RemindSetting.IsRepeatable {
 		r.IsRepeatable = true
 		r.RepeatType = t.RemindSetting.RepeatSetting.Type
 		r.RepeatDateOffset = t.RemindSetting.RepeatSetting.DateOffset
+		r.RepeatRRule = t.RemindSetting.RepeatSetting.RRule
 	}
 	if t.NeedRemind {
-		remindAt := t.RemindSetting.GetNextRemindAt(ctx)
+		remindAt, err := t.RemindSetting.GetNextRemindAt(ctx, util.LoadLocation(r.Timezone))
+		if err == ErrNoMoreOccurrence {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
 		if remindAt.Unix() < 0 {
 			return errors.New("failed to gen remind at")
 		}
@@ -181,10 +293,48 @@ func (*Todo) GenNextRecord(ctx context.Context, id bsoncodec.ObjectId, isFirst b
 			return err
 		}
 		r.RemindAt = remindAt
+		r.Reminders = GenReminders(remindAt, t.RemindSetting.RemindOffsets)
 	}
+	r.DueAt = t.GetRecordDueAt(r.RemindAt)
 	return r.Create(ctx)
 }
 
+// GetTimezone 获取计算提醒时间使用的时区，优先使用 todo 上设置的时区
+func (t *Todo) GetTimezone(ctx context.Context) string {
+	if t.Timezone != "" {
+		return t.Timezone
+	}
+	user, err := CUser.GetByUserId(ctx, t.UserId)
+	if err != nil {
+		return ""
+	}
+	return user.Timezone
+}
+
+func (*Todo) ListByPagination(ctx context.Context, condition bsoncodec.M, page, perPage int64, orderBy []string) (int64, []Todo, error) {
+	var todos []Todo
+	total, err := repository.Mongo.FindAllWithPage(ctx, C_TODO, orderBy, page, perPage, condition, &todos)
+	return total, todos, err
+}
+
+// GenUserTodosCondition 用户创建的或者被指派的待办
+func GenUserTodosCondition(userId string) bsoncodec.M {
+	return bsoncodec.M{
+		"$or": []bsoncodec.M{
+			{"userId": userId},
+			{"assignees": userId},
+		},
+	}
+}
+
+func (t *Todo) IsOwner(userId string) bool {
+	return t.UserId == userId
+}
+
+func (t *Todo) IsVisibleTo(userId string) bool {
+	return t.UserId == userId || util.StrInArray(userId, &t.Assignees)
+}
+
 func (*Todo) ListByIds(ctx context.Context, ids []bsoncodec.ObjectId) ([]Todo, error) {
 	condition := bsoncodec.M{
 		"_id": bsoncodec.M{
@@ -0,0 +1,149 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+	"todo-reminder/log"
+	"todo-reminder/notifier"
+	"todo-reminder/repository"
+	"todo-reminder/repository/bsoncodec"
+)
+
+var (
+	ErrDependencyCycle = errors.New("dependency cycle")
+)
+
+// ValidateDependencies 前置待办必须存在且属于同一个用户，并且不能形成循环依赖
+func (t *Todo) ValidateDependencies(ctx context.Context) error {
+	if len(t.DependsOn) == 0 {
+		return nil
+	}
+	todos, err := CTodo.ListByIds(ctx, t.DependsOn)
+	if err != nil {
+		return err
+	}
+	found := map[bsoncodec.ObjectId]bool{}
+	for _, todo := range todos {
+		if !todo.IsDeleted && todo.UserId == t.UserId {
+			found[todo.Id] = true
+		}
+	}
+	for _, id := range t.DependsOn {
+		if id == t.Id {
+			return ErrDependencyCycle
+		}
+		if !found[id] {
+			return fmt.Errorf("dependency %s not found", id.Hex())
+		}
+	}
+	// 从前置待办出发沿着依赖关系广度优先搜索，能回到自身说明存在循环
+	visited := map[bsoncodec.ObjectId]bool{}
+	queue := todos
+	for len(queue) > 0 {
+		var next []bsoncodec.ObjectId
+		for _, todo := range queue {
+			for _, id := range todo.DependsOn {
+				if id == t.Id {
+					return ErrDependencyCycle
+				}
+				if !visited[id] {
+					visited[id] = true
+					next = append(next, id)
+				}
+			}
+		}
+		if len(next) == 0 {
+			break
+		}
+		if queue, err = CTodo.ListByIds(ctx, next); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IsUnblocked 所有前置待办都至少完成过一次，已经删除的前置待办视为已完成
+func (t *Todo) IsUnblocked(ctx context.Context) (bool, error) {
+	if len(t.DependsOn) == 0 {
+		return true, nil
+	}
+	todos, err := CTodo.ListByIds(ctx, t.DependsOn)
+	if err != nil {
+		return false, err
+	}
+	for _, todo := range todos {
+		if todo.IsDeleted {
+			continue
+		}
+		done, err := CTodoRecord.HasDoneRecord(ctx, todo.Id)
+		if err != nil {
+			return false, err
+		}
+		if !done {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// StartDependents 前置待办完成后，为所有前置待办都已完成且还未开始的待办生成第一条记录并通知创建者
+func (*Todo) StartDependents(ctx context.Context, id bsoncodec.ObjectId) error {
+	condition := bsoncodec.M{
+		"isDeleted": false,
+		"dependsOn": id,
+	}
+	var todos []Todo
+	if err := repository.Mongo.FindAll(ctx, C_TODO, condition, &todos); err != nil {
+		return err
+	}
+	for _, todo := range todos {
+		if err := todo.start(ctx); err != nil {
+			log.Warn("Failed to start dependent todo", map[string]interface{}{
+				"todoId":       todo.Id.Hex(),
+				"dependencyId": id.Hex(),
+				"error":        err.Error(),
+			})
+		}
+	}
+	return nil
+}
+
+func (t *Todo) start(ctx context.Context) error {
+	unblocked, err := t.IsUnblocked(ctx)
+	if err != nil || !unblocked {
+		return err
+	}
+	count, err := CTodoRecord.CountByTodoId(ctx, t.Id)
+	if err != nil || count > 0 {
+		return err
+	}
+	// 多个前置待办同时完成时只有一个能修改成功
+	modified, err := repository.Mongo.UpdateAll(ctx, C_TODO, bsoncodec.M{
+		"_id":       t.Id,
+		"startedAt": bsoncodec.M{"$exists": false},
+	}, bsoncodec.M{
+		"$set": bsoncodec.M{
+			"startedAt": time.Now(),
+		},
+	})
+	if err != nil || modified == 0 {
+		return err
+	}
+	if err := t.GenNextRecord(ctx, t.Id, true); err != nil {
+		// 生成失败时允许下次重试
+		repository.Mongo.UpdateAll(ctx, C_TODO, bsoncodec.M{"_id": t.Id}, bsoncodec.M{
+			"$unset": bsoncodec.M{
+				"startedAt": "",
+			},
+		})
+		return err
+	}
+	message := notifier.Message{
+		Title:   "待办可以开始了",
+		Content: fmt.Sprintf("前置待办都已完成，可以开始：%s", t.Content),
+	}
+	_, err = notifier.SendWithFallback(ctx, CUser.GetNotifyChannelsByUserId(ctx, t.UserId), message)
+	return err
+}
@@ -2,11 +2,16 @@ package model
 
 import (
 	"context"
+	"fmt"
 	"github.com/qiniu/qmgo"
 	"github.com/qiniu/qmgo/options"
+	"github.com/spf13/cast"
 	mgo_option "go.mongodb.org/mongo-driver/mongo/options"
+	"sort"
 	"time"
 	"todo-reminder/gocq"
+	"todo-reminder/log"
+	"todo-reminder/notifier"
 	"todo-reminder/repository"
 	"todo-reminder/repository/bsoncodec"
 	"todo-reminder/util"
@@ -14,13 +19,22 @@ import (
 
 const (
 	C_TODO_RECORD = "todoRecord"
+
+	// 每条记录最多保留的提醒消息 id 数量
+	maxMessageIdCount = 20
 )
 
 var (
 	CTodoRecord = &TodoRecord{}
+
+	recordChangeHooks []RecordChangeHook
 )
 
+// RecordChangeHook 记录创建或提醒时间、完成状态发生变化后调用
+type RecordChangeHook func(ctx context.Context, id bsoncodec.ObjectId)
+
 func init() {
+	gocq.RegisterOutboundCallback(C_TODO_RECORD, onOutboundMessageFinished)
 	repository.Mongo.CreateIndex(context.Background(), C_TODO_RECORD, options.IndexModel{
 		Key: []string{"isDeleted", "todoId", "hasBeenDone"},
 		IndexOptions: &mgo_option.IndexOptions{
@@ -39,6 +53,84 @@ func init() {
 			Background: util.PtrValue[bool](true),
 		},
 	})
+	repository.Mongo.CreateIndex(context.Background(), C_TODO_RECORD, options.IndexModel{
+		Key: []string{"isDeleted", "needRemind", "hasBeenDone", "hasBeenReminded", "reminders.remindAt"},
+		IndexOptions: &mgo_option.IndexOptions{
+			Background: util.PtrValue[bool](true),
+		},
+	})
+	repository.Mongo.CreateIndex(context.Background(), C_TODO_RECORD, options.IndexModel{
+		Key: []string{"isDeleted", "hasBeenDone", "nextNagAt"},
+		IndexOptions: &mgo_option.IndexOptions{
+			Background: util.PtrValue[bool](true),
+		},
+	})
+	repository.Mongo.CreateIndex(context.Background(), C_TODO_RECORD, options.IndexModel{
+		Key: []string{"isDeleted", "hasBeenDone", "assignees.userId", "remindAt"},
+		IndexOptions: &mgo_option.IndexOptions{
+			Background: util.PtrValue[bool](true),
+		},
+	})
+	repository.Mongo.CreateIndex(context.Background(), C_TODO_RECORD, options.IndexModel{
+		Key: []string{"messageIds"},
+		IndexOptions: &mgo_option.IndexOptions{
+			Background: util.PtrValue[bool](true),
+		},
+	})
+	// 按完成时间搜索
+	repository.Mongo.CreateIndex(context.Background(), C_TODO_RECORD, options.IndexModel{
+		Key: []string{"isDeleted", "hasBeenDone", "userId", "doneAt"},
+		IndexOptions: &mgo_option.IndexOptions{
+			Background: util.PtrValue[bool](true),
+		},
+	})
+	repository.Mongo.CreateIndex(context.Background(), C_TODO_RECORD, options.IndexModel{
+		Key: []string{"isDeleted", "hasBeenDone", "assignees.userId", "doneAt"},
+		IndexOptions: &mgo_option.IndexOptions{
+			Background: util.PtrValue[bool](true),
+		},
+	})
+	repository.Mongo.CreateIndex(context.Background(), C_TODO_RECORD, options.IndexModel{
+		Key: []string{"isDeleted", "hasBeenDone", "userId", "isRepeatable", "remindAt"},
+		IndexOptions: &mgo_option.IndexOptions{
+			Background: util.PtrValue[bool](true),
+		},
+	})
+	repository.Mongo.CreateIndex(context.Background(), C_TODO_RECORD, options.IndexModel{
+		Key: []string{"isDeleted", "hasBeenDone", "userId", "projectId", "remindAt"},
+		IndexOptions: &mgo_option.IndexOptions{
+			Background: util.PtrValue[bool](true),
+		},
+	})
+	repository.Mongo.CreateIndex(context.Background(), C_TODO_RECORD, options.IndexModel{
+		Key: []string{"isDeleted", "hasBeenDone", "userId", "tags", "remindAt"},
+		IndexOptions: &mgo_option.IndexOptions{
+			Background: util.PtrValue[bool](true),
+		},
+	})
+	repository.Mongo.CreateIndex(context.Background(), C_TODO_RECORD, options.IndexModel{
+		Key: []string{"isDeleted", "hasBeenDone", "isOverdue", "dueAt"},
+		IndexOptions: &mgo_option.IndexOptions{
+			Background: util.PtrValue[bool](true),
+		},
+	})
+	repository.Mongo.CreateIndex(context.Background(), C_TODO_RECORD, options.IndexModel{
+		Key: []string{"isDeleted", "hasBeenDone", "userId", "isOverdue", "dueAt"},
+		IndexOptions: &mgo_option.IndexOptions{
+			Background: util.PtrValue[bool](true),
+		},
+	})
+	// 文本索引按空格分词，用于搜索英文和数字，中文片段通过 keywords 搜索
+	repository.Mongo.CreateTextIndex(context.Background(), C_TODO_RECORD, []string{"content"}, &mgo_option.IndexOptions{
+		Background:      util.PtrValue[bool](true),
+		DefaultLanguage: util.PtrValue[string]("none"),
+	})
+	repository.Mongo.CreateIndex(context.Background(), C_TODO_RECORD, options.IndexModel{
+		Key: []string{"keywords"},
+		IndexOptions: &mgo_option.IndexOptions{
+			Background: util.PtrValue[bool](true),
+		},
+	})
 }
 
 type TodoRecord struct {
@@ -57,7 +149,80 @@ type TodoRecord struct {
 	IsRepeatable     bool               `bson:"isRepeatable"`
 	RepeatType       string             `bson:"repeatType"`
 	RepeatDateOffset int                `bson:"repeatDateOffset"`
+	RepeatRRule      string             `bson:"repeatRRule,omitempty"`
 	Images           []string           `bson:"images,omitempty"`
+	Reminders        []Reminder         `bson:"reminders,omitempty"`
+	NagSetting       NagSetting         `bson:"nagSetting"`
+	NagCount         int                `bson:"nagCount"`
+	NextNagAt        time.Time          `bson:"nextNagAt,omitempty"`
+	Timezone         string             `bson:"timezone,omitempty"`
+	// 通过 QQ 发送的提醒消息的 id，用于匹配用户对提醒的回复
+	MessageIds []string   `bson:"messageIds,omitempty"`
+	GroupId    string     `bson:"groupId,omitempty"`
+	Assignees  []Assignee `bson:"assignees,omitempty"`
+	// 同 RemindSetting.CatchUpPolicy
+	CatchUpPolicy string               `bson:"catchUpPolicy,omitempty"`
+	ProjectId     bsoncodec.ObjectId   `bson:"projectId,omitempty"`
+	Tags          []bsoncodec.ObjectId `bson:"tags,omitempty"`
+	Priority      int                  `bson:"priority"`
+	Color         string               `bson:"color,omitempty"`
+	Checklist     []ChecklistItem      `bson:"checklist,omitempty"`
+	// 同 Todo.AutoCompleteChecklist
+	AutoCompleteChecklist bool      `bson:"autoCompleteChecklist"`
+	DueAt                 time.Time `bson:"dueAt,omitempty"`
+	// 由定时任务在到期后标记，完成后保留，用于统计逾期完成的记录
+	IsOverdue bool      `bson:"isOverdue"`
+	OverdueAt time.Time `bson:"overdueAt,omitempty"`
+	// 内容中汉字的单字和二元组，用于搜索中文片段
+	Keywords []string `bson:"keywords,omitempty"`
+}
+
+// Assignee 群待办中每个成员的完成情况
+type Assignee struct {
+	UserId      string    `bson:"userId"`
+	HasBeenDone bool      `bson:"hasBeenDone"`
+	DoneAt      time.Time `bson:"doneAt,omitempty"`
+}
+
+type Reminder struct {
+	// 提前多少秒提醒，0 表示在 remindAt 提醒
+	Offset          int64     `bson:"offset"`
+	RemindAt        time.Time `bson:"remindAt"`
+	HasBeenReminded bool      `bson:"hasBeenReminded"`
+	RemindedAt      time.Time `bson:"remindedAt,omitempty"`
+}
+
+func GenReminders(remindAt time.Time, offsets []int64) []Reminder {
+	if len(offsets) == 0 {
+		offsets = []int64{0}
+	}
+	now := time.Now()
+	reminders := make([]Reminder, 0, len(offsets))
+	for _, offset := range util.Unique(offsets) {
+		reminder := Reminder{
+			Offset:   offset,
+			RemindAt: remindAt.Add(-time.Duration(offset) * time.Second),
+		}
+		// 创建时已经错过的提前提醒直接跳过
+		if offset > 0 && reminder.RemindAt.Before(now) {
+			reminder.HasBeenReminded = true
+		}
+		reminders = append(reminders, reminder)
+	}
+	sort.SliceStable(reminders, func(i, j int) bool {
+		return reminders[i].RemindAt.Before(reminders[j].RemindAt)
+	})
+	return reminders
+}
+
+func RegisterRecordChangeHook(hook RecordChangeHook) {
+	recordChangeHooks = append(recordChangeHooks, hook)
+}
+
+func onRecordChanged(ctx context.Context, id bsoncodec.ObjectId) {
+	for _, hook := range recordChangeHooks {
+		hook(ctx, id)
+	}
 }
 
 func (t *TodoRecord) Create(ctx context.Context) error {
@@ -65,7 +230,12 @@ func (t *TodoRecord) Create(ctx context.Context) error {
 	t.IsDeleted = false
 	t.CreatedAt = time.Now()
 	t.UpdatedAt = time.Now()
-	return repository.Mongo.Insert(ctx, C_TODO_RECORD, t)
+	t.Keywords = GenContentKeywords(t.Content)
+	if err := repository.Mongo.Insert(ctx, C_TODO_RECORD, t); err != nil {
+		return err
+	}
+	onRecordChanged(ctx, t.Id)
+	return nil
 }
 
 func (*TodoRecord) DeleteByTodoId(ctx context.Context, todoId bsoncodec.ObjectId) error {
@@ -80,13 +250,40 @@ func (*TodoRecord) DeleteByTodoId(ctx context.Context, todoId bsoncodec.ObjectId
 			"isDeleted": true,
 		},
 	}
-	_, err := repository.Mongo.UpdateAll(ctx, C_TODO_RECORD, condition, updater)
-	return err
+	return updateAllAndNotify(ctx, condition, updater)
+}
+
+// updateAllAndNotify 批量修改记录，并对每条被修改的记录触发 onRecordChanged
+func updateAllAndNotify(ctx context.Context, condition, updater bsoncodec.M) error {
+	var records []TodoRecord
+	if err := repository.Mongo.FindAll(ctx, C_TODO_RECORD, condition, &records); err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		return nil
+	}
+	ids := make([]bsoncodec.ObjectId, 0, len(records))
+	for _, record := range records {
+		ids = append(ids, record.Id)
+	}
+	// 只修改查询到的记录，查询之后新增的记录不受影响
+	condition["_id"] = bsoncodec.M{
+		"$in": ids,
+	}
+	if _, err := repository.Mongo.UpdateAll(ctx, C_TODO_RECORD, condition, updater); err != nil {
+		return err
+	}
+	for _, id := range ids {
+		onRecordChanged(ctx, id)
+	}
+	return nil
 }
 
+// Done 完成整条记录，只有第一次完成时生成下一条记录，并发调用时不会重复生成
 func (*TodoRecord) Done(ctx context.Context, id bsoncodec.ObjectId) error {
 	condition := bsoncodec.M{
-		"_id": id,
+		"_id":         id,
+		"hasBeenDone": false,
 	}
 	updater := bsoncodec.M{
 		"$set": bsoncodec.M{
@@ -101,11 +298,18 @@ func (*TodoRecord) Done(ctx context.Context, id bsoncodec.ObjectId) error {
 	}
 	r := TodoRecord{}
 	err := repository.Mongo.FindAndApply(ctx, C_TODO_RECORD, condition, change, &r)
+	if err == qmgo.ErrNoSuchDocuments {
+		// 已经完成的记录不需要再处理
+		_, err = CTodoRecord.GetById(ctx, id)
+		return err
+	}
 	if err != nil {
 		return err
 	}
+	onRecordChanged(ctx, id)
 	go func() {
 		CTodo.GenNextRecord(ctx, r.TodoId, false)
+		CTodo.StartDependents(ctx, r.TodoId)
 	}()
 	return nil
 }
@@ -122,7 +326,11 @@ func (*TodoRecord) Undo(ctx context.Context, id bsoncodec.ObjectId) error {
 			"doneAt": "",
 		},
 	}
-	return repository.Mongo.UpdateOne(ctx, C_TODO_RECORD, condition, updater)
+	if err := repository.Mongo.UpdateOne(ctx, C_TODO_RECORD, condition, updater); err != nil {
+		return err
+	}
+	onRecordChanged(ctx, id)
+	return nil
 }
 
 func (*TodoRecord) Delete(ctx context.Context, id bsoncodec.ObjectId) error {
@@ -134,7 +342,11 @@ func (*TodoRecord) Delete(ctx context.Context, id bsoncodec.ObjectId) error {
 			"isDeleted": true,
 		},
 	}
-	return repository.Mongo.UpdateOne(ctx, C_TODO_RECORD, condition, updater)
+	if err := repository.Mongo.UpdateOne(ctx, C_TODO_RECORD, condition, updater); err != nil {
+		return err
+	}
+	onRecordChanged(ctx, id)
+	return nil
 }
 
 func (*TodoRecord) Delay(ctx context.Context, id bsoncodec.ObjectId, delayDuration time.Duration) error {
@@ -147,13 +359,32 @@ func (*TodoRecord) Delay(ctx context.Context, id bsoncodec.ObjectId, delayDurati
 		return err
 	}
 	r.RemindAt = r.RemindAt.Add(delayDuration)
+	reminders := r.GetReminders()
+	for i := range reminders {
+		reminders[i].RemindAt = reminders[i].RemindAt.Add(delayDuration)
+		// 推迟到未来的提醒需要重新发送
+		if reminders[i].RemindAt.After(time.Now()) {
+			reminders[i].HasBeenReminded = false
+		}
+	}
+	r.Reminders = reminders
 	updater := bsoncodec.M{
 		"$set": bsoncodec.M{
-			"remindAt":  r.RemindAt,
-			"updatedAt": time.Now(),
+			"remindAt":        r.RemindAt,
+			"reminders":       r.Reminders,
+			"hasBeenReminded": r.isAllReminded(),
+			"updatedAt":       time.Now(),
+		},
+		// 推迟后重新提醒时再开始催促
+		"$unset": bsoncodec.M{
+			"nextNagAt": "",
 		},
 	}
-	return repository.Mongo.UpdateOne(ctx, C_TODO_RECORD, condition, updater)
+	if err := repository.Mongo.UpdateOne(ctx, C_TODO_RECORD, condition, updater); err != nil {
+		return err
+	}
+	onRecordChanged(ctx, id)
+	return nil
 }
 
 func (*TodoRecord) UpdateById(ctx context.Context, id bsoncodec.ObjectId, updater bsoncodec.M) error {
@@ -164,14 +395,37 @@ func (*TodoRecord) UpdateById(ctx context.Context, id bsoncodec.ObjectId, update
 }
 
 func (*TodoRecord) ListNeedRemindOnes(ctx context.Context) ([]TodoRecord, error) {
+	return CTodoRecord.ListNeedRemindOnesBefore(ctx, time.Now())
+}
+
+// ListNeedRemindOnesBefore 获取 before 之前有未发送提醒的记录
+func (*TodoRecord) ListNeedRemindOnesBefore(ctx context.Context, before time.Time) ([]TodoRecord, error) {
 	condition := bsoncodec.M{
-		"isDeleted": false,
-		"remindAt": bsoncodec.M{
-			"$lte": time.Now(),
-		},
+		"isDeleted":       false,
 		"needRemind":      true,
 		"hasBeenDone":     false,
 		"hasBeenReminded": false,
+		"$or": []bsoncodec.M{
+			{
+				"reminders": bsoncodec.M{
+					"$elemMatch": bsoncodec.M{
+						"remindAt": bsoncodec.M{
+							"$lte": before,
+						},
+						"hasBeenReminded": false,
+					},
+				},
+			},
+			// 兼容没有 reminders 的旧记录
+			{
+				"reminders": bsoncodec.M{
+					"$exists": false,
+				},
+				"remindAt": bsoncodec.M{
+					"$lte": before,
+				},
+			},
+		},
 	}
 	var records []TodoRecord
 	err := repository.Mongo.FindAll(ctx, C_TODO_RECORD, condition, &records)
@@ -181,36 +435,265 @@ func (*TodoRecord) ListNeedRemindOnes(ctx context.Context) ([]TodoRecord, error)
 	return records, nil
 }
 
-func (*TodoRecord) MarkAsReminded(ctx context.Context, ids []bsoncodec.ObjectId) error {
+func (t *TodoRecord) GetReminders() []Reminder {
+	if len(t.Reminders) > 0 {
+		return t.Reminders
+	}
+	return []Reminder{
+		{
+			Offset:          0,
+			RemindAt:        t.RemindAt,
+			HasBeenReminded: t.HasBeenReminded,
+		},
+	}
+}
+
+func (t *TodoRecord) GetDueReminders(now time.Time) []Reminder {
+	var reminders []Reminder
+	for _, reminder := range t.GetReminders() {
+		if !reminder.HasBeenReminded && !reminder.RemindAt.After(now) {
+			reminders = append(reminders, reminder)
+		}
+	}
+	return reminders
+}
+
+// GetNextReminderAt 获取最早的未发送提醒的时间，记录已完成、已删除或不需要提醒时返回 false
+func (t *TodoRecord) GetNextReminderAt() (time.Time, bool) {
+	if t.IsDeleted || t.HasBeenDone || !t.NeedRemind {
+		return time.Time{}, false
+	}
+	var (
+		next  time.Time
+		found bool
+	)
+	for _, reminder := range t.GetReminders() {
+		if reminder.HasBeenReminded {
+			continue
+		}
+		if !found || reminder.RemindAt.Before(next) {
+			next = reminder.RemindAt
+			found = true
+		}
+	}
+	return next, found
+}
+
+func (t *TodoRecord) isAllReminded() bool {
+	for _, reminder := range t.GetReminders() {
+		if !reminder.HasBeenReminded {
+			return false
+		}
+	}
+	return true
+}
+
+func (t *TodoRecord) MarkReminderAsReminded(ctx context.Context, offset int64) error {
+	reminders := t.GetReminders()
+	for i := range reminders {
+		if reminders[i].Offset == offset {
+			reminders[i].HasBeenReminded = true
+			reminders[i].RemindedAt = time.Now()
+		}
+	}
+	t.Reminders = reminders
+	t.HasBeenReminded = t.isAllReminded()
+	setter := bsoncodec.M{
+		"reminders":       t.Reminders,
+		"hasBeenReminded": t.HasBeenReminded,
+	}
+	// 最后一次提醒发出后开始催促
+	if t.HasBeenReminded && t.NagSetting.IsEnabled && t.NagCount < t.NagSetting.MaxTimes {
+		t.NextNagAt = t.NagSetting.GetNextNagAt(t.Now())
+		setter["nextNagAt"] = t.NextNagAt
+	}
+	return t.UpdateById(ctx, t.Id, bsoncodec.M{
+		"$set": setter,
+	})
+}
+
+func (*TodoRecord) ListNeedNagOnes(ctx context.Context) ([]TodoRecord, error) {
 	condition := bsoncodec.M{
-		"_id": bsoncodec.M{
-			"$in": ids,
+		"isDeleted":   false,
+		"hasBeenDone": false,
+		"nextNagAt": bsoncodec.M{
+			"$lte": time.Now(),
+		},
+	}
+	var records []TodoRecord
+	err := repository.Mongo.FindAll(ctx, C_TODO_RECORD, condition, &records)
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// MarkAsNagged 记录一次催促并安排下一次，达到次数上限后不再催促
+func (t *TodoRecord) MarkAsNagged(ctx context.Context) error {
+	t.NagCount++
+	setter := bsoncodec.M{
+		"nagCount": t.NagCount,
+	}
+	updater := bsoncodec.M{
+		"$set": setter,
+	}
+	if t.NagSetting.IsEnabled && t.NagCount < t.NagSetting.MaxTimes {
+		t.NextNagAt = t.NagSetting.GetNextNagAt(t.Now())
+		setter["nextNagAt"] = t.NextNagAt
+	} else {
+		updater["$unset"] = bsoncodec.M{
+			"nextNagAt": "",
+		}
+	}
+	return t.UpdateById(ctx, t.Id, updater)
+}
+
+func (t *TodoRecord) StopNagging(ctx context.Context) error {
+	updater := bsoncodec.M{
+		"$unset": bsoncodec.M{
+			"nextNagAt": "",
 		},
 	}
+	return t.UpdateById(ctx, t.Id, updater)
+}
+
+// PostponeNag 免打扰时段内不催促，顺延到免打扰结束
+func (t *TodoRecord) PostponeNag(ctx context.Context, nextNagAt time.Time) error {
+	t.NextNagAt = nextNagAt
 	updater := bsoncodec.M{
 		"$set": bsoncodec.M{
-			"hasBeenReminded": true,
+			"nextNagAt": t.NextNagAt,
 		},
 	}
-	_, err := repository.Mongo.UpdateAll(ctx, C_TODO_RECORD, condition, updater)
-	return err
+	return t.UpdateById(ctx, t.Id, updater)
 }
 
-func (t *TodoRecord) Notify(ctx context.Context) error {
-	err := gocq.GetGocqInstance().SendPrivateStringMessage(ctx, t.Content, t.UserId)
-	if err != nil {
-		return err
-	}
+// Notify 发送提醒，QQ 消息写入发送队列后即返回，dedupeKey 用于避免同一次提醒重复入队
+func (t *TodoRecord) Notify(ctx context.Context, content, dedupeKey string) error {
+	images := make([]notifier.Image, 0, len(t.Images))
 	for _, image := range t.Images {
 		url, err := util.MinioClient.SignObjectUrl(ctx, image)
 		if err == nil {
-			err = gocq.GetGocqInstance().SendPrivateImageMessage(ctx, t.UserId, image, url)
-			if err != nil {
-				return err
-			}
+			images = append(images, notifier.Image{
+				Name: image,
+				Url:  url,
+			})
 		}
 	}
-	return nil
+	message := notifier.Message{
+		Title:     "待办提醒",
+		Content:   content,
+		Images:    images,
+		DedupeKey: dedupeKey,
+		Callback:  C_TODO_RECORD,
+		CallbackData: map[string]string{
+			"recordId": t.Id.Hex(),
+		},
+	}
+	channels := CUser.GetNotifyChannelsByUserId(ctx, t.UserId)
+	// 群待办发送到群里，失败时通知创建者
+	if t.GroupId != "" {
+		message.Mentions = t.GetUndoneAssigneeIds()
+		channels = append([]notifier.Channel{
+			{
+				Type:   notifier.CHANNEL_QQ_GROUP,
+				Target: t.GroupId,
+			},
+		}, channels...)
+	}
+	_, err := notifier.SendWithFallback(ctx, channels, message)
+	return err
+}
+
+// onOutboundMessageFinished QQ 提醒送达后记录消息 id，多次重试仍失败时改用用户配置的其他渠道
+func onOutboundMessageFinished(ctx context.Context, m gocq.OutboundMessage, delivered bool) {
+	recordId := m.CallbackData["recordId"]
+	if !bsoncodec.IsObjectIdHex(recordId) {
+		return
+	}
+	record, err := CTodoRecord.GetById(ctx, bsoncodec.ObjectIdHex(recordId))
+	if err != nil {
+		return
+	}
+	if delivered {
+		if err := record.AddMessageId(ctx, cast.ToString(m.MessageId)); err != nil {
+			log.Warn("Failed to save reminder message id", map[string]interface{}{
+				"recordId":  record.Id.Hex(),
+				"messageId": m.MessageId,
+				"error":     err.Error(),
+			})
+		}
+		return
+	}
+	var channels []notifier.Channel
+	for _, channel := range CUser.GetNotifyChannelsByUserId(ctx, record.UserId) {
+		if !util.StrInArray(channel.Type, &[]string{notifier.CHANNEL_QQ, notifier.CHANNEL_QQ_GROUP}) {
+			channels = append(channels, channel)
+		}
+	}
+	if len(channels) == 0 {
+		return
+	}
+	message := notifier.Message{
+		Title:   "待办提醒",
+		Content: m.Message,
+	}
+	for _, image := range m.Images {
+		message.Images = append(message.Images, notifier.Image{
+			Name: image.Name,
+			Url:  image.Url,
+		})
+	}
+	if _, err := notifier.SendWithFallback(ctx, channels, message); err != nil {
+		log.Warn("Failed to send reminder by fallback channels", map[string]interface{}{
+			"recordId": record.Id.Hex(),
+			"error":    err.Error(),
+		})
+	}
+}
+
+func (t *TodoRecord) AddMessageId(ctx context.Context, messageId string) error {
+	updater := bsoncodec.M{
+		"$push": bsoncodec.M{
+			"messageIds": bsoncodec.M{
+				"$each":  []string{messageId},
+				"$slice": -maxMessageIdCount,
+			},
+		},
+	}
+	return t.UpdateById(ctx, t.Id, updater)
+}
+
+// GetByMessageId 根据提醒消息的 id 获取用户创建或被指派的记录
+func (*TodoRecord) GetByMessageId(ctx context.Context, userId, messageId string) (TodoRecord, error) {
+	condition := GenUserRecordsCondition(userId)
+	condition["messageIds"] = messageId
+	condition["isDeleted"] = false
+	r := TodoRecord{}
+	err := repository.Mongo.FindOne(ctx, C_TODO_RECORD, condition, &r)
+	return r, err
+}
+
+// Now 获取记录所在时区的当前时间
+func (t *TodoRecord) Now() time.Time {
+	return time.Now().In(util.LoadLocation(t.Timezone))
+}
+
+func (t *TodoRecord) FormatReminderMessage(reminder Reminder) string {
+	message := t.Content
+	if reminder.Offset > 0 {
+		message = fmt.Sprintf("【%s后】%s", util.FormatDuration(time.Duration(reminder.Offset)*time.Second), message)
+	}
+	message += t.formatChecklistProgress()
+	// 服务恢复后补发的提醒注明原定时间
+	if reminder.IsLate(time.Now()) {
+		message = fmt.Sprintf("【迟到的提醒，原定 %s】%s", reminder.RemindAt.In(util.LoadLocation(t.Timezone)).Format("01-02 15:04"), message)
+	}
+	return message
+}
+
+func (t *TodoRecord) FormatNagMessage() string {
+	return fmt.Sprintf("【第%d次提醒，还没完成】%s%s", t.NagCount+2, t.Content, t.formatChecklistProgress())
 }
 
 func (*TodoRecord) ListByPagination(ctx context.Context, condition bsoncodec.M, page, perPage int64, orderBy []string) (int64, []TodoRecord, error) {
@@ -230,6 +713,7 @@ func (*TodoRecord) GetById(ctx context.Context, id bsoncodec.ObjectId) (TodoReco
 
 func (*TodoRecord) DeleteUndoneOnesByTodoId(ctx context.Context, todoId bsoncodec.ObjectId) error {
 	condition := bsoncodec.M{
+		"isDeleted":   false,
 		"todoId":      todoId,
 		"hasBeenDone": false,
 	}
@@ -238,12 +722,42 @@ func (*TodoRecord) DeleteUndoneOnesByTodoId(ctx context.Context, todoId bsoncode
 			"isDeleted": true,
 		},
 	}
-	if _, err := repository.Mongo.UpdateAll(ctx, C_TODO_RECORD, condition, updater); err != nil && err != qmgo.ErrNoSuchDocuments {
+	if err := updateAllAndNotify(ctx, condition, updater); err != nil && err != qmgo.ErrNoSuchDocuments {
 		return err
 	}
 	return nil
 }
 
+// GetNextByTodoId 获取待办下一条未完成的记录
+func (*TodoRecord) GetNextByTodoId(ctx context.Context, todoId bsoncodec.ObjectId) (TodoRecord, error) {
+	condition := bsoncodec.M{
+		"isDeleted":   false,
+		"todoId":      todoId,
+		"hasBeenDone": false,
+	}
+	r := TodoRecord{}
+	err := repository.Mongo.FindOneWithSorter(ctx, C_TODO_RECORD, []string{"remindAt"}, condition, &r)
+	return r, err
+}
+
+func (*TodoRecord) CountByTodoId(ctx context.Context, todoId bsoncodec.ObjectId) (int64, error) {
+	condition := bsoncodec.M{
+		"isDeleted": false,
+		"todoId":    todoId,
+	}
+	return repository.Mongo.Count(ctx, C_TODO_RECORD, condition)
+}
+
+func (*TodoRecord) HasDoneRecord(ctx context.Context, todoId bsoncodec.ObjectId) (bool, error) {
+	condition := bsoncodec.M{
+		"isDeleted":   false,
+		"todoId":      todoId,
+		"hasBeenDone": true,
+	}
+	count, err := repository.Mongo.Count(ctx, C_TODO_RECORD, condition)
+	return count > 0, err
+}
+
 func (*TodoRecord) CountNotDoneRecordsByTodoId(ctx context.Context, todoId bsoncodec.ObjectId) (int64, error) {
 	condition := bsoncodec.M{
 		"isDeleted":   false,
@@ -252,3 +766,106 @@ func (*TodoRecord) CountNotDoneRecordsByTodoId(ctx context.Context, todoId bsonc
 	}
 	return repository.Mongo.Count(ctx, C_TODO_RECORD, condition)
 }
+
+func GenAssignees(userIds []string) []Assignee {
+	assignees := make([]Assignee, 0, len(userIds))
+	for _, userId := range util.Unique(userIds) {
+		assignees = append(assignees, Assignee{
+			UserId: userId,
+		})
+	}
+	return assignees
+}
+
+// GenUserRecordsCondition 用户创建的或者被指派的记录
+func GenUserRecordsCondition(userId string) bsoncodec.M {
+	return bsoncodec.M{
+		"$or": []bsoncodec.M{
+			{"userId": userId},
+			{"assignees.userId": userId},
+		},
+	}
+}
+
+func (t *TodoRecord) IsOwner(userId string) bool {
+	return t.UserId == userId
+}
+
+func (t *TodoRecord) IsAssignee(userId string) bool {
+	for _, assignee := range t.Assignees {
+		if assignee.UserId == userId {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *TodoRecord) GetUndoneAssigneeIds() []string {
+	var userIds []string
+	for _, assignee := range t.Assignees {
+		if !assignee.HasBeenDone {
+			userIds = append(userIds, assignee.UserId)
+		}
+	}
+	return userIds
+}
+
+// DoneByUser 创建者完成整条记录，被指派的成员只完成自己的部分
+func (*TodoRecord) DoneByUser(ctx context.Context, r TodoRecord, userId string) error {
+	if !r.IsOwner(userId) && r.IsAssignee(userId) {
+		return CTodoRecord.DoneByAssignee(ctx, r.Id, userId)
+	}
+	return CTodoRecord.Done(ctx, r.Id)
+}
+
+// DoneByAssignee 标记成员已完成，所有成员都完成后整条记录完成
+func (*TodoRecord) DoneByAssignee(ctx context.Context, id bsoncodec.ObjectId, userId string) error {
+	condition := bsoncodec.M{
+		"_id":              id,
+		"assignees.userId": userId,
+	}
+	change := qmgo.Change{
+		Upsert:    false,
+		ReturnNew: true,
+		Update: bsoncodec.M{
+			"$set": bsoncodec.M{
+				"assignees.$.hasBeenDone": true,
+				"assignees.$.doneAt":      time.Now(),
+				"updatedAt":               time.Now(),
+			},
+		},
+	}
+	r := TodoRecord{}
+	err := repository.Mongo.FindAndApply(ctx, C_TODO_RECORD, condition, change, &r)
+	if err != nil {
+		return err
+	}
+	if len(r.GetUndoneAssigneeIds()) == 0 && !r.HasBeenDone {
+		return CTodoRecord.Done(ctx, id)
+	}
+	return nil
+}
+
+// UndoByAssignee 撤销成员的完成状态，整条记录也会变为未完成
+func (*TodoRecord) UndoByAssignee(ctx context.Context, id bsoncodec.ObjectId, userId string) error {
+	condition := bsoncodec.M{
+		"_id":              id,
+		"assignees.userId": userId,
+	}
+	updater := bsoncodec.M{
+		"$set": bsoncodec.M{
+			"assignees.$.hasBeenDone": false,
+			"hasBeenDone":             false,
+			"updatedAt":               time.Now(),
+		},
+		"$unset": bsoncodec.M{
+			"assignees.$.doneAt": "",
+			"doneAt":             "",
+		},
+	}
+	if err := repository.Mongo.UpdateOne(ctx, C_TODO_RECORD, condition, updater); err != nil {
+		return err
+	}
+	onRecordChanged(ctx, id)
+	return nil
+}
@@ -2,11 +2,15 @@ package model
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"github.com/qiniu/qmgo"
 	"github.com/qiniu/qmgo/options"
+	"github.com/spf13/viper"
 	mgo_option "go.mongodb.org/mongo-driver/mongo/options"
 	"golang.org/x/crypto/bcrypt"
 	"time"
+	"todo-reminder/notifier"
 	"todo-reminder/repository"
 	"todo-reminder/repository/bsoncodec"
 	"todo-reminder/util"
@@ -16,8 +20,22 @@ const (
 	C_USER = "user"
 )
 
+const (
+	LOGIN_METHOD_PASSWORD = "password"
+	LOGIN_METHOD_CODE     = "code"
+
+	ROLE_USER  = ""
+	ROLE_ADMIN = "admin"
+
+	USER_DISABLED_BY_ADMIN      = "admin"
+	USER_DISABLED_BY_UNFRIENDED = "unfriended"
+)
+
 var (
 	CUser = &User{}
+
+	ErrInvalidCredentials = errors.New("invalid user id or password")
+	ErrUserDisabled       = errors.New("user has been disabled")
 )
 
 func init() {
@@ -28,6 +46,12 @@ func init() {
 			Unique:     util.PtrValue[bool](true),
 		},
 	})
+	repository.Mongo.CreateIndex(context.Background(), C_USER, options.IndexModel{
+		Key: []string{"isDeleted", "overdueDigest.isEnabled"},
+		IndexOptions: &mgo_option.IndexOptions{
+			Background: util.PtrValue[bool](true),
+		},
+	})
 }
 
 type User struct {
@@ -37,7 +61,25 @@ type User struct {
 	CreatedAt time.Time          `json:"createdAt" bson:"createdAt"`
 	UpdatedAt time.Time          `json:"updatedAt" bson:"updatedAt"`
 	IsDeleted bool               `json:"isDeleted" bson:"isDeleted"`
-	IsEnabled bool               `json:"isEnabled" bson:"isEnabled"`
+	// 被管理员禁用后不能登录，也不再收到提醒
+	IsEnabled bool   `json:"isEnabled" bson:"isEnabled"`
+	Role      string `json:"role" bson:"role,omitempty"`
+	// 被禁用的原因，删除机器人好友导致的禁用在重新添加好友后自动恢复
+	DisabledReason string `json:"disabledReason" bson:"disabledReason,omitempty"`
+	// 被管理员手动启用的用户不会因为不在好友列表中被自动禁用
+	IsEnabledByAdmin bool `json:"isEnabledByAdmin" bson:"isEnabledByAdmin,omitempty"`
+	// 按顺序尝试的通知渠道，前一个发送失败时使用下一个，为空时只使用 QQ
+	NotifyChannels []NotifyChannel `json:"notifyChannels" bson:"notifyChannels,omitempty"`
+	// IANA 时区，如 Asia/Shanghai，为空时使用服务器所在时区
+	Timezone      string        `json:"timezone" bson:"timezone,omitempty"`
+	OverdueDigest OverdueDigest `json:"overdueDigest" bson:"overdueDigest"`
+	// 最近一次发送逾期汇总的日期，避免同一天重复发送
+	LastOverdueDigestDate string `json:"lastOverdueDigestDate" bson:"lastOverdueDigestDate,omitempty"`
+}
+
+type NotifyChannel struct {
+	Type   string `json:"type" bson:"type"`
+	Target string `json:"target" bson:"target"`
 }
 
 func (*User) Create(ctx context.Context, userId, password string) error {
@@ -52,37 +94,54 @@ func (*User) Create(ctx context.Context, userId, password string) error {
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 		IsDeleted: false,
+		IsEnabled: true,
 	}
 
 	return repository.Mongo.Insert(ctx, C_USER, user)
 }
 
-func (*User) Login(ctx context.Context, userId, password string) (string, error) {
-	condition := bsoncodec.M{
-		"isDeleted": false,
-		"userId":    userId,
+// Login 校验密码后创建新的会话
+func (*User) Login(ctx context.Context, userId, password, userAgent, ip string) (TokenPair, error) {
+	return login(ctx, userId, userAgent, ip, LOGIN_METHOD_PASSWORD, func(user User) error {
+		return bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
+	})
+}
+
+// LoginWithCode 使用通过 QQ 发送的一次性验证码登录
+func (*User) LoginWithCode(ctx context.Context, userId, code, userAgent, ip string) (TokenPair, error) {
+	return login(ctx, userId, userAgent, ip, LOGIN_METHOD_CODE, func(user User) error {
+		return CLoginCode.Verify(ctx, user.UserId, code)
+	})
+}
+
+// login 账号或 IP 失败次数过多时拒绝登录，用户不存在和密码错误返回相同的错误
+func login(ctx context.Context, userId, userAgent, ip, method string, verify func(user User) error) (TokenPair, error) {
+	accountKey, ipKey := GenAccountThrottleKey(userId, ip, method), GenIpThrottleKey(ip)
+	if err := CAuthThrottle.Check(ctx, accountKey, ipKey); err != nil {
+		CAuthEvent.Record(ctx, AUTH_EVENT_LOGIN_LOCKED, userId, "", method)
+		return TokenPair{}, err
 	}
-	change := qmgo.Change{
-		Update: bsoncodec.M{
-			"$set": bsoncodec.M{
-				"isEnabled": true,
-				"updatedAt": time.Now(),
-			},
-		},
-		ReturnNew: true,
-		Upsert:    false,
+	user, err := CUser.GetByUserId(ctx, userId)
+	if err == nil {
+		err = verify(user)
+	}
+	if err == nil && !user.IsEnabled {
+		CAuthEvent.Record(ctx, AUTH_EVENT_LOGIN_FAILED, userId, "", fmt.Sprintf("%s: %s", method, ErrUserDisabled.Error()))
+		return TokenPair{}, ErrUserDisabled
 	}
-	// TODO: FindAndApply
-	user := User{}
-	err := repository.Mongo.FindAndApply(ctx, C_USER, condition, change, &user)
 	if err != nil {
-		return "", err
+		CAuthThrottle.RecordFailure(ctx, accountKey, GetMaxFailures(false))
+		CAuthThrottle.RecordFailure(ctx, ipKey, GetMaxFailures(true))
+		CAuthEvent.Record(ctx, AUTH_EVENT_LOGIN_FAILED, userId, "", fmt.Sprintf("%s: %s", method, err.Error()))
+		return TokenPair{}, ErrInvalidCredentials
 	}
-	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
+	CAuthThrottle.Reset(ctx, accountKey)
+	pair, err := CSession.Create(ctx, user.UserId, userAgent, ip)
 	if err != nil {
-		return "", err
+		return TokenPair{}, err
 	}
-	return util.GenerateToken(user.UserId), nil
+	CAuthEvent.Record(ctx, AUTH_EVENT_LOGIN_SUCCEEDED, userId, pair.SessionId, method)
+	return pair, nil
 }
 
 func (*User) GetByUserId(ctx context.Context, userId string) (User, error) {
@@ -95,10 +154,10 @@ func (*User) GetByUserId(ctx context.Context, userId string) (User, error) {
 	return user, err
 }
 
+// UpsertWithoutPassword 同步好友时创建用户，已经删除的用户不会重新创建
 func (u *User) UpsertWithoutPassword(ctx context.Context) error {
 	condition := bsoncodec.M{
-		"userId":    u.UserId,
-		"isDeleted": false,
+		"userId": u.UserId,
 	}
 	change := qmgo.Change{
 		Upsert:    true,
@@ -109,6 +168,8 @@ func (u *User) UpsertWithoutPassword(ctx context.Context) error {
 			},
 			"$setOnInsert": bsoncodec.M{
 				"createdAt": time.Now(),
+				"isDeleted": false,
+				"isEnabled": true,
 			},
 		},
 	}
@@ -132,3 +193,68 @@ func (*User) UpdatePassword(ctx context.Context, userId, password string) error
 	}
 	return repository.Mongo.UpdateOne(ctx, C_USER, condition, updater)
 }
+
+func (u *User) GetNotifyChannels() []notifier.Channel {
+	if len(u.NotifyChannels) == 0 {
+		return []notifier.Channel{
+			{
+				Type:   notifier.CHANNEL_QQ,
+				Target: u.UserId,
+			},
+		}
+	}
+	channels := make([]notifier.Channel, 0, len(u.NotifyChannels))
+	for _, channel := range u.NotifyChannels {
+		target := channel.Target
+		if channel.Type == notifier.CHANNEL_QQ && target == "" {
+			target = u.UserId
+		}
+		channels = append(channels, notifier.Channel{
+			Type:   channel.Type,
+			Target: target,
+		})
+	}
+	return channels
+}
+
+func (*User) GetNotifyChannelsByUserId(ctx context.Context, userId string) []notifier.Channel {
+	user, err := CUser.GetByUserId(ctx, userId)
+	if err != nil {
+		user = User{
+			UserId: userId,
+		}
+	}
+	return user.GetNotifyChannels()
+}
+
+func (*User) UpdateByUserId(ctx context.Context, userId string, updater bsoncodec.M) error {
+	condition := bsoncodec.M{
+		"userId":    userId,
+		"isDeleted": false,
+	}
+	return repository.Mongo.UpdateOne(ctx, C_USER, condition, updater)
+}
+
+// IsAdmin 角色为 admin 或者在 admin.userIds 中配置的用户是管理员，配置用于指定第一个管理员
+func (u *User) IsAdmin() bool {
+	return u.Role == ROLE_ADMIN || util.StrInArray(u.UserId, util.PtrValue(viper.GetStringSlice("admin.userIds")))
+}
+
+func IsValidRole(role string) bool {
+	return role == ROLE_USER || role == ROLE_ADMIN
+}
+
+// IsActiveUser 用户存在、未删除且未被禁用，查询失败时视为正常，避免数据库抖动时漏发提醒
+func (*User) IsActiveUser(ctx context.Context, userId string) bool {
+	user, err := CUser.GetByUserId(ctx, userId)
+	if err == qmgo.ErrNoSuchDocuments {
+		return false
+	}
+	return err != nil || user.IsEnabled
+}
+
+func (*User) ListByPagination(ctx context.Context, condition bsoncodec.M, page, perPage int64, orderBy []string) (int64, []User, error) {
+	var users []User
+	total, err := repository.Mongo.FindAllWithPage(ctx, C_USER, orderBy, page, perPage, condition, &users)
+	return total, users, err
+}
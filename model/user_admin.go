@@ -0,0 +1,137 @@
+package model
+
+import (
+	"context"
+	"time"
+	"todo-reminder/gocq"
+	"todo-reminder/repository"
+	"todo-reminder/repository/bsoncodec"
+	"todo-reminder/util"
+)
+
+const (
+	// 统计最近发送消息数量的天数
+	usageMessageDays = 30
+)
+
+// UserUsage 管理员查看的用户使用情况
+type UserUsage struct {
+	TodoCount          int64
+	RecordCount        int64
+	UndoneRecordCount  int64
+	OverdueRecordCount int64
+	// 最近 30 天发送给用户的 QQ 私聊消息
+	MessageCount      int64
+	ActiveSessions    int64
+	ActiveApiKeys     int64
+	LastLoginAt       time.Time
+	LastLoginIp       string
+	FailedLoginsToday int64
+}
+
+// SetEnabled 管理员启用或禁用用户
+func (*User) SetEnabled(ctx context.Context, userId string, isEnabled bool) error {
+	if isEnabled {
+		return CUser.UpdateByUserId(ctx, userId, bsoncodec.M{
+			"$set": bsoncodec.M{
+				"isEnabled":        true,
+				"isEnabledByAdmin": true,
+				"updatedAt":        time.Now(),
+			},
+			"$unset": bsoncodec.M{
+				"disabledReason": "",
+			},
+		})
+	}
+	return CUser.Disable(ctx, userId, USER_DISABLED_BY_ADMIN)
+}
+
+// Disable 禁用用户并注销用户所有的会话
+func (*User) Disable(ctx context.Context, userId, reason string) error {
+	err := CUser.UpdateByUserId(ctx, userId, bsoncodec.M{
+		"$set": bsoncodec.M{
+			"isEnabled":      false,
+			"disabledReason": reason,
+			"updatedAt":      time.Now(),
+		},
+		"$unset": bsoncodec.M{
+			"isEnabledByAdmin": "",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	return CSession.RevokeAllByUserId(ctx, userId, "")
+}
+
+func (*User) SetRole(ctx context.Context, userId, role string) error {
+	return CUser.UpdateByUserId(ctx, userId, bsoncodec.M{
+		"$set": bsoncodec.M{
+			"role":      role,
+			"updatedAt": time.Now(),
+		},
+	})
+}
+
+// ForceResetPassword 将密码重置为随机值并注销所有会话，用户需要使用验证码登录后重新设置密码
+func (*User) ForceResetPassword(ctx context.Context, userId string) error {
+	if err := CUser.UpdatePassword(ctx, userId, util.GenSecureToken(24)); err != nil {
+		return err
+	}
+	if err := CSession.RevokeAllByUserId(ctx, userId, ""); err != nil {
+		return err
+	}
+	return gocq.Enqueue(ctx, gocq.NewPrivateMessage(userId, "管理员重置了你的密码，请使用验证码登录后重新设置密码。"))
+}
+
+func (*User) GetUsage(ctx context.Context, userId string) (UserUsage, error) {
+	usage := UserUsage{}
+	now := time.Now()
+	counters := []struct {
+		collection string
+		condition  bsoncodec.M
+		result     *int64
+	}{
+		{C_TODO, genUsageCondition(GenUserTodosCondition(userId), bsoncodec.M{"isDeleted": false}), &usage.TodoCount},
+		{C_TODO_RECORD, genUsageCondition(GenUserRecordsCondition(userId), bsoncodec.M{"isDeleted": false}), &usage.RecordCount},
+		{C_TODO_RECORD, genUsageCondition(GenUserRecordsCondition(userId), bsoncodec.M{"isDeleted": false, "hasBeenDone": false}), &usage.UndoneRecordCount},
+		{C_TODO_RECORD, genUsageCondition(GenUserRecordsCondition(userId), bsoncodec.M{"isDeleted": false, "hasBeenDone": false, "isOverdue": true}), &usage.OverdueRecordCount},
+		{gocq.C_OUTBOUND_MESSAGE, bsoncodec.M{
+			"targetType": gocq.OUTBOUND_TARGET_PRIVATE,
+			"targetId":   userId,
+			"createdAt":  bsoncodec.M{"$gte": now.AddDate(0, 0, -usageMessageDays)},
+		}, &usage.MessageCount},
+		{C_SESSION, bsoncodec.M{"userId": userId, "isRevoked": false, "expiredAt": bsoncodec.M{"$gt": now}}, &usage.ActiveSessions},
+		{C_API_KEY, genActiveApiKeysCondition(userId), &usage.ActiveApiKeys},
+		{C_AUTH_EVENT, bsoncodec.M{
+			"userId":    userId,
+			"event":     AUTH_EVENT_LOGIN_FAILED,
+			"createdAt": bsoncodec.M{"$gte": now.Add(-24 * time.Hour)},
+		}, &usage.FailedLoginsToday},
+	}
+	for _, counter := range counters {
+		count, err := repository.Mongo.Count(ctx, counter.collection, counter.condition)
+		if err != nil {
+			return usage, err
+		}
+		*counter.result = count
+	}
+	lastLogin := AuthEvent{}
+	err := repository.Mongo.FindOneWithSorter(ctx, C_AUTH_EVENT, []string{"-createdAt"}, bsoncodec.M{
+		"userId": userId,
+		"event":  AUTH_EVENT_LOGIN_SUCCEEDED,
+	}, &lastLogin)
+	if err == nil {
+		usage.LastLoginAt = lastLogin.CreatedAt
+		usage.LastLoginIp = lastLogin.Ip
+	}
+	return usage, nil
+}
+
+// genUsageCondition 合并查询条件，userCondition 中包含 $or
+func genUsageCondition(userCondition, condition bsoncodec.M) bsoncodec.M {
+	for k, v := range condition {
+		userCondition[k] = v
+	}
+	return userCondition
+}
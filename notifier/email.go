@@ -0,0 +1,28 @@
+package notifier
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strings"
+	"todo-reminder/util"
+)
+
+func init() {
+	registerNotifier(CHANNEL_EMAIL, emailNotifier{})
+}
+
+type emailNotifier struct {
+}
+
+func (emailNotifier) Send(ctx context.Context, target string, message Message) (string, error) {
+	subject := message.Title
+	if subject == "" {
+		subject = "Todo Reminder"
+	}
+	content := strings.ReplaceAll(html.EscapeString(message.Content), "\n", "<br/>")
+	for _, image := range message.Images {
+		content += fmt.Sprintf(`<br/><img src="%s" alt="%s"/>`, html.EscapeString(image.Url), html.EscapeString(image.Name))
+	}
+	return "", util.SendEmail(ctx, target, subject, content)
+}
@@ -0,0 +1,112 @@
+package notifier
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+	"todo-reminder/util"
+)
+
+const (
+	// 单次请求的超时时间，避免某个渠道无响应时阻塞其他提醒
+	requestTimeout = 10 * time.Second
+	maxRedirects   = 3
+)
+
+var (
+	ErrPrivateAddress = errors.New("webhook address must be a public address")
+
+	httpClient = &http.Client{
+		Timeout: requestTimeout,
+	}
+	// webhookClient 用于请求用户配置的地址，建立连接时校验解析后的 IP，重定向和 DNS 变化后同样生效
+	webhookClient = &http.Client{
+		Timeout: requestTimeout,
+		Transport: &http.Transport{
+			DialContext: (&net.Dialer{
+				Timeout: requestTimeout,
+				Control: checkPublicAddress,
+			}).DialContext,
+			TLSHandshakeTimeout: requestTimeout,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return errors.New("too many redirects")
+			}
+			return nil
+		},
+	}
+)
+
+// IsPublicIP 排除回环、内网、链路本地、组播等地址
+func IsPublicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified())
+}
+
+func checkPublicAddress(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !IsPublicIP(ip) {
+		return ErrPrivateAddress
+	}
+	return nil
+}
+
+// ValidateWebhookUrl 只允许 http(s) 协议且解析到公网地址的 URL
+func ValidateWebhookUrl(ctx context.Context, rawUrl string) error {
+	u, err := url.ParseRequestURI(rawUrl)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return errors.New("invalid webhook url")
+	}
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, u.Hostname())
+	if err != nil {
+		return fmt.Errorf("failed to resolve webhook host: %w", err)
+	}
+	for _, addr := range addrs {
+		if !IsPublicIP(addr.IP) {
+			return ErrPrivateAddress
+		}
+	}
+	return nil
+}
+
+func postJSON(ctx context.Context, client *http.Client, url string, headers map[string]string, body interface{}) error {
+	merged := map[string]string{"Content-Type": "application/json"}
+	for k, v := range headers {
+		merged[k] = v
+	}
+	return post(ctx, client, url, merged, util.MarshalToJson(body))
+}
+
+func post(ctx context.Context, client *http.Client, url string, headers map[string]string, body string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, respBody)
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package notifier
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"todo-reminder/log"
+)
+
+const (
+	CHANNEL_QQ       = "qq"
+	CHANNEL_QQ_GROUP = "qqGroup"
+	CHANNEL_EMAIL    = "email"
+	CHANNEL_WEBHOOK  = "webhook"
+	CHANNEL_TELEGRAM = "telegram"
+	CHANNEL_BARK     = "bark"
+	CHANNEL_NTFY     = "ntfy"
+)
+
+var (
+	notifiers = map[string]Notifier{}
+)
+
+type Notifier interface {
+	// Send 向 target 发送消息，target 的含义由渠道决定，如 QQ 号、邮箱地址、webhook 地址
+	// 渠道支持时返回发送的消息的 id，否则返回空字符串，QQ 渠道只将消息写入发送队列，不返回消息 id
+	Send(ctx context.Context, target string, message Message) (string, error)
+}
+
+type Message struct {
+	Title   string
+	Content string
+	Images  []Image
+	// 需要 @ 的 QQ 号，仅群消息有效
+	Mentions []string
+	// 以下字段仅 QQ 渠道有效，消息进入发送队列后由队列负责去重、重试和回调
+	DedupeKey    string
+	Callback     string
+	CallbackData map[string]string
+}
+
+type Image struct {
+	Name string
+	Url  string
+}
+
+type Channel struct {
+	Type   string
+	Target string
+}
+
+// Receipt 发送成功的渠道及消息 id
+type Receipt struct {
+	Channel   Channel
+	MessageId string
+}
+
+func registerNotifier(channelType string, notifier Notifier) {
+	notifiers[channelType] = notifier
+}
+
+func IsSupported(channelType string) bool {
+	_, ok := notifiers[channelType]
+	return ok
+}
+
+func GetNotifier(channelType string) (Notifier, error) {
+	notifier, ok := notifiers[channelType]
+	if !ok {
+		return nil, fmt.Errorf("unsupported notify channel %s", channelType)
+	}
+	return notifier, nil
+}
+
+// SendWithFallback 按顺序尝试各个渠道，有一个发送成功即返回
+func SendWithFallback(ctx context.Context, channels []Channel, message Message) (Receipt, error) {
+	if len(channels) == 0 {
+		return Receipt{}, errors.New("no notify channel")
+	}
+	var errs []string
+	for _, channel := range channels {
+		var messageId string
+		notifier, err := GetNotifier(channel.Type)
+		if err == nil {
+			messageId, err = notifier.Send(ctx, channel.Target, message)
+		}
+		if err == nil {
+			return Receipt{
+				Channel:   channel,
+				MessageId: messageId,
+			}, nil
+		}
+		log.Warn("Failed to send message", map[string]interface{}{
+			"channel": channel.Type,
+			"target":  channel.Target,
+			"error":   err.Error(),
+		})
+		errs = append(errs, fmt.Sprintf("%s: %s", channel.Type, err.Error()))
+	}
+	return Receipt{}, errors.New(strings.Join(errs, "; "))
+}
+
+// formatPlainText 将图片以链接的形式附在正文后，用于不支持图片的渠道
+func formatPlainText(message Message) string {
+	lines := []string{message.Content}
+	for _, image := range message.Images {
+		lines = append(lines, image.Url)
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,63 @@
+package notifier
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/spf13/viper"
+	"net/url"
+	"strings"
+)
+
+func init() {
+	registerNotifier(CHANNEL_TELEGRAM, telegramNotifier{})
+	registerNotifier(CHANNEL_BARK, barkNotifier{})
+	registerNotifier(CHANNEL_NTFY, ntfyNotifier{})
+}
+
+type telegramNotifier struct {
+}
+
+// Send target 为 chat id
+func (telegramNotifier) Send(ctx context.Context, target string, message Message) (string, error) {
+	token := viper.GetString("notifier.telegram.botToken")
+	if token == "" {
+		return "", errors.New("telegram bot token not configured")
+	}
+	return "", postJSON(ctx, httpClient, fmt.Sprintf("https://api.telegram.org/bot%s/sendMessage", token), nil, map[string]interface{}{
+		"chat_id": target,
+		"text":    formatPlainText(message),
+	})
+}
+
+type barkNotifier struct {
+}
+
+// Send target 为 bark 的 device key
+func (barkNotifier) Send(ctx context.Context, target string, message Message) (string, error) {
+	server := strings.TrimSuffix(viper.GetString("notifier.bark.server"), "/")
+	body := map[string]interface{}{
+		"device_key": target,
+		"title":      message.Title,
+		"body":       message.Content,
+	}
+	if len(message.Images) > 0 {
+		body["url"] = message.Images[0].Url
+	}
+	return "", postJSON(ctx, httpClient, fmt.Sprintf("%s/push", server), nil, body)
+}
+
+type ntfyNotifier struct {
+}
+
+// Send target 为 ntfy 的 topic
+func (ntfyNotifier) Send(ctx context.Context, target string, message Message) (string, error) {
+	server := strings.TrimSuffix(viper.GetString("notifier.ntfy.server"), "/")
+	headers := map[string]string{
+		"Content-Type": "text/plain",
+	}
+	if message.Title != "" {
+		headers["Title"] = message.Title
+	}
+	return "", post(ctx, httpClient, fmt.Sprintf("%s/%s", server, url.PathEscape(target)), headers, formatPlainText(message))
+}
@@ -0,0 +1,42 @@
+package notifier
+
+import (
+	"context"
+	"todo-reminder/gocq"
+)
+
+func init() {
+	registerNotifier(CHANNEL_QQ, qqNotifier{})
+	registerNotifier(CHANNEL_QQ_GROUP, qqGroupNotifier{})
+}
+
+type qqNotifier struct {
+}
+
+// Send 将消息写入发送队列，送达后的消息 id 通过 message.Callback 回调获取
+func (qqNotifier) Send(ctx context.Context, target string, message Message) (string, error) {
+	m := gocq.NewPrivateMessage(target, message.Content)
+	for _, image := range message.Images {
+		m.Images = append(m.Images, gocq.OutboundImage{
+			Name: image.Name,
+			Url:  image.Url,
+		})
+	}
+	return "", enqueue(ctx, m, message)
+}
+
+type qqGroupNotifier struct {
+}
+
+// Send target 为群号，图片以链接的形式附在消息后面
+func (qqGroupNotifier) Send(ctx context.Context, target string, message Message) (string, error) {
+	m := gocq.NewGroupMessage(target, message.Mentions, formatPlainText(message))
+	return "", enqueue(ctx, m, message)
+}
+
+func enqueue(ctx context.Context, m *gocq.OutboundMessage, message Message) error {
+	m.DedupeKey = message.DedupeKey
+	m.Callback = message.Callback
+	m.CallbackData = message.CallbackData
+	return gocq.Enqueue(ctx, m)
+}
@@ -0,0 +1,33 @@
+package notifier
+
+import (
+	"context"
+)
+
+func init() {
+	registerNotifier(CHANNEL_WEBHOOK, webhookNotifier{})
+}
+
+type webhookNotifier struct {
+}
+
+type webhookImage struct {
+	Name string `json:"name"`
+	Url  string `json:"url"`
+}
+
+// Send 以 JSON 的形式 POST 到用户配置的地址
+func (webhookNotifier) Send(ctx context.Context, target string, message Message) (string, error) {
+	images := make([]webhookImage, 0, len(message.Images))
+	for _, image := range message.Images {
+		images = append(images, webhookImage{
+			Name: image.Name,
+			Url:  image.Url,
+		})
+	}
+	return "", postJSON(ctx, webhookClient, target, nil, map[string]interface{}{
+		"title":   message.Title,
+		"content": message.Content,
+		"images":  images,
+	})
+}
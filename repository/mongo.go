@@ -6,6 +6,7 @@ import (
 	"github.com/qiniu/qmgo/options"
 	"github.com/spf13/viper"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
 	mgo_option "go.mongodb.org/mongo-driver/mongo/options"
 	"todo-reminder/repository/bsoncodec"
 )
@@ -22,7 +23,7 @@ func init() {
 		Uri:      uri,
 		Database: database,
 	}, options.ClientOptions{
-		&mgo_option.ClientOptions{
+		ClientOptions: &mgo_option.ClientOptions{
 			Registry: bsoncodec.DefaultRegistry,
 		},
 	})
@@ -42,10 +43,13 @@ type dbRepository interface {
 	FindOne(ctx context.Context, collection string, condition bson.M, result interface{}) error
 	Count(ctx context.Context, collection string, condition bson.M) (int64, error)
 	FindAndApply(ctx context.Context, collection string, condition bson.M, change qmgo.Change, result interface{}) error
+	FindAndApplyWithSorter(ctx context.Context, collection string, sorter []string, condition bson.M, change qmgo.Change, result interface{}) error
 	CreateIndex(ctx context.Context, collection string, index options.IndexModel) error
+	CreateTextIndex(ctx context.Context, collection string, fields []string, indexOptions *mgo_option.IndexOptions) error
 	FindAllWithSorter(ctx context.Context, collection string, sorter []string, condition bsoncodec.M, result interface{}) error
 	FindOneWithSorter(ctx context.Context, collection string, sorter []string, condition bsoncodec.M, result interface{}) error
 	FindAllWithPage(ctx context.Context, collection string, sorter []string, page, perPage int64, condition bsoncodec.M, result interface{}) (int64, error)
+	RemoveAll(ctx context.Context, collection string, condition bsoncodec.M) (int64, error)
 }
 
 type mongoRepository struct {
@@ -85,10 +89,31 @@ func (m mongoRepository) FindAndApply(ctx context.Context, collection string, co
 	return m.client.Database.Collection(collection).Find(ctx, condition).Apply(change, result)
 }
 
+func (m mongoRepository) FindAndApplyWithSorter(ctx context.Context, collection string, sorter []string, condition bson.M, change qmgo.Change, result interface{}) error {
+	return m.client.Database.Collection(collection).Find(ctx, condition).Sort(sorter...).Apply(change, result)
+}
+
 func (m mongoRepository) CreateIndex(ctx context.Context, collection string, index options.IndexModel) error {
 	return m.client.Database.Collection(collection).CreateOneIndex(ctx, index)
 }
 
+// CreateTextIndex qmgo 的 IndexModel 只支持升序和降序索引，文本索引需要通过 mongo driver 创建
+func (m mongoRepository) CreateTextIndex(ctx context.Context, collection string, fields []string, indexOptions *mgo_option.IndexOptions) error {
+	col, err := m.client.Database.Collection(collection).CloneCollection()
+	if err != nil {
+		return err
+	}
+	keys := bson.D{}
+	for _, field := range fields {
+		keys = append(keys, bson.E{Key: field, Value: "text"})
+	}
+	_, err = col.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    keys,
+		Options: indexOptions,
+	})
+	return err
+}
+
 func (m mongoRepository) FindOneWithSorter(ctx context.Context, collection string, sorter []string, condition bsoncodec.M, result interface{}) error {
 	return m.client.Database.Collection(collection).Find(ctx, condition).Sort(sorter...).One(result)
 }
@@ -113,3 +138,11 @@ func (m mongoRepository) FindAllWithPage(ctx context.Context, collection string,
 	}
 	return col.Find(ctx, condition).Count()
 }
+
+func (m mongoRepository) RemoveAll(ctx context.Context, collection string, condition bsoncodec.M) (int64, error) {
+	result, err := m.client.Database.Collection(collection).RemoveAll(ctx, condition)
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
@@ -0,0 +1,38 @@
+package test
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+	_ "todo-reminder/conf"
+	"todo-reminder/model"
+)
+
+func TestApiKey(t *testing.T) {
+	ctx := context.Background()
+	userId := "test_api_key"
+	apiKey := model.ApiKey{
+		UserId:    userId,
+		Name:      "home assistant",
+		Scopes:    []string{"todos:admin"},
+		ExpiredAt: time.Now().Add(time.Hour),
+	}
+	_, err := apiKey.Create(ctx)
+	assert.ErrorIs(t, err, model.ErrInvalidApiScope)
+	apiKey.Scopes = []string{model.API_SCOPE_WRITE_TODOS, model.API_SCOPE_DONE_RECORDS}
+	key, err := apiKey.Create(ctx)
+	assert.NoError(t, err)
+	assert.True(t, model.IsApiKey(key))
+
+	authenticated, err := model.CApiKey.Authenticate(ctx, key)
+	assert.NoError(t, err)
+	assert.Equal(t, userId, authenticated.UserId)
+	assert.True(t, authenticated.HasScope(model.API_SCOPE_WRITE_TODOS))
+	assert.False(t, authenticated.HasScope(model.API_SCOPE_READ_RECORDS))
+	assert.False(t, authenticated.HasScope(""))
+
+	assert.NoError(t, model.CApiKey.Revoke(ctx, userId, apiKey.Id))
+	_, err = model.CApiKey.Authenticate(ctx, key)
+	assert.ErrorIs(t, err, model.ErrInvalidApiKey)
+}
@@ -0,0 +1,53 @@
+package test
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+	_ "todo-reminder/conf"
+	"todo-reminder/model"
+	"todo-reminder/util"
+)
+
+func TestGetLockoutDuration(t *testing.T) {
+	assert.Equal(t, time.Minute, model.GetLockoutDuration(1))
+	assert.Equal(t, 4*time.Minute, model.GetLockoutDuration(3))
+	assert.Equal(t, 24*time.Hour, model.GetLockoutDuration(20))
+	assert.Len(t, util.GenSecureDigits(6), 6)
+}
+
+func TestAuthThrottle(t *testing.T) {
+	ctx := context.Background()
+	key := model.GenAccountThrottleKey("test_throttle", "127.0.0.1", model.LOGIN_METHOD_PASSWORD)
+	assert.NoError(t, model.CAuthThrottle.Reset(ctx, key))
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, model.CAuthThrottle.Check(ctx, key))
+		assert.NoError(t, model.CAuthThrottle.RecordFailure(ctx, key, 2))
+	}
+	// 达到失败次数上限后锁定
+	assert.ErrorIs(t, model.CAuthThrottle.Check(ctx, key, model.GenIpThrottleKey("127.0.0.1")), model.ErrTooManyAttempts)
+	// 其他 IP 和验证码登录不受影响
+	assert.NoError(t, model.CAuthThrottle.Check(ctx, model.GenAccountThrottleKey("test_throttle", "127.0.0.2", model.LOGIN_METHOD_PASSWORD)))
+	assert.NoError(t, model.CAuthThrottle.Check(ctx, model.GenAccountThrottleKey("test_throttle", "127.0.0.1", model.LOGIN_METHOD_CODE)))
+	// 重置后解除锁定
+	assert.NoError(t, model.CAuthThrottle.Reset(ctx, key))
+	assert.NoError(t, model.CAuthThrottle.Check(ctx, key))
+}
+
+func TestLoginCode(t *testing.T) {
+	ctx := context.Background()
+	userId := "test_login_code"
+	code, err := model.CLoginCode.Generate(ctx, userId)
+	if err == model.ErrLoginCodeTooSoon {
+		t.Skip("login code was generated recently")
+	}
+	assert.NoError(t, err)
+	// 冷却时间内不能重新生成
+	_, err = model.CLoginCode.Generate(ctx, userId)
+	assert.ErrorIs(t, err, model.ErrLoginCodeTooSoon)
+	assert.ErrorIs(t, model.CLoginCode.Verify(ctx, userId, "wrong"), model.ErrInvalidLoginCode)
+	assert.NoError(t, model.CLoginCode.Verify(ctx, userId, code))
+	// 验证码只能使用一次
+	assert.ErrorIs(t, model.CLoginCode.Verify(ctx, userId, code), model.ErrInvalidLoginCode)
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"log"
 	"testing"
+	"time"
 	_ "todo-reminder/conf"
 	"todo-reminder/gocq"
 	"todo-reminder/util"
@@ -20,17 +21,17 @@ func TestWSListFriends(t *testing.T) {
 }
 
 func TestWSAtInGroup(t *testing.T) {
-	err := gocq.GetGocqInstance().SendAtInGroup(context.Background(), "484122864", "1658272229", "测试")
+	_, err := gocq.GetGocqInstance().SendAtInGroup(context.Background(), "484122864", []string{"1658272229"}, "测试")
 	assert.NoError(t, err)
 }
 
 func TestWSSendImageInGroup(t *testing.T) {
-	err := gocq.GetGocqInstance().SendGroupImageMessage(context.Background(), "484122864", "test.png", "/home/user/Pictures/test.png")
+	err := gocq.GetGocqInstance().SendGroupImageMessage(context.Background(), "484122864", "test.png", util.GenFileURI("/home/user/Pictures/test.png"))
 	assert.NoError(t, err)
 }
 
 func TestWSSendPrivateMessage(t *testing.T) {
-	err := gocq.GetGocqInstance().SendPrivateStringMessage(context.Background(), "123", "1658272229")
+	_, err := gocq.GetGocqInstance().SendPrivateStringMessage(context.Background(), "123", "1658272229")
 	assert.NoError(t, err)
 }
 
@@ -58,3 +59,24 @@ func TestGetCQParams(t *testing.T) {
 		log.Println("====================")
 	}
 }
+
+func TestActionError(t *testing.T) {
+	resp := gocq.BaseResponse[gocq.SendMessageResponse]{
+		Code:    100,
+		Status:  gocq.ACTION_STATUS_FAILED,
+		Message: "SEND_MSG_API_ERROR",
+	}
+	err := resp.Err("send_private_msg")
+	var actionErr *gocq.ActionError
+	assert.ErrorAs(t, err, &actionErr)
+	assert.Equal(t, int64(100), actionErr.RetCode)
+	resp.Status = gocq.ACTION_STATUS_OK
+	assert.NoError(t, resp.Err("send_private_msg"))
+}
+
+func TestGetRetryInterval(t *testing.T) {
+	assert.Equal(t, 10*time.Second, gocq.GetRetryInterval(1))
+	assert.Equal(t, 20*time.Second, gocq.GetRetryInterval(2))
+	assert.Equal(t, 80*time.Second, gocq.GetRetryInterval(4))
+	assert.Equal(t, 30*time.Minute, gocq.GetRetryInterval(20))
+}
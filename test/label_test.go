@@ -0,0 +1,42 @@
+package test
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	_ "todo-reminder/conf"
+	"todo-reminder/model"
+)
+
+func TestIsValidColor(t *testing.T) {
+	assert.True(t, model.IsValidColor(""))
+	assert.True(t, model.IsValidColor("#1a2B3c"))
+	assert.False(t, model.IsValidColor("1a2b3c"))
+	assert.False(t, model.IsValidColor("#fff"))
+}
+
+func TestIsValidPriority(t *testing.T) {
+	assert.True(t, model.IsValidPriority(model.PRIORITY_NONE))
+	assert.True(t, model.IsValidPriority(model.PRIORITY_HIGH))
+	assert.False(t, model.IsValidPriority(4))
+	assert.False(t, model.IsValidPriority(-1))
+}
+
+func TestLabelDuplicateName(t *testing.T) {
+	ctx := context.Background()
+	userId := "test_label"
+	label := model.Label{
+		UserId: userId,
+		Name:   "test_label_name",
+	}
+	assert.NoError(t, model.CTag.Create(ctx, &label))
+	defer model.CTag.DeleteById(ctx, userId, label.Id)
+	duplicate := model.Label{
+		UserId: userId,
+		Name:   label.Name,
+	}
+	assert.ErrorIs(t, model.CTag.Create(ctx, &duplicate), model.ErrDuplicateName)
+	// 清单和标签分别校验重名
+	assert.NoError(t, model.CProject.Create(ctx, &duplicate))
+	assert.NoError(t, model.CProject.DeleteById(ctx, userId, duplicate.Id))
+}
@@ -0,0 +1,31 @@
+package test
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+	_ "todo-reminder/conf"
+	"todo-reminder/model"
+)
+
+func TestLease(t *testing.T) {
+	ctx := context.Background()
+	name := "test_lease"
+	ok, err := model.CLease.Acquire(ctx, name, "instance_a", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	// 未过期时其他实例无法获取
+	ok, err = model.CLease.Acquire(ctx, name, "instance_b", time.Minute)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	// 持有者可以续期
+	ok, err = model.CLease.Acquire(ctx, name, "instance_a", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.NoError(t, model.CLease.Release(ctx, name, "instance_a"))
+	ok, err = model.CLease.Acquire(ctx, name, "instance_b", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.NoError(t, model.CLease.Release(ctx, name, "instance_b"))
+}
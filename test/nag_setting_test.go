@@ -0,0 +1,46 @@
+package test
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+	"todo-reminder/model"
+)
+
+func TestQuietHoursAcrossMidnight(t *testing.T) {
+	quietHours := model.QuietHours{
+		Start: "22:00",
+		End:   "08:00",
+	}
+	assert.True(t, quietHours.Contains(time.Date(2026, 10, 19, 23, 0, 0, 0, time.Local)))
+	assert.True(t, quietHours.Contains(time.Date(2026, 10, 19, 7, 59, 0, 0, time.Local)))
+	assert.False(t, quietHours.Contains(time.Date(2026, 10, 19, 8, 0, 0, 0, time.Local)))
+	assert.Equal(t, time.Date(2026, 10, 20, 8, 0, 0, 0, time.Local), quietHours.GetEndTime(time.Date(2026, 10, 19, 23, 0, 0, 0, time.Local)))
+}
+
+func TestGetNextNagAt(t *testing.T) {
+	setting := model.NagSetting{
+		IsEnabled:       true,
+		IntervalMinutes: 30,
+		MaxTimes:        3,
+		QuietHours: model.QuietHours{
+			Start: "22:00",
+			End:   "08:00",
+		},
+	}
+	assert.Equal(t, time.Date(2026, 10, 19, 21, 30, 0, 0, time.Local), setting.GetNextNagAt(time.Date(2026, 10, 19, 21, 0, 0, 0, time.Local)))
+	assert.Equal(t, time.Date(2026, 10, 20, 8, 0, 0, 0, time.Local), setting.GetNextNagAt(time.Date(2026, 10, 19, 21, 45, 0, 0, time.Local)))
+}
+
+func TestQuietHoursSingleDigitHour(t *testing.T) {
+	quietHours := model.QuietHours{
+		Start: "9:00",
+		End:   "12:00",
+	}
+	assert.True(t, quietHours.Contains(time.Date(2026, 10, 19, 10, 0, 0, 0, time.Local)))
+	assert.False(t, quietHours.Contains(time.Date(2026, 10, 19, 8, 0, 0, 0, time.Local)))
+	assert.Error(t, quietHours.Validate())
+	quietHours.Start = "09:00"
+	assert.NoError(t, quietHours.Validate())
+	assert.Error(t, model.QuietHours{Start: "24:00", End: "08:00"}.Validate())
+}
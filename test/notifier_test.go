@@ -0,0 +1,57 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"todo-reminder/notifier"
+)
+
+func TestSendWithFallback(t *testing.T) {
+	var received map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&received)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	failed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failed.Close()
+	receipt, err := notifier.SendWithFallback(context.Background(), []notifier.Channel{
+		{
+			Type:   "unknown",
+			Target: "test",
+		},
+		{
+			Type:   notifier.CHANNEL_WEBHOOK,
+			Target: failed.URL,
+		},
+		{
+			Type:   notifier.CHANNEL_WEBHOOK,
+			Target: server.URL,
+		},
+	}, notifier.Message{
+		Title:   "test",
+		Content: "content",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "content", received["content"])
+	assert.Equal(t, server.URL, receipt.Channel.Target)
+	assert.Empty(t, receipt.MessageId)
+}
+
+func TestSendWithAllChannelsFailed(t *testing.T) {
+	_, err := notifier.SendWithFallback(context.Background(), []notifier.Channel{
+		{
+			Type:   "unknown",
+			Target: "test",
+		},
+	}, notifier.Message{
+		Content: "content",
+	})
+	assert.Error(t, err)
+}
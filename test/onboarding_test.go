@@ -0,0 +1,46 @@
+package test
+
+import (
+	"context"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	_ "todo-reminder/conf"
+	"todo-reminder/model"
+)
+
+func TestOnboarding(t *testing.T) {
+	ctx := context.Background()
+	userId := "test_onboarding"
+	viper.Set("onboarding.autoApprove", false)
+	viper.Set("onboarding.inviteCodes", []string{"todo2023"})
+	assert.False(t, model.CanAutoApproveFriend(ctx, userId, "你好"))
+	assert.True(t, model.CanAutoApproveFriend(ctx, userId, "问题：邀请码\n回答：todo2023"))
+
+	user, err := model.CUser.Onboard(ctx, userId)
+	assert.NoError(t, err)
+	assert.True(t, user.IsEnabled)
+	// 删除好友后禁用，重新添加好友后恢复
+	assert.NoError(t, model.CUser.Disable(ctx, userId, model.USER_DISABLED_BY_UNFRIENDED))
+	assert.True(t, model.CanAutoApproveFriend(ctx, userId, "todo2023"))
+	assert.False(t, model.CUser.IsActiveUser(ctx, userId))
+	user, err = model.CUser.Onboard(ctx, userId)
+	assert.NoError(t, err)
+	assert.True(t, user.IsEnabled)
+	// 被管理员禁用的用户不会自动恢复
+	assert.NoError(t, model.CUser.SetEnabled(ctx, userId, false))
+	assert.False(t, model.CanAutoApproveFriend(ctx, userId, "todo2023"))
+	user, err = model.CUser.Onboard(ctx, userId)
+	assert.NoError(t, err)
+	assert.False(t, user.IsEnabled)
+	assert.NoError(t, model.CUser.SetEnabled(ctx, userId, true))
+	user, err = model.CUser.GetByUserId(ctx, userId)
+	assert.NoError(t, err)
+	assert.True(t, user.IsEnabledByAdmin)
+	// 好友列表为空时不禁用任何用户
+	assert.NoError(t, model.CUser.DisableUnfriendedOnes(ctx, nil))
+	assert.True(t, model.CUser.IsActiveUser(ctx, userId))
+
+	assert.True(t, strings.Contains(model.FormatWelcomeMessage("123456"), "123456"))
+}
@@ -0,0 +1,97 @@
+package test
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+	_ "todo-reminder/conf"
+	"todo-reminder/model"
+)
+
+func TestRRuleByDay(t *testing.T) {
+	ctx := context.Background()
+	// 2026-10-19 是周一
+	remindAt := time.Date(2026, 10, 19, 9, 0, 0, 0, time.Local)
+	setting := model.RemindSetting{
+		RemindAt:     remindAt,
+		LastRemindAt: remindAt,
+		IsRepeatable: true,
+		RepeatSetting: model.RepeatSetting{
+			Type:  model.REPEAT_TYPE_RRULE,
+			RRule: "FREQ=WEEKLY;BYDAY=MO,WE,FR",
+		},
+	}
+	next, err := setting.GetNextRemindAt(ctx, time.Local)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2026, 10, 21, 9, 0, 0, 0, time.Local), next)
+	next, err = setting.GetNextRemindAt(ctx, time.Local)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2026, 10, 23, 9, 0, 0, 0, time.Local), next)
+}
+
+func TestRRuleLastFridayOfMonth(t *testing.T) {
+	ctx := context.Background()
+	remindAt := time.Date(2026, 10, 30, 18, 0, 0, 0, time.Local)
+	setting := model.RemindSetting{
+		RemindAt:     remindAt,
+		LastRemindAt: remindAt,
+		IsRepeatable: true,
+		RepeatSetting: model.RepeatSetting{
+			Type:  model.REPEAT_TYPE_RRULE,
+			RRule: "FREQ=MONTHLY;BYDAY=FR;BYSETPOS=-1",
+		},
+	}
+	next, err := setting.GetNextRemindAt(ctx, time.Local)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2026, 11, 27, 18, 0, 0, 0, time.Local), next)
+}
+
+func TestRRuleCountAndExDate(t *testing.T) {
+	ctx := context.Background()
+	remindAt := time.Date(2026, 10, 19, 9, 0, 0, 0, time.Local)
+	setting := model.RemindSetting{
+		RemindAt:     remindAt,
+		LastRemindAt: remindAt,
+		IsRepeatable: true,
+		RepeatSetting: model.RepeatSetting{
+			Type:    model.REPEAT_TYPE_RRULE,
+			RRule:   "FREQ=DAILY;COUNT=3",
+			ExDates: []time.Time{remindAt.AddDate(0, 0, 1)},
+		},
+	}
+	next, err := setting.GetNextRemindAt(ctx, time.Local)
+	assert.NoError(t, err)
+	assert.Equal(t, remindAt.AddDate(0, 0, 2), next)
+	_, err = setting.GetNextRemindAt(ctx, time.Local)
+	assert.Equal(t, model.ErrNoMoreOccurrence, err)
+}
+
+func TestShorthandMapsToRRule(t *testing.T) {
+	setting := model.RepeatSetting{
+		Type:       model.REPEAT_TYPE_WEEKLY,
+		DateOffset: 2,
+	}
+	assert.Equal(t, "FREQ=WEEKLY;INTERVAL=2", setting.ToRRule())
+}
+
+func TestRRuleAcrossDST(t *testing.T) {
+	ctx := context.Background()
+	loc, err := time.LoadLocation("America/New_York")
+	assert.NoError(t, err)
+	// 2026-11-01 凌晨结束夏令时，提醒的本地时间应该保持 9 点
+	remindAt := time.Date(2026, 10, 31, 9, 0, 0, 0, loc).UTC()
+	setting := model.RemindSetting{
+		RemindAt:     remindAt,
+		LastRemindAt: remindAt,
+		IsRepeatable: true,
+		RepeatSetting: model.RepeatSetting{
+			Type:       model.REPEAT_TYPE_DAILY,
+			DateOffset: 1,
+		},
+	}
+	next, err := setting.GetNextRemindAt(ctx, loc)
+	assert.NoError(t, err)
+	assert.Equal(t, 9, next.Hour())
+	assert.Equal(t, time.Date(2026, 11, 1, 14, 0, 0, 0, time.UTC), next.UTC())
+}
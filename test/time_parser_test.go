@@ -0,0 +1,55 @@
+package test
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+	"todo-reminder/bot"
+	"todo-reminder/model"
+)
+
+// 2026-10-18 是周日
+var parserNow = time.Date(2026, 10, 18, 16, 20, 0, 0, time.Local)
+
+func TestParseChineseTodo(t *testing.T) {
+	parsed, err := bot.ParseTodo("明天下午3点提醒我交报告", parserNow)
+	assert.NoError(t, err)
+	assert.Equal(t, "交报告", parsed.Content)
+	assert.Equal(t, time.Date(2026, 10, 19, 15, 0, 0, 0, time.Local), parsed.RemindAt)
+	assert.False(t, parsed.IsRepeatable)
+
+	parsed, err = bot.ParseTodo("1个半小时后开会", parserNow)
+	assert.NoError(t, err)
+	assert.Equal(t, "开会", parsed.Content)
+	assert.Equal(t, time.Date(2026, 10, 18, 17, 50, 0, 0, time.Local), parsed.RemindAt)
+
+	parsed, err = bot.ParseTodo("周五晚上8点看电影", parserNow)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2026, 10, 23, 20, 0, 0, 0, time.Local), parsed.RemindAt)
+}
+
+func TestParseRepeatableTodo(t *testing.T) {
+	parsed, err := bot.ParseTodo("every weekday 9am stand-up", parserNow)
+	assert.NoError(t, err)
+	assert.Equal(t, "stand-up", parsed.Content)
+	assert.True(t, parsed.IsRepeatable)
+	assert.Equal(t, model.REPEAT_TYPE_RRULE, parsed.RepeatSetting.Type)
+	assert.Equal(t, "FREQ=WEEKLY;BYDAY=MO,TU,WE,TH,FR", parsed.RepeatSetting.RRule)
+	assert.Equal(t, 9, parsed.RemindAt.Hour())
+
+	parsed, err = bot.ParseTodo("每个工作日 9:30 打卡", parserNow)
+	assert.NoError(t, err)
+	assert.Equal(t, "打卡", parsed.Content)
+	assert.Equal(t, model.REPEAT_TYPE_WORKING_DAY, parsed.RepeatSetting.Type)
+
+	parsed, err = bot.ParseTodo("每月15号还信用卡", parserNow)
+	assert.NoError(t, err)
+	assert.Equal(t, "FREQ=MONTHLY;BYMONTHDAY=15", parsed.RepeatSetting.RRule)
+}
+
+func TestParseTodoWithoutTime(t *testing.T) {
+	_, err := bot.ParseTodo("买牛奶", parserNow)
+	assert.ErrorIs(t, err, bot.ErrNoTimeFound)
+	_, err = bot.ParseTodo("明天下午3点", parserNow)
+	assert.ErrorIs(t, err, bot.ErrMissingContent)
+}
@@ -0,0 +1,32 @@
+package test
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	_ "todo-reminder/conf"
+	"todo-reminder/model"
+	"todo-reminder/repository/bsoncodec"
+)
+
+func TestValidateDependencies(t *testing.T) {
+	ctx := context.Background()
+	a := model.Todo{
+		Content: "a",
+		UserId:  "test_user_id",
+	}
+	assert.NoError(t, a.Create(ctx))
+	b := model.Todo{
+		Id:        bsoncodec.NewObjectId(),
+		Content:   "b",
+		UserId:    "test_user_id",
+		DependsOn: []bsoncodec.ObjectId{a.Id},
+	}
+	assert.NoError(t, b.ValidateDependencies(ctx))
+	assert.NoError(t, b.Upsert(ctx))
+	// a 依赖 b 会形成循环
+	a.DependsOn = []bsoncodec.ObjectId{b.Id}
+	assert.ErrorIs(t, a.ValidateDependencies(ctx), model.ErrDependencyCycle)
+	a.DependsOn = []bsoncodec.ObjectId{a.Id}
+	assert.ErrorIs(t, a.ValidateDependencies(ctx), model.ErrDependencyCycle)
+}
@@ -7,6 +7,7 @@ import (
 	"time"
 	_ "todo-reminder/conf"
 	"todo-reminder/model"
+	"todo-reminder/repository/bsoncodec"
 )
 
 func TestGenTodoRecordWithoutRemind(t *testing.T) {
@@ -530,3 +531,155 @@ func TestGenTodoRecordWithHolidayRepeatTimePassed(t *testing.T) {
 	err = td.GenNextRecord(ctx, td.Id, false)
 	assert.NoError(t, err)
 }
+
+func TestGenTodoRecordWithRemindOffsets(t *testing.T) {
+	ctx := context.Background()
+	td := model.Todo{
+		NeedRemind: true,
+		Content:    "test",
+		UserId:     "test_user_id",
+		RemindSetting: model.RemindSetting{
+			RemindAt:      time.Now().Add(time.Hour * 2),
+			IsRepeatable:  false,
+			RemindOffsets: []int64{86400, 3600, 0, 3600},
+		},
+	}
+	err := td.Create(ctx)
+	assert.NoError(t, err)
+}
+
+func TestGenReminders(t *testing.T) {
+	remindAt := time.Now().Add(time.Hour * 2)
+	reminders := model.GenReminders(remindAt, []int64{0, 86400, 3600, 3600})
+	assert.Len(t, reminders, 3)
+	// 提前一天的提醒已经错过
+	assert.Equal(t, int64(86400), reminders[0].Offset)
+	assert.True(t, reminders[0].HasBeenReminded)
+	assert.Equal(t, remindAt.Add(-time.Hour), reminders[1].RemindAt)
+	assert.False(t, reminders[1].HasBeenReminded)
+	assert.Equal(t, remindAt, reminders[2].RemindAt)
+	assert.Len(t, (&model.TodoRecord{Reminders: reminders}).GetDueReminders(remindAt.Add(-time.Minute*30)), 1)
+}
+
+func TestGroupTodoAssignees(t *testing.T) {
+	record := model.TodoRecord{
+		UserId:    "owner",
+		GroupId:   "test_group_id",
+		Assignees: model.GenAssignees([]string{"a", "b", "a"}),
+	}
+	assert.Len(t, record.Assignees, 2)
+	assert.Equal(t, []string{"a", "b"}, record.GetUndoneAssigneeIds())
+	record.Assignees[0].HasBeenDone = true
+	assert.Equal(t, []string{"b"}, record.GetUndoneAssigneeIds())
+	assert.True(t, record.IsOwner("owner"))
+	assert.True(t, record.IsAssignee("b"))
+	assert.False(t, record.IsAssignee("owner"))
+}
+
+func TestGetNextReminderAt(t *testing.T) {
+	remindAt := time.Now().Add(time.Hour * 2)
+	record := model.TodoRecord{
+		NeedRemind: true,
+		RemindAt:   remindAt,
+		Reminders:  model.GenReminders(remindAt, []int64{0, 3600}),
+	}
+	next, ok := record.GetNextReminderAt()
+	assert.True(t, ok)
+	assert.Equal(t, remindAt.Add(-time.Hour), next)
+	record.Reminders[0].HasBeenReminded = true
+	next, ok = record.GetNextReminderAt()
+	assert.True(t, ok)
+	assert.Equal(t, remindAt, next)
+	record.HasBeenDone = true
+	_, ok = record.GetNextReminderAt()
+	assert.False(t, ok)
+}
+
+func TestCatchUpPolicy(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	record := model.TodoRecord{
+		Content:       "test",
+		CatchUpPolicy: model.CATCH_UP_POLICY_ALL,
+	}
+	reminders := []model.Reminder{
+		{Offset: 3600, RemindAt: now.Add(-time.Hour * 2)},
+		{Offset: 0, RemindAt: now.Add(-time.Hour)},
+	}
+	assert.True(t, reminders[0].IsLate(now))
+	assert.Contains(t, record.FormatReminderMessage(reminders[1]), "迟到的提醒")
+	result, err := record.ApplyCatchUpPolicy(ctx, reminders, now)
+	assert.NoError(t, err)
+	assert.Len(t, result, 2)
+	// 未错过的提醒不受补发策略影响
+	onTime := []model.Reminder{{Offset: 0, RemindAt: now}}
+	record.CatchUpPolicy = model.CATCH_UP_POLICY_SKIP
+	result, err = record.ApplyCatchUpPolicy(ctx, onTime, now)
+	assert.NoError(t, err)
+	assert.Equal(t, onTime, result)
+	assert.Equal(t, "test", record.FormatReminderMessage(onTime[0]))
+}
+
+func TestChecklistProgress(t *testing.T) {
+	items := []model.ChecklistItem{
+		{Id: bsoncodec.NewObjectId(), Content: "a", IsChecked: true},
+		{Id: bsoncodec.NewObjectId(), Content: "b"},
+	}
+	record := model.TodoRecord{
+		Content:   "test",
+		Checklist: model.GenChecklist(items),
+	}
+	// 生成的记录中所有子任务都未完成
+	checked, total := record.GetChecklistProgress()
+	assert.Equal(t, 0, checked)
+	assert.Equal(t, 2, total)
+	record.Checklist[1].IsChecked = true
+	assert.Equal(t, "test（1/2）", record.FormatReminderMessage(model.Reminder{RemindAt: time.Now()}))
+	assert.Nil(t, model.GenChecklist(nil))
+}
+
+func TestOverdue(t *testing.T) {
+	remindAt := time.Now().Add(-time.Hour)
+	todo := model.Todo{
+		DueAt: remindAt.Add(time.Minute),
+	}
+	assert.Equal(t, remindAt.Add(time.Minute), todo.GetRecordDueAt(remindAt))
+	// 重复待办按提醒时间计算截止时间
+	todo.DueOffset = 1800
+	assert.Equal(t, remindAt.Add(30*time.Minute), todo.GetRecordDueAt(remindAt))
+	record := model.TodoRecord{
+		DueAt: todo.GetRecordDueAt(remindAt),
+	}
+	assert.True(t, record.IsOverdueAt(time.Now()))
+	record.HasBeenDone = true
+	assert.False(t, record.IsOverdueAt(time.Now()))
+	assert.False(t, (&model.TodoRecord{}).IsOverdueAt(time.Now()))
+
+	user := model.User{
+		OverdueDigest: model.OverdueDigest{IsEnabled: true, Time: "09:00"},
+		Timezone:      "Asia/Shanghai",
+	}
+	loc, _ := time.LoadLocation("Asia/Shanghai")
+	assert.Equal(t, "", user.GetOverdueDigestDate(time.Date(2023, 1, 2, 8, 59, 0, 0, loc)))
+	assert.Equal(t, "2023-01-02", user.GetOverdueDigestDate(time.Date(2023, 1, 2, 9, 0, 0, 0, loc)))
+	user.LastOverdueDigestDate = "2023-01-02"
+	assert.Equal(t, "", user.GetOverdueDigestDate(time.Date(2023, 1, 2, 20, 0, 0, 0, loc)))
+	assert.Error(t, model.OverdueDigest{IsEnabled: true, Time: "25:00"}.Validate())
+	assert.Error(t, model.OverdueDigest{IsEnabled: true, Time: "9:00"}.Validate())
+	user.OverdueDigest.Time = "9:00"
+	user.LastOverdueDigestDate = ""
+	assert.Equal(t, "2023-01-02", user.GetOverdueDigestDate(time.Date(2023, 1, 2, 10, 0, 0, 0, loc)))
+}
+
+func TestGenContentKeywords(t *testing.T) {
+	assert.Equal(t, []string{"买", "买牛", "牛", "牛奶", "奶", "开", "开会", "会"}, model.GenContentKeywords("买牛奶 at 9 开会"))
+	assert.Empty(t, model.GenContentKeywords("meeting"))
+	assert.Nil(t, model.GenKeywordCondition("  "))
+	// 中文关键词通过 keywords 匹配，英文关键词使用文本索引
+	assert.Equal(t, bsoncodec.M{
+		"keywords": bsoncodec.M{"$all": []string{"牛奶"}},
+		"content":  bsoncodec.M{"$regex": "牛奶", "$options": "i"},
+	}, model.GenKeywordCondition("牛奶"))
+	assert.Equal(t, bsoncodec.M{"$text": bsoncodec.M{"$search": "meeting"}}, model.GenKeywordCondition("meeting"))
+	assert.Len(t, model.GenKeywordCondition("牛奶 meeting")["$or"], 2)
+}
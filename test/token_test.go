@@ -0,0 +1,55 @@
+package test
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	_ "todo-reminder/conf"
+	"todo-reminder/model"
+	"todo-reminder/util"
+)
+
+func TestToken(t *testing.T) {
+	tokenStr, expiredAt, err := util.GenerateToken("10000", "63c7a1b2e4b0a1a2b3c4d5e6")
+	assert.NoError(t, err)
+	token, err := util.ParseToken(tokenStr)
+	assert.NoError(t, err)
+	assert.Equal(t, "10000", token.UserId)
+	assert.Equal(t, "63c7a1b2e4b0a1a2b3c4d5e6", token.SessionId)
+	assert.Equal(t, expiredAt.Unix(), token.ExpiredAt.Unix())
+	// 篡改签名
+	_, err = util.ParseToken(tokenStr[:len(tokenStr)-2] + "xx")
+	assert.Error(t, err)
+	// 旧格式的 token 无法通过校验
+	_, err = util.ParseToken("aGVsbG8=")
+	assert.Error(t, err)
+	assert.NotEqual(t, util.GenSecureToken(16), util.GenSecureToken(16))
+}
+
+func TestSession(t *testing.T) {
+	ctx := context.Background()
+	pair, err := model.CSession.Create(ctx, "10000", "test", "127.0.0.1")
+	assert.NoError(t, err)
+	token, err := util.ParseToken(pair.AccessToken)
+	assert.NoError(t, err)
+	assert.NoError(t, model.CSession.CheckActive(ctx, token.SessionId))
+	assert.True(t, strings.HasPrefix(pair.RefreshToken, token.SessionId+"."))
+
+	newPair, userId, err := model.CSession.Refresh(ctx, pair.RefreshToken)
+	assert.NoError(t, err)
+	assert.Equal(t, "10000", userId)
+	assert.NotEqual(t, pair.RefreshToken, newPair.RefreshToken)
+	// 重复使用旧的 refresh token 会注销会话
+	_, _, err = model.CSession.Refresh(ctx, pair.RefreshToken)
+	assert.ErrorIs(t, err, model.ErrInvalidRefreshToken)
+	assert.ErrorIs(t, model.CSession.CheckActive(ctx, token.SessionId), model.ErrSessionRevoked)
+	_, _, err = model.CSession.Refresh(ctx, newPair.RefreshToken)
+	assert.Error(t, err)
+
+	pair, err = model.CSession.Create(ctx, "10000", "test", "127.0.0.1")
+	assert.NoError(t, err)
+	token, _ = util.ParseToken(pair.AccessToken)
+	assert.NoError(t, model.CSession.RevokeAllByUserId(ctx, "10000", ""))
+	assert.ErrorIs(t, model.CSession.CheckActive(ctx, token.SessionId), model.ErrSessionRevoked)
+}
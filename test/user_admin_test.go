@@ -0,0 +1,46 @@
+package test
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	_ "todo-reminder/conf"
+	"todo-reminder/model"
+	"todo-reminder/util"
+)
+
+func TestUserAdmin(t *testing.T) {
+	ctx := context.Background()
+	userId := "test_user_admin"
+	user := model.User{
+		UserId: userId,
+	}
+	assert.NoError(t, user.UpsertWithoutPassword(ctx))
+	assert.True(t, user.IsEnabled)
+	assert.False(t, user.IsAdmin())
+	assert.True(t, model.CUser.IsActiveUser(ctx, userId))
+	assert.False(t, model.CUser.IsActiveUser(ctx, "test_user_not_exist"))
+
+	pair, err := model.CSession.Create(ctx, userId, "test", "127.0.0.1")
+	assert.NoError(t, err)
+	token, _ := util.ParseToken(pair.AccessToken)
+	// 禁用后会话失效，且不再收到提醒
+	assert.NoError(t, model.CUser.SetEnabled(ctx, userId, false))
+	assert.False(t, model.CUser.IsActiveUser(ctx, userId))
+	assert.ErrorIs(t, model.CSession.CheckActive(ctx, token.SessionId), model.ErrSessionRevoked)
+	// 同步好友不会重新启用用户
+	assert.NoError(t, user.UpsertWithoutPassword(ctx))
+	assert.False(t, user.IsEnabled)
+	assert.NoError(t, model.CUser.SetEnabled(ctx, userId, true))
+
+	assert.False(t, model.IsValidRole("root"))
+	assert.NoError(t, model.CUser.SetRole(ctx, userId, model.ROLE_ADMIN))
+	user, err = model.CUser.GetByUserId(ctx, userId)
+	assert.NoError(t, err)
+	assert.True(t, user.IsAdmin())
+	assert.NoError(t, model.CUser.SetRole(ctx, userId, model.ROLE_USER))
+
+	usage, err := model.CUser.GetUsage(ctx, userId)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), usage.ActiveSessions)
+}
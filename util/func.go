@@ -12,6 +12,7 @@ import (
 	"regexp"
 	"runtime"
 	"strings"
+	"sync"
 	"time"
 	"todo-reminder/constant"
 	"todo-reminder/log"
@@ -28,6 +29,10 @@ var (
 		time.Sunday:    7,
 	}
 
+	locations = sync.Map{}
+
+	clockRegexp = regexp.MustCompile(`^\d{2}:\d{2}$`)
+
 	randomStringPool = []rune{
 		'1', '2', '3', '4', '5', '6', '7', '8', '9', '0',
 		'A', 'B', 'C', 'D', 'E', 'F', 'G', 'H', 'I', 'G', 'K', 'L', 'M', 'N', 'O', 'P', 'Q', 'R', 'S', 'T', 'U', 'V', 'W', 'X', 'Y', 'Z',
@@ -49,22 +54,43 @@ func StrInArray(str string, arr *[]string) bool {
 
 func GetStartTimeOfYear(argTime time.Time) time.Time {
 	y, _, _ := argTime.Date()
-	return time.Date(y, 1, 1, 0, 0, 0, 0, time.Local)
+	return time.Date(y, 1, 1, 0, 0, 0, 0, argTime.Location())
 }
 
 func GetEndTimeOfYear(argTime time.Time) time.Time {
 	y, _, _ := argTime.Date()
-	return time.Date(y+1, 1, 1, 23, 59, 59, 999999999, time.Local).AddDate(0, 0, -1)
+	return time.Date(y+1, 1, 1, 23, 59, 59, 999999999, argTime.Location()).AddDate(0, 0, -1)
 }
 
 func GetStartTimeOfMonth(argTime time.Time) time.Time {
 	y, m, _ := argTime.Date()
-	return time.Date(y, m, 1, 0, 0, 0, 0, time.Local)
+	return time.Date(y, m, 1, 0, 0, 0, 0, argTime.Location())
 }
 
 func GetEndTimeOfMonth(argTime time.Time) time.Time {
 	y, m, _ := argTime.Date()
-	return time.Date(y, m+1, 0, 23, 59, 59, 999999999, time.Local)
+	return time.Date(y, m+1, 0, 23, 59, 59, 999999999, argTime.Location())
+}
+
+// LoadLocation 加载 IANA 时区，为空或无效时使用服务器所在时区
+func LoadLocation(name string) *time.Location {
+	if name == "" {
+		return time.Local
+	}
+	if loc, ok := locations.Load(name); ok {
+		return loc.(*time.Location)
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return time.Local
+	}
+	locations.Store(name, loc)
+	return loc
+}
+
+func IsValidTimezone(name string) bool {
+	_, err := time.LoadLocation(name)
+	return name != "" && err == nil
 }
 
 func PtrValue[T any](value T) *T {
@@ -73,6 +99,59 @@ func PtrValue[T any](value T) *T {
 	return v
 }
 
+func Unique[T comparable](arr []T) []T {
+	result := make([]T, 0, len(arr))
+	exists := make(map[T]bool, len(arr))
+	for _, item := range arr {
+		if exists[item] {
+			continue
+		}
+		exists[item] = true
+		result = append(result, item)
+	}
+	return result
+}
+
+// FormatDuration 将时长格式化为“1天2小时30分钟”的形式，精确到分钟
+func FormatDuration(d time.Duration) string {
+	var (
+		result  string
+		days    = int64(d / (24 * time.Hour))
+		hours   = int64(d % (24 * time.Hour) / time.Hour)
+		minutes = int64(d % time.Hour / time.Minute)
+	)
+	if days > 0 {
+		result += fmt.Sprintf("%d天", days)
+	}
+	if hours > 0 {
+		result += fmt.Sprintf("%d小时", hours)
+	}
+	if minutes > 0 || result == "" {
+		result += fmt.Sprintf("%d分钟", minutes)
+	}
+	return result
+}
+
+// IsValidClock 是否为 HH:mm 格式的时刻，小时和分钟都必须是两位数
+func IsValidClock(clock string) bool {
+	_, err := ParseClock(clock)
+	return err == nil && clockRegexp.MatchString(clock)
+}
+
+// ParseClock 将 HH:mm 解析为当天的第几分钟，兼容之前保存的 9:00 这种小时只有一位的时刻
+func ParseClock(clock string) (int, error) {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// GetMinuteOfDay t 是所在时区当天的第几分钟
+func GetMinuteOfDay(t time.Time) int {
+	return t.Hour()*60 + t.Minute()
+}
+
 func GetNextWeekday(t time.Time, weekday int) time.Time {
 	for weekdayMap[t.Weekday()] != weekday {
 		t = t.AddDate(0, 0, 1)
@@ -172,6 +251,13 @@ func ExtractUserId(ctx context.Context) string {
 	return ""
 }
 
+func ExtractSessionId(ctx context.Context) string {
+	if ginCtx, ok := ctx.(*gin.Context); ok {
+		return ginCtx.GetString(constant.GIN_KEY_SESSION_ID)
+	}
+	return ""
+}
+
 func FuncWithRecovery(fn func()) func() {
 	return func() {
 		defer func() {
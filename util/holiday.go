@@ -18,34 +18,34 @@ var (
 	}
 )
 
-// GetSolarHolidays 获取阳历节日，返回精确到天
-func GetSolarHolidays(year int) map[string]time.Time {
+// GetSolarHolidays 获取 loc 时区下的阳历节日，返回精确到天
+func GetSolarHolidays(year int, loc *time.Location) map[string]time.Time {
 	return map[string]time.Time{
-		"元旦":         time.Date(year, time.January, 1, 0, 0, 0, 0, time.Local),
-		"情人节":        time.Date(year, time.February, 14, 0, 0, 0, 0, time.Local),
-		"妇女节":        time.Date(year, time.March, 8, 0, 0, 0, 0, time.Local),
-		"愚人节":        time.Date(year, time.April, 1, 0, 0, 0, 0, time.Local),
-		"清明节":        time.Date(year, time.April, 5, 0, 0, 0, 0, time.Local),
-		"劳动节":        time.Date(year, time.March, 1, 0, 0, 0, 0, time.Local),
-		"青年节":        time.Date(year, time.March, 4, 0, 0, 0, 0, time.Local),
-		"儿童节":        time.Date(year, time.June, 1, 0, 0, 0, 0, time.Local),
-		"中国共产党成立纪念日": time.Date(year, time.July, 1, 0, 0, 0, 0, time.Local),
-		"建军节":        time.Date(year, time.August, 1, 0, 0, 0, 0, time.Local),
-		"教师节":        time.Date(year, time.September, 10, 0, 0, 0, 0, time.Local),
-		"国庆节":        time.Date(year, time.October, 1, 0, 0, 0, 0, time.Local),
-		"父亲节":        GetFatherDay(year),
-		"母亲节":        GetMotherDay(year),
-		"双十一":        time.Date(year, time.November, 11, 0, 0, 0, 0, time.Local),
-		"双十二":        time.Date(year, time.December, 12, 0, 0, 0, 0, time.Local),
-		"感恩节":        GetThanksgivingDay(year),
-		"圣诞节":        time.Date(year, time.December, 25, 0, 0, 0, 0, time.Local),
-		"万圣节":        time.Date(year, time.November, 1, 0, 0, 0, 0, time.Local),
+		"元旦":         time.Date(year, time.January, 1, 0, 0, 0, 0, loc),
+		"情人节":        time.Date(year, time.February, 14, 0, 0, 0, 0, loc),
+		"妇女节":        time.Date(year, time.March, 8, 0, 0, 0, 0, loc),
+		"愚人节":        time.Date(year, time.April, 1, 0, 0, 0, 0, loc),
+		"清明节":        time.Date(year, time.April, 5, 0, 0, 0, 0, loc),
+		"劳动节":        time.Date(year, time.March, 1, 0, 0, 0, 0, loc),
+		"青年节":        time.Date(year, time.March, 4, 0, 0, 0, 0, loc),
+		"儿童节":        time.Date(year, time.June, 1, 0, 0, 0, 0, loc),
+		"中国共产党成立纪念日": time.Date(year, time.July, 1, 0, 0, 0, 0, loc),
+		"建军节":        time.Date(year, time.August, 1, 0, 0, 0, 0, loc),
+		"教师节":        time.Date(year, time.September, 10, 0, 0, 0, 0, loc),
+		"国庆节":        time.Date(year, time.October, 1, 0, 0, 0, 0, loc),
+		"父亲节":        GetFatherDay(year, loc),
+		"母亲节":        GetMotherDay(year, loc),
+		"双十一":        time.Date(year, time.November, 11, 0, 0, 0, 0, loc),
+		"双十二":        time.Date(year, time.December, 12, 0, 0, 0, 0, loc),
+		"感恩节":        GetThanksgivingDay(year, loc),
+		"圣诞节":        time.Date(year, time.December, 25, 0, 0, 0, 0, loc),
+		"万圣节":        time.Date(year, time.November, 1, 0, 0, 0, 0, loc),
 	}
 }
 
 // GetFatherDay 获取指定年份的父亲节的日期，返回精确到天
-func GetFatherDay(year int) time.Time {
-	startTime := time.Date(year, time.June, 1, 0, 0, 0, 0, time.Local)
+func GetFatherDay(year int, loc *time.Location) time.Time {
+	startTime := time.Date(year, time.June, 1, 0, 0, 0, 0, loc)
 	for startTime.Weekday() != time.Sunday {
 		startTime = startTime.AddDate(0, 0, 1)
 	}
@@ -54,8 +54,8 @@ func GetFatherDay(year int) time.Time {
 }
 
 // GetMotherDay 获取指定年份的母亲节的日期，返回精确到天
-func GetMotherDay(year int) time.Time {
-	startTime := time.Date(year, time.May, 1, 0, 0, 0, 0, time.Local)
+func GetMotherDay(year int, loc *time.Location) time.Time {
+	startTime := time.Date(year, time.May, 1, 0, 0, 0, 0, loc)
 	for startTime.Weekday() != time.Sunday {
 		startTime = startTime.AddDate(0, 0, 1)
 	}
@@ -63,8 +63,8 @@ func GetMotherDay(year int) time.Time {
 }
 
 // GetThanksgivingDay 获取指定年份的感恩节的日期，返回精确到天
-func GetThanksgivingDay(year int) time.Time {
-	startTime := time.Date(year, time.November, 1, 0, 0, 0, 0, time.Local)
+func GetThanksgivingDay(year int, loc *time.Location) time.Time {
+	startTime := time.Date(year, time.November, 1, 0, 0, 0, 0, loc)
 	for startTime.Weekday() != time.Thursday {
 		startTime = startTime.AddDate(0, 0, 1)
 	}
@@ -0,0 +1,19 @@
+package util
+
+import (
+	"fmt"
+	"os"
+)
+
+var (
+	// InstanceId 当前进程的唯一标识，多个实例同时运行时用于区分租约的持有者
+	InstanceId = genInstanceId()
+)
+
+func genInstanceId() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return fmt.Sprintf("%s-%d-%s", hostname, os.Getpid(), GenRandomString(6))
+}
@@ -1,83 +1,220 @@
 package util
 
 import (
-	"bytes"
-	"crypto/aes"
-	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v4"
 	"github.com/spf13/viper"
+	"math/big"
 	"time"
 )
 
+const (
+	defaultTokenIssuer        = "todo-reminder"
+	defaultAccessTokenMinutes = 15
+)
+
 var (
-	encryptor cipher.Block
-	days      int
+	signingKeys    = map[string]signingKey{}
+	currentKeyId   string
+	accessTokenTTL time.Duration
+	tokenIssuer    string
 )
 
 type Token struct {
-	UserId    string    `json:"userId"`
-	CreatedAt time.Time `json:"createdAt"`
-	ExpiredAt time.Time `json:"expiredAt"`
+	UserId string
+	// 签发该 token 的会话，会话被注销后 token 失效
+	SessionId string
+	ExpiredAt time.Time
+}
+
+// SigningKeyConfig token.keys 中的一项，HS256 使用 secret，EdDSA 使用 base64 编码的 ed25519 密钥
+// 只用于校验旧 token 的 EdDSA 密钥可以只配置 publicKey
+type SigningKeyConfig struct {
+	Id         string `mapstructure:"id"`
+	Algorithm  string `mapstructure:"algorithm"`
+	Secret     string `mapstructure:"secret"`
+	PrivateKey string `mapstructure:"privateKey"`
+	PublicKey  string `mapstructure:"publicKey"`
+}
+
+type signingKey struct {
+	method    jwt.SigningMethod
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+type accessTokenClaims struct {
+	jwt.RegisteredClaims
+	SessionId string `json:"sid"`
 }
 
 func init() {
-	c, err := aes.NewCipher([]byte(viper.GetString("token.key")))
-	if err != nil {
+	var configs []SigningKeyConfig
+	if err := viper.UnmarshalKey("token.keys", &configs); err != nil {
 		panic(err)
 	}
-	encryptor = c
-	days = viper.GetInt("token.validDays")
-	if days == 0 {
-		panic("need token valid days")
+	for _, config := range configs {
+		key, err := parseSigningKey(config)
+		if err != nil {
+			panic(fmt.Sprintf("invalid signing key %s: %v", config.Id, err))
+		}
+		signingKeys[config.Id] = key
+	}
+	currentKeyId = viper.GetString("token.currentKeyId")
+	if key, ok := signingKeys[currentKeyId]; !ok || key.signKey == nil {
+		panic("need current signing key")
+	}
+	minutes := viper.GetInt("token.accessTokenMinutes")
+	if minutes <= 0 {
+		minutes = defaultAccessTokenMinutes
+	}
+	accessTokenTTL = time.Duration(minutes) * time.Minute
+	tokenIssuer = viper.GetString("token.issuer")
+	if tokenIssuer == "" {
+		tokenIssuer = defaultTokenIssuer
 	}
-}
-func pkcs7Padding(data []byte, blockSize int) []byte {
-	//判断缺少几位长度。最少1，最多 blockSize
-	padding := blockSize - len(data)%blockSize
-	//补足位数。把切片[]byte{byte(padding)}复制padding个
-	padText := bytes.Repeat([]byte{byte(padding)}, padding)
-	return append(data, padText...)
-}
-
-func pkcs7UnPadding(data []byte) []byte {
-	length := len(data)
-	//获取填充的个数
-	unPadding := int(data[length-1])
-	return data[:(length - unPadding)]
 }
 
-func Encrypt(data []byte) []byte {
-	data = pkcs7Padding(data, encryptor.BlockSize())
-	encrypted := make([]byte, len(data))
-	cipher.NewCBCEncrypter(encryptor, []byte(viper.GetString("token.key"))[:encryptor.BlockSize()]).CryptBlocks(encrypted, data)
-	return encrypted
+func parseSigningKey(config SigningKeyConfig) (signingKey, error) {
+	if config.Id == "" {
+		return signingKey{}, errors.New("empty key id")
+	}
+	switch config.Algorithm {
+	case jwt.SigningMethodHS256.Alg():
+		// HS256 的密钥至少 256 位
+		if len(config.Secret) < 32 {
+			return signingKey{}, errors.New("secret is too short")
+		}
+		return signingKey{
+			method:    jwt.SigningMethodHS256,
+			signKey:   []byte(config.Secret),
+			verifyKey: []byte(config.Secret),
+		}, nil
+	case jwt.SigningMethodEdDSA.Alg():
+		key := signingKey{
+			method: jwt.SigningMethodEdDSA,
+		}
+		if config.PrivateKey != "" {
+			data, err := base64.StdEncoding.DecodeString(config.PrivateKey)
+			if err != nil {
+				return signingKey{}, err
+			}
+			// 兼容只配置 32 字节种子的情况
+			if len(data) == ed25519.SeedSize {
+				data = ed25519.NewKeyFromSeed(data)
+			}
+			if len(data) != ed25519.PrivateKeySize {
+				return signingKey{}, errors.New("invalid ed25519 private key")
+			}
+			privateKey := ed25519.PrivateKey(data)
+			key.signKey = privateKey
+			key.verifyKey = privateKey.Public()
+		}
+		if config.PublicKey != "" {
+			data, err := base64.StdEncoding.DecodeString(config.PublicKey)
+			if err != nil {
+				return signingKey{}, err
+			}
+			if len(data) != ed25519.PublicKeySize {
+				return signingKey{}, errors.New("invalid ed25519 public key")
+			}
+			key.verifyKey = ed25519.PublicKey(data)
+		}
+		if key.verifyKey == nil {
+			return signingKey{}, errors.New("missing ed25519 key")
+		}
+		return key, nil
+	}
+	return signingKey{}, fmt.Errorf("unsupported algorithm %s", config.Algorithm)
 }
 
-func Decrypt(data []byte) []byte {
-	decrypted := make([]byte, len(data))
-	cipher.NewCBCDecrypter(encryptor, []byte(viper.GetString("token.key"))[:encryptor.BlockSize()]).CryptBlocks(decrypted, data)
-	decrypted = pkcs7UnPadding(decrypted)
-	return decrypted
+func GetAccessTokenTTL() time.Duration {
+	return accessTokenTTL
 }
 
-func GenerateToken(userId string) string {
-	token := Token{
-		UserId:    userId,
-		CreatedAt: time.Now(),
-		ExpiredAt: time.Now().AddDate(0, 0, days),
+// GenerateToken 使用当前密钥签发短期有效的 access token，kid 为密钥 id
+func GenerateToken(userId, sessionId string) (string, time.Time, error) {
+	key := signingKeys[currentKeyId]
+	now := time.Now()
+	expiredAt := now.Add(accessTokenTTL)
+	claims := accessTokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    tokenIssuer,
+			Subject:   userId,
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiredAt),
+			ID:        GenSecureToken(16),
+		},
+		SessionId: sessionId,
 	}
-	return base64.StdEncoding.EncodeToString(Encrypt([]byte(MarshalToJson(token))))
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = currentKeyId
+	tokenStr, err := token.SignedString(key.signKey)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return tokenStr, expiredAt, nil
 }
 
+// ParseToken 校验签名、签发者和有效期，密钥从配置中移除后由其签发的 token 无法通过校验
 func ParseToken(tokenStr string) (*Token, error) {
-	data, err := base64.StdEncoding.DecodeString(tokenStr)
+	claims := accessTokenClaims{}
+	_, err := jwt.ParseWithClaims(tokenStr, &claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, ok := signingKeys[kid]
+		if !ok {
+			return nil, errors.New("unknown key id")
+		}
+		// 防止使用与密钥不符的算法伪造签名
+		if token.Method.Alg() != key.method.Alg() {
+			return nil, errors.New("unexpected signing method")
+		}
+		return key.verifyKey, nil
+	})
 	if err != nil {
-		return nil, err
+		return nil, errors.New("invalid token")
+	}
+	if !claims.VerifyIssuer(tokenIssuer, true) || claims.Subject == "" || claims.SessionId == "" || claims.ExpiresAt == nil {
+		return nil, errors.New("invalid token")
+	}
+	return &Token{
+		UserId:    claims.Subject,
+		SessionId: claims.SessionId,
+		ExpiredAt: claims.ExpiresAt.Time,
+	}, nil
+}
+
+// GenSecureToken 生成 size 字节的随机数并使用 base64url 编码
+func GenSecureToken(size int) string {
+	data := make([]byte, size)
+	if _, err := rand.Read(data); err != nil {
+		panic(err)
 	}
-	token := MustUnmarshalFromJson[Token](string(Decrypt(data)))
-	if token.CreatedAt.Unix() > 0 && token.ExpiredAt.After(time.Now()) {
-		return &token, nil
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// HashToken 只在数据库中保存 token 的摘要
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// GenSecureDigits 生成 length 位的随机数字，用于验证码
+func GenSecureDigits(length int) string {
+	result := make([]byte, length)
+	for i := range result {
+		n, err := rand.Int(rand.Reader, big.NewInt(10))
+		if err != nil {
+			panic(err)
+		}
+		result[i] = byte('0' + n.Int64())
 	}
-	return nil, errors.New("invalid token")
+	return string(result)
 }